@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/service"
+	"github.com/kartalenka7/project_gophermart/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// Создание администратора из командной строки
+func createAdmin(log *logrus.Logger, args []string) error {
+	cfg, admin, err := config.GetAdminConfig(log, args, os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	storage, err := storage.NewStorage(ctx, cfg.Database, log)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	return service.CreateAdmin(ctx, storage, log, cfg, admin)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/kartalenka7/project_gophermart/internal/config"
@@ -15,6 +16,15 @@ import (
 func main() {
 	log := logger.InitLog()
 
+	// gophermart admin ... - создание администратора
+	if len(os.Args) > 1 && os.Args[1] == "admin" {
+		if err := createAdmin(log, os.Args[2:]); err != nil {
+			log.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	cfg, err := config.GetConfig(log)
 	if err != nil {
 		log.Error(err.Error())
@@ -28,8 +38,15 @@ func main() {
 	if err != nil {
 		return
 	}
-	service := service.NewService(ctx, storage, log, cfg.AccrualSys)
-	router := handlers.NewRouter(service, log)
+	// фоновые горутины сервиса работают все время жизни процесса,
+	// таймаут нужен только для подключения к бд
+	bgCtx, bgCancel := context.WithCancel(context.Background())
+	defer bgCancel()
+	service, err := service.NewService(bgCtx, storage, log, cfg)
+	if err != nil {
+		return
+	}
+	router := handlers.NewRouter(service, log, cfg)
 
 	err = http.ListenAndServe(cfg.Server, router)
 	if err != nil {
@@ -1,9 +1,15 @@
 package config
 
 import (
+	"bufio"
 	"flag"
+	"io"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env"
+	"github.com/kartalenka7/project_gophermart/internal/model"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,6 +21,63 @@ type Config struct {
 	Server     string `env:"RUN_ADDRESS" envDefault:"localhost:8080"`
 	Database   string `env:"DATABASE_URI"`
 	AccrualSys string `env:"ACCRUAL_SYSTEM_ADDRESS" envDefault:"http://localhost:8080/"`
+
+	// хэширование паролей: bcrypt или argon2id
+	PasswordHash  string `env:"PASSWORD_HASH" envDefault:"bcrypt"`
+	BcryptCost    int    `env:"BCRYPT_COST" envDefault:"14"`
+	Argon2Time    uint   `env:"ARGON2_TIME" envDefault:"1"`
+	Argon2Memory  uint   `env:"ARGON2_MEMORY" envDefault:"65536"`
+	Argon2Threads uint   `env:"ARGON2_THREADS" envDefault:"2"`
+
+	// корректировки баланса больше порога (в рублях) утверждает второй администратор
+	AdjustmentThreshold float64 `env:"ADJUSTMENT_APPROVAL_THRESHOLD" envDefault:"1000"`
+
+	// выдавать токен в cookie в дополнение к заголовку Authorization
+	SessionCookies bool `env:"SESSION_COOKIES" envDefault:"false"`
+	// до этого момента (RFC 3339) принимаются токены, выданные до появления сессий;
+	// если не задано, такие токены отклоняются и пользователи входят заново
+	LegacyTokensUntil time.Time `env:"LEGACY_TOKENS_UNTIL"`
+
+	// время жизни токена первого шага входа с 2FA
+	TOTPChallengeTTL time.Duration `env:"TOTP_CHALLENGE_TTL" envDefault:"5m"`
+	// после стольких неверных кодов подряд вход с 2FA блокируется на TOTP_LOCKOUT
+	TOTPMaxAttempts int           `env:"TOTP_MAX_ATTEMPTS" envDefault:"5"`
+	TOTPLockout     time.Duration `env:"TOTP_LOCKOUT" envDefault:"15m"`
+	// списания больше порога (в рублях) требуют кода 2FA
+	StepUpThreshold float64 `env:"STEP_UP_WITHDRAW_THRESHOLD" envDefault:"1000"`
+
+	// предельный размер тела запроса в байтах, для загрузки заказа - отдельный предел
+	MaxBodySize      int64 `env:"MAX_BODY_SIZE" envDefault:"65536"`
+	MaxOrderBodySize int64 `env:"MAX_ORDER_BODY_SIZE" envDefault:"128"`
+
+	// поток событий: период heartbeat, сколько пропущенных событий отдавать
+	// при переподключении и сколько их хранить
+	SSEHeartbeat     time.Duration `env:"SSE_HEARTBEAT" envDefault:"15s"`
+	EventReplayLimit int           `env:"EVENT_REPLAY_LIMIT" envDefault:"100"`
+	EventRetention   time.Duration `env:"EVENT_RETENTION" envDefault:"24h"`
+
+	// срок удержания баллов по умолчанию и наибольший срок, который может запросить клиент
+	HoldTTL    time.Duration `env:"HOLD_TTL" envDefault:"15m"`
+	HoldMaxTTL time.Duration `env:"HOLD_MAX_TTL" envDefault:"24h"`
+
+	// через сколько месяцев после начисления сгорают баллы, 0 - не сгорают
+	PointsExpiryMonths int `env:"POINTS_EXPIRY_MONTHS" envDefault:"12"`
+
+	// сколько рублей и сколько переводов пользователь может отправить за сутки, 0 - без ограничения
+	TransferDailyLimit float64 `env:"TRANSFER_DAILY_LIMIT" envDefault:"5000"`
+	TransferDailyCount int     `env:"TRANSFER_DAILY_COUNT" envDefault:"10"`
+
+	// лимиты списаний по умолчанию в рублях: минимум и максимум одного списания,
+	// максимум за скользящие сутки и 30 дней, наибольшая доля стоимости заказа; 0 - без ограничения
+	WithdrawMin              float64 `env:"WITHDRAW_MIN" envDefault:"0"`
+	WithdrawMax              float64 `env:"WITHDRAW_MAX" envDefault:"0"`
+	WithdrawDailyLimit       float64 `env:"WITHDRAW_DAILY_LIMIT" envDefault:"0"`
+	Withdraw30DaysLimit      float64 `env:"WITHDRAW_30D_LIMIT" envDefault:"0"`
+	WithdrawMaxOrderFraction float64 `env:"WITHDRAW_MAX_ORDER_FRACTION" envDefault:"0"`
+
+	// проверять запросы по спецификации OpenAPI, ответы - только в тестах
+	OpenAPIValidation        bool `env:"OPENAPI_VALIDATION" envDefault:"false"`
+	OpenAPIValidateResponses bool `env:"OPENAPI_VALIDATE_RESPONSES" envDefault:"false"`
 }
 
 var (
@@ -60,3 +123,51 @@ func GetConfig(log *logrus.Logger) (Config, error) {
 	log.WithFields(logrus.Fields{"cfg": cfg}).Info("Итоговая конфигурация")
 	return cfg, err
 }
+
+// Конфигурация команды создания администратора:
+// ADMIN_PASSWORD=<пароль> gophermart admin -login <логин> [-d <строка соединения с бд>].
+// Без ADMIN_PASSWORD пароль читается первой строкой из stdin. Флаг -password оставлен
+// для совместимости: пароль в аргументах виден в списке процессов и истории команд
+func GetAdminConfig(log *logrus.Logger, args []string, stdin io.Reader) (Config, model.User, error) {
+	var cfg Config
+	var admin model.User
+	var dbFlag string
+
+	err := env.Parse(&cfg)
+	if err != nil {
+		return Config{}, model.User{}, err
+	}
+
+	flags := flag.NewFlagSet("admin", flag.ContinueOnError)
+	flags.StringVar(&dbFlag, "d", "", "Database connections")
+	flags.StringVar(&admin.Login, "login", "", "Admin login")
+	flags.StringVar(&admin.Password, "password", "", "Admin password (deprecated, use ADMIN_PASSWORD)")
+	if err = flags.Parse(args); err != nil {
+		return Config{}, model.User{}, err
+	}
+
+	if password := os.Getenv("ADMIN_PASSWORD"); password != "" {
+		admin.Password = password
+	} else if admin.Password != "" {
+		log.Warn("Пароль администратора передан флагом -password, используйте ADMIN_PASSWORD")
+	} else if admin.Login != "" {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return Config{}, model.User{}, err
+		}
+		admin.Password = strings.TrimRight(line, "\r\n")
+	}
+
+	if dbFlag != "" {
+		cfg.Database = dbFlag
+	}
+	if cfg.Database == "" {
+		cfg.Database = database
+	}
+
+	if admin.Login == "" || admin.Password == "" {
+		log.Error("Не заданы логин или пароль администратора")
+		return Config{}, model.User{}, model.ErrWrongRequest
+	}
+	return cfg, admin, nil
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+func (s server) createAdjustment(rw http.ResponseWriter, r *http.Request) {
+	var adj model.Adjustment
+
+	s.log.Info("Создание корректировки баланса")
+	admin, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+
+	if err := utils.DecodeJSON(r.Body, &adj); err != nil {
+		s.log.Error(err.Error())
+		s.writeError(rw, r, err)
+		return
+	}
+
+	adj, err := s.service.CreateAdjustment(r.Context(), adj, admin)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+
+	// корректировка ждет утверждения вторым администратором
+	if adj.Status == model.AdjustmentPending {
+		s.writeJSON(rw, r, http.StatusAccepted, adj)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusCreated, adj)
+}
+
+func (s server) getAdjustments(rw http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	s.log.WithFields(logrus.Fields{"status": status}).Info("Получение списка корректировок")
+
+	adjustments, err := s.service.GetAdjustments(r.Context(), status)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	if adjustments == nil {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, adjustments)
+}
+
+func (s server) approveAdjustment(rw http.ResponseWriter, r *http.Request) {
+	s.decideAdjustment(rw, r, true)
+}
+
+func (s server) rejectAdjustment(rw http.ResponseWriter, r *http.Request) {
+	s.decideAdjustment(rw, r, false)
+}
+
+func (s server) decideAdjustment(rw http.ResponseWriter, r *http.Request, approve bool) {
+	admin, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.writeError(rw, r, model.ErrAdjustmentNotFound)
+		return
+	}
+
+	adj, err := s.service.DecideAdjustment(r.Context(), id, admin, approve)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, adj)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+func (s server) blockUser(rw http.ResponseWriter, r *http.Request) {
+	s.setUserBlocked(rw, r, true)
+}
+
+func (s server) unblockUser(rw http.ResponseWriter, r *http.Request) {
+	s.setUserBlocked(rw, r, false)
+}
+
+func (s server) setUserBlocked(rw http.ResponseWriter, r *http.Request, blocked bool) {
+	login := chi.URLParam(r, "login")
+	s.log.WithFields(logrus.Fields{
+		"user":    login,
+		"blocked": blocked,
+	}).Info("Блокировка пользователя администратором")
+
+	if err := s.service.BlockUser(r.Context(), login, blocked); err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (s server) requeueOrder(rw http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+	// обработанный заказ отправляется повторно только явно: разница начисления
+	// будет доначислена или списана
+	reverify := r.URL.Query().Get("reverify") == "true"
+	s.log.WithFields(logrus.Fields{
+		"number":   number,
+		"reverify": reverify,
+	}).Info("Повторная обработка заказа")
+
+	if err := s.service.RequeueOrder(r.Context(), number, reverify); err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	rw.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+func (s server) createAPIKey(rw http.ResponseWriter, r *http.Request) {
+	var key model.APIKey
+
+	if err := utils.DecodeJSON(r.Body, &key); err != nil {
+		s.log.Error(err.Error())
+		s.writeError(rw, r, err)
+		return
+	}
+	s.log.WithFields(logrus.Fields{"partner": key.Partner}).Info("Создание API ключа")
+
+	key, err := s.service.CreateAPIKey(r.Context(), key)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusCreated, key)
+}
+
+func (s server) getAPIKeys(rw http.ResponseWriter, r *http.Request) {
+	keys, err := s.service.GetAPIKeys(r.Context())
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	if keys == nil {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, keys)
+}
+
+func (s server) revokeAPIKey(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.writeError(rw, r, model.ErrAPIKeyNotFound)
+		return
+	}
+
+	if err = s.service.RevokeAPIKey(r.Context(), id); err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
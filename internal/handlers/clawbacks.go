@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Заказы, начисление по которым система расчета пересмотрела в меньшую
+// сторону. status=open - только с непогашенным долгом пользователя
+func (s server) getClawbacks(rw http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "open" {
+		s.writeError(rw, r, fmt.Errorf("%w: status must be open", model.ErrWrongRequest))
+		return
+	}
+	s.log.WithFields(logrus.Fields{"status": status}).Info("Получение списка пересмотренных начислений")
+
+	clawbacks, err := s.service.GetClawbacks(r.Context(), status == "open")
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	if clawbacks == nil {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, clawbacks)
+}
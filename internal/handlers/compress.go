@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+const (
+	// ответы меньше порога не сжимаются: выигрыш меньше накладных расходов
+	compressMinSize = 1024
+	// предел размера распакованного тела запроса, защита от gzip-бомб
+	maxDecompressedSize = 1 << 20
+)
+
+// Поддерживаемые кодировки ответа в порядке предпочтения
+var responseEncodings = []string{"gzip", "deflate"}
+
+// Обработка запросов с поддержкой сжатия данных: распаковка тела запроса
+// и сжатие ответа по Accept-Encoding
+func gzipHandle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := decompressRequest(r); err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Подменить тело запроса на распаковывающий поток. Тело не читается целиком:
+// хэндлер получит ошибку, как только распакованные данные превысят предел
+func decompressRequest(r *http.Request) error {
+	var body io.ReadCloser
+	switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return badRequest(err)
+		}
+		body = gz
+	case "deflate":
+		body = flate.NewReader(r.Body)
+	default:
+		return model.ErrUnsupportedEncoding
+	}
+
+	r.Body = &limitedBody{
+		reader: body,
+		left:   maxDecompressedSize,
+		close:  []io.Closer{body, r.Body},
+	}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return nil
+}
+
+// Ограничить размер тела запроса, при превышении - 413.
+// Предел не больше нуля - размер не ограничивается
+func limitBody(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limit <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > limit {
+				writeProblem(w, r, model.ErrBodyTooLarge)
+				return
+			}
+			r.Body = &limitedBody{
+				reader: r.Body,
+				left:   limit,
+				close:  []io.Closer{r.Body},
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Поток с ограничением размера, при превышении возвращает model.ErrBodyTooLarge
+type limitedBody struct {
+	reader io.Reader
+	left   int64
+	close  []io.Closer
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		// данные кончились ровно на пределе - это не превышение
+		var one [1]byte
+		if n, _ := b.reader.Read(one[:]); n == 0 {
+			return 0, io.EOF
+		}
+		return 0, model.ErrBodyTooLarge
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.reader.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	var err error
+	for _, c := range b.close {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Выбрать кодировку ответа по заголовку Accept-Encoding с учетом q-значений.
+// Пустая строка - ответ не сжимается
+func negotiateEncoding(accept string) string {
+	if accept == "" {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		weights[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range responseEncodings {
+		q, ok := weights[enc]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// Сжимаются только текстовые форматы. text/event-stream отдается
+// без сжатия, чтобы события не задерживались в буфере компрессора
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mt == "text/event-stream":
+		return false
+	case strings.HasPrefix(mt, "text/"),
+		mt == "application/json",
+		strings.HasSuffix(mt, "+json"),
+		mt == "application/xml",
+		mt == "application/x-ndjson",
+		mt == "application/x-ofx":
+		return true
+	}
+	return false
+}
+
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Сжимающий ResponseWriter. Первые compressMinSize байт ответа накапливаются,
+// чтобы решить, стоит ли его сжимать
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      bytes.Buffer
+	decided  bool
+	writer   flushWriter
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.decided {
+		return cw.write(b)
+	}
+
+	cw.buf.Write(b)
+	if cw.buf.Len() >= compressMinSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (cw *compressWriter) write(b []byte) (int, error) {
+	if cw.writer != nil {
+		return cw.writer.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Отправить заголовки и накопленные данные, включив сжатие, если ответ
+// достаточно большой и его тип сжимаемый
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	header := cw.Header()
+	if large && header.Get("Content-Encoding") == "" &&
+		cw.status != http.StatusNoContent && cw.status != http.StatusNotModified &&
+		compressible(header.Get("Content-Type")) {
+
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		if cw.encoding == "gzip" {
+			cw.writer = gzip.NewWriter(cw.ResponseWriter)
+		} else {
+			fw, err := flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+			if err != nil {
+				return err
+			}
+			cw.writer = fw
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+	_, err := cw.write(cw.buf.Bytes())
+	cw.buf.Reset()
+	return err
+}
+
+// Flush отправляет клиенту все записанные данные, в том числе из компрессора
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if err := cw.decide(cw.buf.Len() >= compressMinSize); err != nil {
+			return
+		}
+	}
+	if cw.writer != nil {
+		cw.writer.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Завершить ответ: короткие ответы отправляются без сжатия
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			// хэндлер ничего не записал
+			return nil
+		}
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+	if cw.writer != nil {
+		return cw.writer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{accept: "", want: ""},
+		{accept: "gzip, deflate, br", want: "gzip"},
+		{accept: "deflate", want: "deflate"},
+		{accept: "gzip;q=0.5, deflate;q=0.8", want: "deflate"},
+		{accept: "gzip;q=0, identity", want: ""},
+		{accept: "*", want: "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiateEncoding(tt.accept))
+		})
+	}
+}
+
+func TestGzipHandle(t *testing.T) {
+	echo := gzipHandle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeProblem(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+
+	compress := func(data []byte) *bytes.Buffer {
+		buf := bytes.NewBuffer(nil)
+		gz := gzip.NewWriter(buf)
+		gz.Write(data)
+		gz.Close()
+		return buf
+	}
+	large := bytes.Repeat([]byte("a"), compressMinSize*2)
+
+	tests := []struct {
+		name         string
+		body         *bytes.Buffer
+		gzipped      bool
+		wantStatus   int
+		wantEncoding string
+	}{
+		{name: "short response is not compressed", body: bytes.NewBufferString("{}"), wantStatus: http.StatusOK},
+		{name: "large response is compressed", body: bytes.NewBuffer(large), wantStatus: http.StatusOK, wantEncoding: "gzip"},
+		{name: "gzipped request", body: compress(large), gzipped: true, wantStatus: http.StatusOK, wantEncoding: "gzip"},
+		{
+			name:       "gzip bomb",
+			body:       compress(bytes.Repeat([]byte("a"), maxDecompressedSize+1)),
+			gzipped:    true,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", tt.body)
+			r.Header.Set("Accept-Encoding", "gzip")
+			if tt.gzipped {
+				r.Header.Set("Content-Encoding", "gzip")
+			}
+			w := httptest.NewRecorder()
+			echo.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantEncoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			if tt.wantEncoding != "gzip" {
+				return
+			}
+			gz, err := gzip.NewReader(w.Body)
+			require.NoError(t, err)
+			body, err := io.ReadAll(gz)
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(string(body), "aaaa"))
+			assert.Len(t, body, len(large))
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Установить ETag ответа по версии данных пользователя, не формируя сам ответ.
+// Возвращает true, если у клиента актуальная версия и ему уже отправлен 304
+func (s server) notModified(rw http.ResponseWriter, r *http.Request, login string, resource string) bool {
+	version, err := s.service.GetDataVersion(r.Context(), login)
+	if err != nil {
+		// без версии ответ просто формируется заново
+		s.log.Error(err.Error())
+		return false
+	}
+
+	etag := dataETag(login, resource, version)
+	rw.Header().Set("ETag", etag)
+	rw.Header().Set("Cache-Control", "private")
+	if !matchETag(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"login":    login,
+		"resource": resource,
+	}).Info("Данные не изменились")
+	rw.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ETag различается для разных пользователей на одном адресе, например
+// для партнеров, передающих логин в заголовке
+func dataETag(login string, resource string, version int64) string {
+	sum := sha256.Sum256([]byte(login))
+	return fmt.Sprintf(`"%s-%x-%d"`, resource, sum[:4], version)
+}
+
+// Ресурс ETag для ответа, зависящего от параметров запроса: одна версия данных
+// с разными параметрами дает разные ответы, поэтому и теги должны различаться.
+// Параметры передаются в каноническом виде, чтобы одинаковые запросы,
+// записанные по-разному, получали один тег
+func etagResource(resource string, params ...string) string {
+	if len(params) == 0 {
+		return resource
+	}
+	sum := sha256.Sum256([]byte(strings.Join(params, "&")))
+	return fmt.Sprintf("%s-%x", resource, sum[:4])
+}
+
+// Проверить If-None-Match: список тегов через запятую или *,
+// теги сравниваются без учета признака W/
+func matchETag(ifNoneMatch string, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
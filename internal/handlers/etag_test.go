@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchETag(t *testing.T) {
+	etag := dataETag("user", "orders", 3)
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{name: "empty", ifNoneMatch: "", want: false},
+		{name: "same", ifNoneMatch: etag, want: true},
+		{name: "weak", ifNoneMatch: "W/" + etag, want: true},
+		{name: "list", ifNoneMatch: `"other", ` + etag, want: true},
+		{name: "any", ifNoneMatch: "*", want: true},
+		{name: "old version", ifNoneMatch: dataETag("user", "orders", 2), want: false},
+		{name: "other user", ifNoneMatch: dataETag("admin", "orders", 3), want: false},
+		{name: "other resource", ifNoneMatch: dataETag("user", "balance", 3), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchETag(tt.ifNoneMatch, etag))
+		})
+	}
+}
+
+func TestGetBalanceAtETag(t *testing.T) {
+	service := &fakeService{users: map[string]model.User{"user": {Login: "user"}}}
+	router := newTestRouter(service)
+	token := testToken(t, "user", model.RoleUser)
+
+	get := func(query string, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/user/balance?"+query, nil)
+		r.Header.Set("Authorization", token)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return serve(router, r)
+	}
+
+	current := get("", "")
+	require.Equal(t, http.StatusOK, current.Code)
+	currentTag := current.Header().Get("ETag")
+
+	// тег текущего баланса не подходит к балансу на момент времени
+	past := get("at=2024-03-01T12:00:00Z", currentTag)
+	require.Equal(t, http.StatusOK, past.Code)
+	assert.True(t, time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC).Equal(service.balanceAt))
+	pastTag := past.Header().Get("ETag")
+	assert.NotEqual(t, currentTag, pastTag)
+
+	// тот же момент в другой записи получает тот же тег, другой момент - другой
+	assert.Equal(t, http.StatusNotModified, get("at=2024-03-01T15:00:00%2B03:00", pastTag).Code)
+	assert.Equal(t, http.StatusOK, get("at=2024-03-02T12:00:00Z", pastTag).Code)
+	assert.Equal(t, http.StatusOK, get("", pastTag).Code)
+
+	assert.Equal(t, http.StatusBadRequest, get("at=yesterday", "").Code)
+}
+
+func TestGetBalanceExpiryETag(t *testing.T) {
+	service := &fakeService{users: map[string]model.User{"user": {Login: "user"}}}
+	token := testToken(t, "user", model.RoleUser)
+	get := func(router http.Handler, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/user/balance", nil)
+		r.Header.Set("Authorization", token)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return serve(router, r)
+	}
+
+	plain := get(newTestRouter(service), "")
+	require.Equal(t, http.StatusOK, plain.Code)
+	plainTag := plain.Header().Get("ETag")
+
+	// при сгорании баллов тег зависит от дня, тег без дня не подходит
+	router := NewRouter(service, logger.InitLog(), config.Config{PointsExpiryMonths: 12})
+	expiry := get(router, plainTag)
+	require.Equal(t, http.StatusOK, expiry.Code)
+	expiryTag := expiry.Header().Get("ETag")
+	assert.NotEqual(t, plainTag, expiryTag)
+	today := time.Now().UTC().Format("2006-01-02")
+	assert.Equal(t, dataETag("user", etagResource("balance", "expiry="+today), 1), expiryTag)
+	assert.Equal(t, http.StatusNotModified, get(router, expiryTag).Code)
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// интервал переподключения, который рекомендуется клиенту, мс
+const sseRetry = 3000
+
+// Поток событий по счету пользователя в формате Server-Sent Events.
+// Клиент возобновляет поток с заголовком Last-Event-ID
+func (s server) streamEvents(rw http.ResponseWriter, r *http.Request) {
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		s.writeError(rw, r, errors.New("streaming is not supported"))
+		return
+	}
+
+	var lastID int64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil || id < 0 {
+			s.writeError(rw, r, fmt.Errorf("%w: invalid Last-Event-ID", model.ErrWrongRequest))
+			return
+		}
+		lastID = id
+	}
+
+	replay, live, cancel, err := s.service.SubscribeEvents(r.Context(), login, lastID)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	defer cancel()
+
+	s.log.WithFields(logrus.Fields{
+		"user":   login,
+		"lastID": lastID,
+		"replay": len(replay),
+	}).Info("Подключен поток событий")
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	// отключить буферизацию ответа в nginx
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+
+	if _, err = fmt.Fprintf(rw, "retry: %d\n\n", sseRetry); err != nil {
+		return
+	}
+	sent := lastID
+	for _, event := range replay {
+		if err = writeEvent(rw, event); err != nil {
+			return
+		}
+		sent = event.ID
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(s.sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-live:
+			// подписчик отстал и отключен, клиент переподключится с Last-Event-ID
+			if !ok {
+				return
+			}
+			if event.ID <= sent {
+				continue
+			}
+			if err = writeEvent(rw, event); err != nil {
+				return
+			}
+			sent = event.ID
+			flusher.Flush()
+		case <-heartbeat.C:
+			// ошибка записи - клиент отключился
+			if _, err = fmt.Fprint(rw, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeEvent(rw http.ResponseWriter, event model.Event) error {
+	_, err := fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data)
+	return err
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// сервис с пользователями в памяти, вызовы остальных методов паникуют
+type fakeService struct {
+	ServiceInterface
+	users map[string]model.User
+	// действующие API ключи партнеров
+	keys map[string]model.APIKey
+	// логин, с которым хэндлер обратился к сервису
+	login string
+	// номера заказов, переданные в загрузку пакетом
+	numbers []string
+	// момент, на который запрошен баланс
+	balanceAt time.Time
+	// кто отменяет списание
+	reversalBy model.ReversalInitiator
+}
+
+func (f *fakeService) CheckUserAccess(ctx context.Context, login string) (model.User, error) {
+	user, ok := f.users[login]
+	if !ok {
+		return model.User{}, model.ErrNotAuthorized
+	}
+	if user.Blocked {
+		return model.User{}, model.ErrUserBlocked
+	}
+	return user, nil
+}
+
+func (f *fakeService) GetUser(ctx context.Context, login string) (model.User, error) {
+	user, ok := f.users[login]
+	if !ok {
+		return model.User{}, model.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeService) AuthAPIKey(ctx context.Context, rawKey string) (model.APIKey, error) {
+	key, ok := f.keys[rawKey]
+	if !ok {
+		return model.APIKey{}, model.ErrNotAuthorized
+	}
+	return key, nil
+}
+
+func (f *fakeService) CheckSession(ctx context.Context, login string, id string) error {
+	return nil
+}
+
+func (f *fakeService) GetDataVersion(ctx context.Context, login string) (int64, error) {
+	return 1, nil
+}
+
+func (f *fakeService) GetWithdrawals(ctx context.Context, login string) ([]model.OrderWithdraw, error) {
+	f.login = login
+	return nil, model.ErrNoWithdrawals
+}
+
+func (f *fakeService) GetUserOrders(ctx context.Context, login string) ([]model.OrdersResponse, error) {
+	f.login = login
+	return nil, model.ErrNoOrders
+}
+
+func (f *fakeService) AddUserOrders(ctx context.Context, numbers []string, login string) ([]model.BatchOrderResult, error) {
+	f.login = login
+	f.numbers = numbers
+	results := make([]model.BatchOrderResult, len(numbers))
+	for i, number := range numbers {
+		results[i] = model.BatchOrderResult{Number: number, Result: model.BatchAccepted, Status: http.StatusAccepted}
+	}
+	return results, nil
+}
+
+func (f *fakeService) GetBalance(ctx context.Context, login string) (model.Balance, error) {
+	f.login = login
+	return model.Balance{}, nil
+}
+
+func (f *fakeService) GetTransactions(ctx context.Context, login string, filter model.ListFilter) ([]model.Transaction, error) {
+	f.login = login
+	return []model.Transaction{{ID: 1, Type: model.TxAccrual, Amount: 10, Balance: 10}}, nil
+}
+
+func (f *fakeService) GetBalanceAt(ctx context.Context, login string, at time.Time) (model.Balance, error) {
+	f.login = login
+	f.balanceAt = at
+	return model.Balance{}, nil
+}
+
+func (f *fakeService) GetSessions(ctx context.Context, login string, current string) ([]model.Session, error) {
+	f.login = login
+	return []model.Session{}, nil
+}
+
+func (f *fakeService) ReverseWithdrawal(ctx context.Context, login string, number string, req model.ReversalRequest,
+	by model.ReversalInitiator) (model.Reversal, error) {
+	f.login = login
+	f.reversalBy = by
+	// как в сервисе: крупные отмены администратором ждут утверждения
+	if by.Admin != "" && req.Sum > 1000 {
+		return model.Reversal{Number: number, Sum: req.Sum, Status: model.ReversalPending, AdjustmentID: 1}, nil
+	}
+	return model.Reversal{Number: number, Sum: req.Sum, Status: model.ReversalApplied}, nil
+}
+
+func (f *fakeService) TerminateSession(ctx context.Context, login string, id string) error {
+	f.login = login
+	return nil
+}
+
+func newTestRouter(service ServiceInterface) chi.Router {
+	return NewRouter(service, logger.InitLog(), config.Config{})
+}
+
+// токен пользователя с ролью в claims
+func testToken(t *testing.T, login string, role string) string {
+	token, err := utils.NewToken(model.User{Login: login, Role: role}, "session")
+	require.NoError(t, err)
+	return token
+}
+
+func serve(router http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, r)
+	return rec
+}
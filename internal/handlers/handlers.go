@@ -7,8 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"time"
 
 	"github.com/kartalenka7/project_gophermart/internal/model"
 	"github.com/kartalenka7/project_gophermart/internal/utils"
@@ -16,16 +16,59 @@ import (
 )
 
 // интерфейс для взаимодействия с сервисом
+//
 //go:generate mockery --name ServiceInterface --with-expecter
 type ServiceInterface interface {
 	RgstrUser(ctx context.Context, user model.User) error
-	AuthUser(ctx context.Context, user model.User) error
+	AuthUser(ctx context.Context, user model.User) (model.User, error)
 	AddUserOrder(ctx context.Context, number string, login string) error
+	AddUserOrders(ctx context.Context, numbers []string, login string) ([]model.BatchOrderResult, error)
 	GetUserOrders(ctx context.Context, login string) ([]model.OrdersResponse, error)
+	GetUserOrder(ctx context.Context, number string, login string) (model.OrderDetail, error)
+	WaitUserOrder(ctx context.Context, number string, login string, sinceStatus string,
+		wait time.Duration) (model.OrderDetail, error)
 	ParseUserCredentials(r *http.Request) (model.User, error)
 	WriteWithdraw(ctx context.Context, withdraw model.OrderWithdraw, login string) error
 	GetBalance(ctx context.Context, login string) (model.Balance, error)
+	GetBalanceAt(ctx context.Context, login string, at time.Time) (model.Balance, error)
+	CreateHold(ctx context.Context, login string, req model.HoldRequest) (model.Hold, error)
+	CaptureHold(ctx context.Context, login string, id int64) (model.Hold, error)
+	VoidHold(ctx context.Context, login string, id int64) (model.Hold, error)
+	Transfer(ctx context.Context, sender string, req model.TransferRequest) (model.Transfer, error)
+	ReverseTransfer(ctx context.Context, id int64, admin string) (model.Transfer, error)
+	GetWithdrawalLimits(ctx context.Context, login string) (model.UserWithdrawalLimits, error)
+	SetWithdrawalLimits(ctx context.Context, login string, limits model.WithdrawalLimits,
+		admin string) (model.UserWithdrawalLimits, error)
+	DeleteWithdrawalLimits(ctx context.Context, login string) error
 	GetWithdrawals(ctx context.Context, login string) ([]model.OrderWithdraw, error)
+	GetDataVersion(ctx context.Context, login string) (int64, error)
+	GetTransactions(ctx context.Context, login string, filter model.ListFilter) ([]model.Transaction, error)
+	GetStatement(ctx context.Context, login string, filter model.ListFilter, w model.StatementWriter) error
+	ReverseWithdrawal(ctx context.Context, login string, number string, req model.ReversalRequest,
+		by model.ReversalInitiator) (model.Reversal, error)
+	CheckUserAccess(ctx context.Context, login string) (model.User, error)
+	GetUser(ctx context.Context, login string) (model.User, error)
+	BlockUser(ctx context.Context, login string, blocked bool) error
+	RequeueOrder(ctx context.Context, number string, reverify bool) error
+	CreateAdjustment(ctx context.Context, adj model.Adjustment, admin string) (model.Adjustment, error)
+	DecideAdjustment(ctx context.Context, id int64, admin string, approve bool) (model.Adjustment, error)
+	GetAdjustments(ctx context.Context, status string) ([]model.Adjustment, error)
+	GetClawbacks(ctx context.Context, openOnly bool) ([]model.Clawback, error)
+	CreateAPIKey(ctx context.Context, key model.APIKey) (model.APIKey, error)
+	AuthAPIKey(ctx context.Context, rawKey string) (model.APIKey, error)
+	GetAPIKeys(ctx context.Context) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int64) error
+	EnrollTOTP(ctx context.Context, login string) (model.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, login string, code string) error
+	DisableTOTP(ctx context.Context, login string, code string) error
+	NewLoginChallenge(ctx context.Context, login string) (model.LoginChallenge, error)
+	CompleteLogin(ctx context.Context, req model.OTPRequest) (model.User, error)
+	CheckStepUp(ctx context.Context, login string, amount float64, code string) error
+	CreateSession(ctx context.Context, login string, userAgent string, ip string) (string, error)
+	CheckSession(ctx context.Context, login string, id string) error
+	GetSessions(ctx context.Context, login string, current string) ([]model.Session, error)
+	TerminateSession(ctx context.Context, login string, id string) error
+	SubscribeEvents(ctx context.Context, login string, lastID int64) ([]model.Event, <-chan model.Event, func(), error)
 }
 
 func (s server) userRegstr(rw http.ResponseWriter, r *http.Request) {
@@ -33,25 +76,21 @@ func (s server) userRegstr(rw http.ResponseWriter, r *http.Request) {
 	// проверяем запрос, парсим логин и пароль
 	user, err := s.service.ParseUserCredentials(r)
 	if err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
+		s.writeError(rw, r, err)
 		return
 	}
 
+	// логин уже существует - 409
 	err = s.service.RgstrUser(r.Context(), user)
 	if err != nil {
-		// логин уже существует
-		if errors.Is(err, model.ErrLoginExists) {
-			rw.WriteHeader(http.StatusConflict)
-			return
-		}
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, err)
 		return
 	}
 
 	// аутентификация пользователя
-	if err = utils.AddAuthoriztionHeader(rw, user); err != nil {
-		s.log.Error(err.Error())
-		rw.WriteHeader(http.StatusInternalServerError)
+	user.Role = model.RoleUser
+	if err = s.authenticate(rw, r, user); err != nil {
+		s.writeError(rw, r, err)
 		return
 	}
 
@@ -62,27 +101,29 @@ func (s server) userRegstr(rw http.ResponseWriter, r *http.Request) {
 func (s server) userAuth(rw http.ResponseWriter, r *http.Request) {
 	user, err := s.service.ParseUserCredentials(r)
 	if err != nil {
-		rw.WriteHeader(http.StatusBadRequest)
+		s.writeError(rw, r, err)
 		return
 	}
 	s.log.WithFields(logrus.Fields{
 		"user": user.Login}).Info("Аутентификация пользователя")
 
-	err = s.service.AuthUser(r.Context(), user)
+	// неверные логин или пароль - 401, пользователь заблокирован - 403
+	user, err = s.service.AuthUser(r.Context(), user)
 	if err != nil {
-		// Неверные логин или пароль
-		if errors.Is(err, model.ErrAuthFailed) {
-			rw.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, err)
+		return
+	}
+
+	// при включенной 2FA выдаем только токен первого шага,
+	// полный токен выдается после проверки кода
+	if user.TOTPEnabled {
+		s.loginChallenge(rw, r, user)
 		return
 	}
 
 	// аутентификация пользователя
-	if err = utils.AddAuthoriztionHeader(rw, user); err != nil {
-		s.log.Error(err.Error())
-		rw.WriteHeader(http.StatusInternalServerError)
+	if err = s.authenticate(rw, r, user); err != nil {
+		s.writeError(rw, r, err)
 		return
 	}
 
@@ -93,27 +134,25 @@ func (s server) userAuth(rw http.ResponseWriter, r *http.Request) {
 func (s server) addOrder(rw http.ResponseWriter, r *http.Request) {
 
 	s.log.Info("Добавить заказ")
-	// получить номер заказа из body
+	// проверить формат запроса
+	if !utils.HasContentType(r, "text/plain") {
+		s.log.Error("Неверный Content-Type")
+		s.writeError(rw, r, model.ErrWrongContentType)
+		return
+	}
+
+	// получить номер заказа из body, размер тела ограничен - 413
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Add order handler| %v", err)
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, err)
 		return
 	}
 	defer r.Body.Close()
 	number := string(body)
 
-	// проверить формат запроса
-	if r.Header.Get("Content-Type") != "text/plain" {
-		s.log.Error("Неверный Content-Type")
-		rw.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
 	login, ok := r.Context().Value(model.KeyLogin).(string)
 	if !ok {
-		s.log.Error(model.ErrCastingType)
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, model.ErrCastingType)
 		return
 	}
 
@@ -124,17 +163,9 @@ func (s server) addOrder(rw http.ResponseWriter, r *http.Request) {
 			rw.WriteHeader(http.StatusOK)
 			return
 		}
-		// номер заказа был зарегистрирова другим пользователем
-		if errors.Is(err, model.ErrOrderExistsDiffUser) {
-			rw.WriteHeader(http.StatusConflict)
-			return
-		}
-		// неверный формат номера заказа
-		if errors.Is(err, model.ErrNotValidOrderNumber) {
-			rw.WriteHeader(http.StatusUnprocessableEntity)
-			return
-		}
-		rw.WriteHeader(http.StatusInternalServerError)
+		// номер заказа был зарегистрирован другим пользователем - 409,
+		// неверный формат номера заказа - 422
+		s.writeError(rw, r, err)
 		return
 	}
 
@@ -146,13 +177,22 @@ func (s server) getOrders(rw http.ResponseWriter, r *http.Request) {
 
 	login, ok := r.Context().Value(model.KeyLogin).(string)
 	if !ok {
-		s.log.Error(model.ErrCastingType.Error())
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+
+	// данные не менялись с прошлого запроса - 304
+	if s.notModified(rw, r, login, "orders") {
+		return
 	}
 
 	orders, err := s.service.GetUserOrders(r.Context(), login)
 	if err != nil {
-		rw.WriteHeader(http.StatusNoContent)
+		if errors.Is(err, model.ErrNoOrders) {
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		s.writeError(rw, r, err)
 		return
 	}
 
@@ -162,7 +202,7 @@ func (s server) getOrders(rw http.ResponseWriter, r *http.Request) {
 	encoder.SetEscapeHTML(false)
 	err = encoder.Encode(orders)
 	if err != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, err)
 		return
 	}
 
@@ -181,38 +221,36 @@ func (s server) withdraw(rw http.ResponseWriter, r *http.Request) {
 	s.log.Info("Попытка списания средств")
 
 	// проверить формат запроса
-	if r.Header.Get("Content-Type") != "application/json" {
+	if !utils.HasContentType(r, "application/json") {
 		s.log.Error("Неверный Content-Type")
-		rw.WriteHeader(http.StatusBadRequest)
+		s.writeError(rw, r, model.ErrWrongContentType)
 		return
 	}
 
 	// получаем из body сумму списания и номер заказа
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&withdraw); err != nil {
+	if err := utils.DecodeJSON(r.Body, &withdraw); err != nil {
 		s.log.Error(err.Error())
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, err)
 		return
 	}
 	login, ok := r.Context().Value(model.KeyLogin).(string)
 	if !ok {
-		s.log.Error(model.ErrCastingType.Error())
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, model.ErrCastingType)
 		return
 	}
+	// списание по API ключу записывается на партнера
+	withdraw.Partner, _ = r.Context().Value(model.KeyPartner).(string)
 
+	// крупные списания подтверждаются кодом 2FA
+	err := s.service.CheckStepUp(r.Context(), login, withdraw.Withdraw, r.Header.Get(otpHeader))
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+
+	// 422 — неверный номер заказа, 402 — на счету недостаточно средств
 	if err := s.service.WriteWithdraw(r.Context(), withdraw, login); err != nil {
-		if errors.Is(err, model.ErrNotValidOrderNumber) {
-			//422 — неверный номер заказа;
-			rw.WriteHeader(http.StatusUnprocessableEntity)
-			return
-		}
-		if errors.Is(err, model.ErrInsufficientBalance) {
-			//402 — на счету недостаточно средств
-			rw.WriteHeader(http.StatusPaymentRequired)
-			return
-		}
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, err)
 		return
 	}
 	s.log.Info("Списание произошло")
@@ -223,17 +261,21 @@ func (s server) getWithdrawals(rw http.ResponseWriter, r *http.Request) {
 	s.log.Info("Получение информации о выводе средств")
 	login, ok := r.Context().Value(model.KeyLogin).(string)
 	if !ok {
-		s.log.Error(model.ErrCastingType.Error())
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+	// данные не менялись с прошлого запроса - 304
+	if s.notModified(rw, r, login, "withdrawals") {
 		return
 	}
+
 	withdrawals, err := s.service.GetWithdrawals(r.Context(), login)
 	if err != nil {
 		if errors.Is(err, model.ErrNoWithdrawals) {
 			rw.WriteHeader(http.StatusNoContent)
 			return
 		}
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, err)
 		return
 	}
 	buf := bytes.NewBuffer([]byte{})
@@ -241,7 +283,7 @@ func (s server) getWithdrawals(rw http.ResponseWriter, r *http.Request) {
 	encoder.SetEscapeHTML(false)
 	err = encoder.Encode(withdrawals)
 	if err != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, err)
 		return
 	}
 	s.log.WithFields(logrus.Fields{"withdrawals": withdrawals}).Info("Информация о выводе средств получена")
@@ -255,14 +297,41 @@ func (s server) getBalance(rw http.ResponseWriter, r *http.Request) {
 
 	login, ok := r.Context().Value(model.KeyLogin).(string)
 	if !ok {
-		s.log.Error(model.ErrCastingType.Error())
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+
+	// баланс на момент at, например для разбора спорных операций
+	var at *time.Time
+	var etagParams []string
+	if value := r.URL.Query().Get("at"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			s.writeError(rw, r, fmt.Errorf("%w: at must be RFC 3339 time", model.ErrWrongRequest))
+			return
+		}
+		at = &t
+		etagParams = append(etagParams, "at="+t.UTC().Format(time.RFC3339Nano))
+	} else if s.pointsExpiry {
+		// сумма к сгоранию меняется со сменой дня (UTC) без изменения данных
+		etagParams = append(etagParams, "expiry="+time.Now().UTC().Format("2006-01-02"))
+	}
+
+	// данные не менялись с прошлого запроса - 304
+	if s.notModified(rw, r, login, etagResource("balance", etagParams...)) {
 		return
 	}
 
-	balance, err := s.service.GetBalance(r.Context(), login)
+	var balance model.Balance
+	var err error
+	if at != nil {
+		balance, err = s.service.GetBalanceAt(r.Context(), login, *at)
+	} else {
+		balance, err = s.service.GetBalance(r.Context(), login)
+	}
 	if err != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, err)
+		return
 	}
 
 	buf := bytes.NewBuffer([]byte{})
@@ -270,7 +339,7 @@ func (s server) getBalance(rw http.ResponseWriter, r *http.Request) {
 	encoder.SetEscapeHTML(false)
 	err = encoder.Encode(balance)
 	if err != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
+		s.writeError(rw, r, err)
 		return
 	}
 
@@ -283,3 +352,51 @@ func (s server) getBalance(rw http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(rw, buf)
 
 }
+
+// закодировать объект в JSON и записать в ответ с указанным статусом
+func (s server) writeJSON(rw http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	buf := bytes.NewBuffer([]byte{})
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+
+	rw.Header().Add("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	fmt.Fprint(rw, buf)
+}
+
+// Операции по счету в порядке проведения с балансом после каждой,
+// с фильтром по периоду и страницей
+func (s server) getTransactions(rw http.ResponseWriter, r *http.Request) {
+	s.log.Info("Получение операций по счету")
+
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+	filter, err := parseListFilter(r)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+
+	// данные не менялись с прошлого запроса - 304
+	if s.notModified(rw, r, login, etagResource("transactions", listETagParams(filter)...)) {
+		return
+	}
+
+	transactions, err := s.service.GetTransactions(r.Context(), login, filter)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	if len(transactions) == 0 {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, transactions)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Удержать баллы под заказ при сборке корзины, списываются они
+// только при подтверждении оплаты
+func (s server) createHold(rw http.ResponseWriter, r *http.Request) {
+	var req model.HoldRequest
+
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		s.log.Error(err.Error())
+		s.writeError(rw, r, err)
+		return
+	}
+	// удержание по API ключу, а затем и списание при capture, записывается на партнера
+	req.Partner, _ = r.Context().Value(model.KeyPartner).(string)
+	s.log.WithFields(logrus.Fields{
+		"user":   login,
+		"number": req.Number,
+	}).Info("Удержание баллов")
+
+	// крупные удержания, как и списания, подтверждаются кодом 2FA
+	err := s.service.CheckStepUp(r.Context(), login, req.Sum, r.Header.Get(otpHeader))
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+
+	hold, err := s.service.CreateHold(r.Context(), login, req)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusCreated, hold)
+}
+
+func (s server) captureHold(rw http.ResponseWriter, r *http.Request) {
+	s.decideHold(rw, r, s.service.CaptureHold)
+}
+
+func (s server) voidHold(rw http.ResponseWriter, r *http.Request) {
+	s.decideHold(rw, r, s.service.VoidHold)
+}
+
+func (s server) decideHold(rw http.ResponseWriter, r *http.Request,
+	decide func(ctx context.Context, login string, id int64) (model.Hold, error)) {
+
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.writeError(rw, r, model.ErrHoldNotFound)
+		return
+	}
+
+	hold, err := decide(r.Context(), login, id)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, hold)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Лимиты списаний пользователя: переопределенные и действующие
+func (s server) getWithdrawalLimits(rw http.ResponseWriter, r *http.Request) {
+	limits, err := s.service.GetWithdrawalLimits(r.Context(), chi.URLParam(r, "login"))
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, limits)
+}
+
+// Переопределение лимитов списаний пользователя администратором
+func (s server) setWithdrawalLimits(rw http.ResponseWriter, r *http.Request) {
+	var limits model.WithdrawalLimits
+
+	admin, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+	if err := utils.DecodeJSON(r.Body, &limits); err != nil {
+		s.log.Error(err.Error())
+		s.writeError(rw, r, err)
+		return
+	}
+	login := chi.URLParam(r, "login")
+	s.log.WithFields(logrus.Fields{
+		"user":  login,
+		"admin": admin,
+	}).Info("Изменение лимитов списаний")
+
+	result, err := s.service.SetWithdrawalLimits(r.Context(), login, limits, admin)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, result)
+}
+
+// Сброс лимитов пользователя к значениям по умолчанию
+func (s server) deleteWithdrawalLimits(rw http.ResponseWriter, r *http.Request) {
+	login := chi.URLParam(r, "login")
+	s.log.WithFields(logrus.Fields{"user": login}).Info("Сброс лимитов списаний")
+
+	if err := s.service.DeleteWithdrawalLimits(r.Context(), login); err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+// размер страницы списков по умолчанию и наибольший
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// Разобрать параметры списка: from и to - RFC 3339 или дата (to включительно),
+// limit и offset - страница
+func parseListFilter(r *http.Request) (model.ListFilter, error) {
+	var err error
+	filter := model.ListFilter{Limit: defaultListLimit}
+	query := r.URL.Query()
+
+	if value := query.Get("from"); value != "" {
+		if filter.From, err = parseListTime(value, false); err != nil {
+			return model.ListFilter{}, fmt.Errorf("%w: from: %v", model.ErrWrongRequest, err)
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if filter.To, err = parseListTime(value, true); err != nil {
+			return model.ListFilter{}, fmt.Errorf("%w: to: %v", model.ErrWrongRequest, err)
+		}
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return model.ListFilter{}, fmt.Errorf("%w: from must be before to", model.ErrWrongRequest)
+	}
+
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxListLimit {
+			return model.ListFilter{}, fmt.Errorf("%w: limit must be from 1 to %d", model.ErrWrongRequest, maxListLimit)
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		filter.Offset, err = strconv.Atoi(value)
+		if err != nil || filter.Offset < 0 {
+			return model.ListFilter{}, fmt.Errorf("%w: offset must not be negative", model.ErrWrongRequest)
+		}
+	}
+	return filter, nil
+}
+
+// Дата без времени в конце периода включает весь день
+func parseListTime(value string, end bool) (*time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return &t, nil
+	}
+	t, err = time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("expected RFC 3339 time or date, got %q", value)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// Параметры списка в каноническом виде для ETag
+func listETagParams(filter model.ListFilter) []string {
+	params := []string{
+		"limit=" + strconv.Itoa(filter.Limit),
+		"offset=" + strconv.Itoa(filter.Offset),
+	}
+	if filter.From != nil {
+		params = append(params, "from="+filter.From.UTC().Format(time.RFC3339Nano))
+	}
+	if filter.To != nil {
+		params = append(params, "to="+filter.To.UTC().Format(time.RFC3339Nano))
+	}
+	return params
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseListFilter(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		query   string
+		from    *time.Time
+		to      *time.Time
+		limit   int
+		offset  int
+		wantErr bool
+	}{
+		{query: "", limit: defaultListLimit},
+		{query: "from=2024-03-01&to=2024-03-01&limit=10&offset=20",
+			from: &day, to: func() *time.Time { t := day.AddDate(0, 0, 1); return &t }(), limit: 10, offset: 20},
+		{query: "from=2024-03-01T00:00:00Z", from: &day, limit: defaultListLimit},
+		{query: "from=yesterday", wantErr: true},
+		{query: "from=2024-03-02&to=2024-03-01", wantErr: true},
+		{query: "limit=0", wantErr: true},
+		{query: "limit=100000", wantErr: true},
+		{query: "offset=-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/user/transactions?"+tt.query, nil)
+			filter, err := parseListFilter(r)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.limit, filter.Limit)
+			assert.Equal(t, tt.offset, filter.Offset)
+			if tt.from != nil {
+				require.NotNil(t, filter.From)
+				assert.True(t, tt.from.Equal(*filter.From))
+			}
+			if tt.to != nil {
+				require.NotNil(t, filter.To)
+				assert.True(t, tt.to.Equal(*filter.To))
+			}
+		})
+	}
+}
+
+func TestTransactionsETag(t *testing.T) {
+	etag := func(query string) string {
+		r := httptest.NewRequest(http.MethodGet, "/api/user/transactions?"+query, nil)
+		filter, err := parseListFilter(r)
+		require.NoError(t, err)
+		return dataETag("user", etagResource("transactions", listETagParams(filter)...), 1)
+	}
+
+	first := etag("from=2024-03-01&limit=10")
+	// тот же запрос, записанный иначе
+	assert.Equal(t, first, etag("limit=10&from=2024-03-01T00:00:00Z"))
+	assert.Equal(t, first, etag("from=2024-03-01T03:00:00%2B03:00&limit=10&offset=0"))
+	// другая страница или период
+	assert.NotEqual(t, first, etag("from=2024-03-01&limit=10&offset=10"))
+	assert.NotEqual(t, first, etag("from=2024-03-01&limit=20"))
+	assert.NotEqual(t, first, etag("from=2024-03-02&limit=10"))
+	assert.NotEqual(t, first, etag("from=2024-03-01&to=2024-03-05&limit=10"))
+	assert.NotEqual(t, etag(""), first)
+}
+
+func TestGetTransactionsNotModified(t *testing.T) {
+	service := &fakeService{users: map[string]model.User{"user": {Login: "user"}}}
+	router := newTestRouter(service)
+	token := testToken(t, "user", model.RoleUser)
+
+	get := func(query string, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/user/transactions?"+query, nil)
+		r.Header.Set("Authorization", token)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return serve(router, r)
+	}
+
+	first := get("limit=1", "")
+	require.Equal(t, http.StatusOK, first.Code)
+	etag := first.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	assert.Equal(t, http.StatusNotModified, get("limit=1&offset=0", etag).Code)
+	// тег первой страницы не подходит ко второй
+	assert.Equal(t, http.StatusOK, get("limit=1&offset=1", etag).Code)
+}
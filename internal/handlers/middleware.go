@@ -1,81 +1,204 @@
 package handlers
 
 import (
-	"compress/gzip"
 	"context"
-	"io"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi/v5"
 	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/sirupsen/logrus"
 )
 
-// Обработка запросов с поддержкой сжатия данных
-func gzipHandle(next http.Handler) http.Handler {
+const (
+	apiKeyScheme    = "ApiKey "
+	userLoginHeader = "X-User-Login"
+)
+
+// Проверить, что пользователь аутентифицирован
+func (s server) checkUserAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.log.Info("Проверка аутентификации пользователя")
+		//Получение токена
+		tokenHeader, fromCookie := s.requestToken(r)
+		if tokenHeader == "" {
+			s.log.Error("Токен пуст")
+			s.writeError(w, r, model.ErrNotAuthorized)
+			return
+		}
+		if fromCookie && !checkCSRF(r) {
+			s.log.Error("Не пройдена проверка CSRF токена")
+			s.writeError(w, r, model.ErrCSRFFailed)
+			return
+		}
 
-		if r.Header.Get("Content-Encoding") != "gzip" {
-			// если gzip не поддерживается, передаём управление
-			// дальше без изменений
-			next.ServeHTTP(w, r)
+		// запрос партнера по API ключу
+		if strings.HasPrefix(tokenHeader, apiKeyScheme) {
+			s.checkAPIKey(w, r, next, strings.TrimPrefix(tokenHeader, apiKeyScheme))
 			return
 		}
 
-		// Распаковать длинный url из body с помощью gzip
-		gz, err := gzip.NewReader(r.Body)
+		tk, err := utils.ParseToken(tokenHeader)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			s.log.Error(err.Error())
+			s.writeError(w, r, fmt.Errorf("%w: %v", model.ErrNotAuthorized, err))
+			return
+		}
+
+		// токен первого шага входа с 2FA не дает доступа к счету
+		if tk.Purpose != "" {
+			s.log.Error("Token not valid")
+			s.writeError(w, r, model.ErrNotAuthorized)
 			return
 		}
-		defer gz.Close()
 
-		// при чтении вернётся распакованный слайс байт
-		b, err := io.ReadAll(gz)
+		// пользователь мог быть заблокирован или лишен роли после выдачи токена,
+		// поэтому роль берется из базы, а не из токена
+		user, err := s.service.CheckUserAccess(r.Context(), tk.Login)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			s.writeError(w, r, err)
 			return
 		}
-		// пишем в тело распакованные данные и передаем дальше в хэндлеры
-		r.Body = io.NopCloser(strings.NewReader(string(b)))
 
-		// замыкание — используем ServeHTTP следующего хендлера
-		next.ServeHTTP(w, r)
+		// сессия могла быть завершена пользователем
+		if err = s.service.CheckSession(r.Context(), tk.Login, tk.SessionID); err != nil {
+			if errors.Is(err, model.ErrSessionNotFound) {
+				s.log.Error(err.Error())
+				err = fmt.Errorf("%w: %v", model.ErrNotAuthorized, err)
+			}
+			s.writeError(w, r, err)
+			return
+		}
+
+		role := user.Role
+		if role == "" {
+			role = model.RoleUser
+		}
 
+		// передаем логин, роль и сессию через контекст
+		ctx := context.WithValue(r.Context(), model.KeyLogin, tk.Login)
+		ctx = context.WithValue(ctx, model.KeyRole, role)
+		ctx = context.WithValue(ctx, model.KeySession, tk.SessionID)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
 	})
 }
 
-// Проверить, что пользователь аутентифицирован
-func (s server) checkUserAuth(next http.Handler) http.Handler {
+// Аутентификация партнера по API ключу. Пользователь, от имени которого
+// выполняется запрос, передается в заголовке X-User-Login
+func (s server) checkAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, rawKey string) {
+	key, err := s.service.AuthAPIKey(r.Context(), rawKey)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	login := r.Header.Get(userLoginHeader)
+	if login == "" {
+		s.log.Error("Не указан пользователь для запроса партнера")
+		s.writeError(w, r, fmt.Errorf("%w: заголовок %s не указан", model.ErrWrongRequest, userLoginHeader))
+		return
+	}
+	if _, err = s.service.CheckUserAccess(r.Context(), login); err != nil {
+		// для партнера неизвестный пользователь - 404, а не ошибка аутентификации
+		if errors.Is(err, model.ErrNotAuthorized) {
+			err = model.ErrUserNotFound
+		}
+		s.writeError(w, r, err)
+		return
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"partner": key.Partner,
+		"user":    login,
+	}).Info("Запрос партнера по API ключу")
+	ctx := context.WithValue(r.Context(), model.KeyLogin, login)
+	ctx = context.WithValue(ctx, model.KeyRole, model.RolePartner)
+	ctx = context.WithValue(ctx, model.KeyPartner, key.Partner)
+	ctx = context.WithValue(ctx, model.KeyPermissions, key.Permissions)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Проверить право API ключа на операцию. Пользователям доступны все операции
+// над своим счетом, поэтому проверка касается только партнеров
+func (s server) requirePermission(perm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if role, _ := r.Context().Value(model.KeyRole).(string); role != model.RolePartner {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			permissions, _ := r.Context().Value(model.KeyPermissions).([]string)
+			for _, p := range permissions {
+				if p == perm {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			s.log.WithFields(logrus.Fields{"permission": perm}).Error(model.ErrForbidden.Error())
+			s.writeError(w, r, model.ErrForbidden)
+		})
+	}
+}
+
+// Операции, доступные только самому пользователю, но не партнерам
+func (s server) userOnly(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		s.log.Info("Проверка аутентификации пользователя")
-		//Получение токена
-		tokenHeader := r.Header.Get("Authorization")
-		if tokenHeader == "" {
-			s.log.Error("Токен пуст")
-			w.WriteHeader(http.StatusUnauthorized)
+		if role, _ := r.Context().Value(model.KeyRole).(string); role == model.RolePartner {
+			s.log.Error(model.ErrForbidden.Error())
+			s.writeError(w, r, model.ErrForbidden)
 			return
 		}
-		tk := &model.Token{}
-		token, err := jwt.ParseWithClaims(tokenHeader, tk, func(token *jwt.Token) (interface{}, error) {
-			return []byte("secret"), nil
-		})
+		next.ServeHTTP(w, r)
+	})
+}
 
-		if err != nil {
-			s.log.Error(err.Error())
-			w.WriteHeader(http.StatusUnauthorized)
+// Операции, доступные только партнерам по API ключу
+func (s server) partnerOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if role, _ := r.Context().Value(model.KeyRole).(string); role != model.RolePartner {
+			s.log.Error(model.ErrForbidden.Error())
+			s.writeError(w, r, model.ErrForbidden)
 			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		if !token.Valid {
-			s.log.Error("Token not valid")
-			w.WriteHeader(http.StatusUnauthorized)
+// Проверить, что у пользователя есть нужная роль
+func (s server) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userRole, ok := r.Context().Value(model.KeyRole).(string)
+			if !ok || userRole != role {
+				s.log.WithFields(logrus.Fields{
+					"role":     userRole,
+					"required": role,
+				}).Error(model.ErrForbidden.Error())
+				s.writeError(w, r, model.ErrForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Подменить логин в контексте на логин пользователя из URL,
+// чтобы администратор мог использовать обычные хэндлеры
+func (s server) targetUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		login := chi.URLParam(r, "login")
+		if _, err := s.service.GetUser(r.Context(), login); err != nil {
+			s.writeError(w, r, err)
 			return
 		}
 
-		// передаем логин через контекст
-		ctx := context.WithValue(r.Context(), model.KeyLogin, tk.Login)
-		r = r.WithContext(ctx)
-		next.ServeHTTP(w, r)
+		s.log.WithFields(logrus.Fields{"user": login}).Info("Запрос администратора к данным пользователя")
+		ctx := context.WithValue(r.Context(), model.KeyLogin, login)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
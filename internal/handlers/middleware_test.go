@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireRole(t *testing.T) {
+	service := &fakeService{users: map[string]model.User{
+		"admin":   {Login: "admin", Role: model.RoleAdmin},
+		"demoted": {Login: "demoted", Role: model.RoleUser},
+		"user":    {Login: "user", Role: model.RoleUser},
+		"blocked": {Login: "blocked", Role: model.RoleAdmin, Blocked: true},
+	}}
+	router := newTestRouter(service)
+
+	tests := []struct {
+		name       string
+		login      string
+		tokenRole  string
+		wantStatus int
+	}{
+		{name: "admin", login: "admin", tokenRole: model.RoleAdmin, wantStatus: http.StatusNoContent},
+		{name: "role from db, not token", login: "admin", tokenRole: model.RoleUser, wantStatus: http.StatusNoContent},
+		{name: "demoted admin", login: "demoted", tokenRole: model.RoleAdmin, wantStatus: http.StatusForbidden},
+		{name: "user", login: "user", tokenRole: model.RoleUser, wantStatus: http.StatusForbidden},
+		{name: "blocked admin", login: "blocked", tokenRole: model.RoleAdmin, wantStatus: http.StatusForbidden},
+		{name: "deleted user", login: "ghost", tokenRole: model.RoleAdmin, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/admin/users/user/withdrawals", nil)
+			r.Header.Set("Authorization", testToken(t, tt.login, tt.tokenRole))
+			assert.Equal(t, tt.wantStatus, serve(router, r).Code)
+		})
+	}
+}
+
+func TestTargetUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantLogin  string
+	}{
+		{name: "existing user", target: "user", wantStatus: http.StatusNoContent, wantLogin: "user"},
+		{name: "unknown user", target: "ghost", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &fakeService{users: map[string]model.User{
+				"admin": {Login: "admin", Role: model.RoleAdmin},
+				"user":  {Login: "user", Role: model.RoleUser},
+			}}
+			r := httptest.NewRequest(http.MethodGet, "/api/admin/users/"+tt.target+"/withdrawals", nil)
+			r.Header.Set("Authorization", testToken(t, "admin", model.RoleAdmin))
+
+			assert.Equal(t, tt.wantStatus, serve(newTestRouter(service), r).Code)
+			// хэндлер работает с данными пользователя из URL, а не администратора
+			assert.Equal(t, tt.wantLogin, service.login)
+		})
+	}
+}
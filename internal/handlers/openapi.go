@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/kartalenka7/project_gophermart/internal/openapi"
+	"github.com/sirupsen/logrus"
+)
+
+// Отдать спецификацию API
+func (s server) getSpec(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(openapi.Spec())
+}
+
+// Ответ хэндлера, накопленный для проверки по спецификации
+type contractRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+
+	// после первого Flush ответ передается клиенту сразу
+	streaming bool
+	failed    bool
+	validate  func(status int) error
+}
+
+func (rec *contractRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *contractRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.failed {
+		return 0, openapi.ErrContract
+	}
+	if rec.streaming {
+		return rec.ResponseWriter.Write(b)
+	}
+	return rec.body.Write(b)
+}
+
+// Потоковый ответ, например SSE, нельзя накопить целиком: при первом Flush
+// проверяются только статус и Content-Type, дальше данные идут клиенту сразу
+func (rec *contractRecorder) Flush() {
+	if rec.failed {
+		return
+	}
+	if !rec.streaming {
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if err := rec.validate(rec.status); err != nil {
+			rec.failed = true
+			return
+		}
+		rec.streaming = true
+		rec.ResponseWriter.WriteHeader(rec.status)
+		rec.ResponseWriter.Write(rec.body.Bytes())
+		rec.body.Reset()
+	}
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Проверка запросов и ответов по спецификации OpenAPI.
+// Запрос, не соответствующий спецификации, отклоняется с 400.
+// Ответ, нарушающий контракт, заменяется на 500, чтобы тесты
+// обнаруживали несовместимые изменения хэндлеров
+func (s server) validateContract(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.contract == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if s.validateRequests {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				s.writeError(w, r, err)
+				return
+			}
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			if err = s.contract.ValidateRequest(r, body); err != nil {
+				s.log.WithFields(logrus.Fields{"path": r.URL.Path}).Error(err.Error())
+				s.writeError(w, r, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		if !s.validateResponses {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &contractRecorder{ResponseWriter: w}
+		rec.validate = func(status int) error {
+			err := s.contract.ValidateResponseHeader(r.Method, r.URL.Path, status, w.Header())
+			if err != nil {
+				s.writeError(w, r, err)
+			}
+			return err
+		}
+		next.ServeHTTP(rec, r)
+		if rec.streaming || rec.failed {
+			return
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		err := s.contract.ValidateResponse(r.Method, r.URL.Path, rec.status, w.Header(), rec.body.Bytes())
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+	})
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// наибольшее время ожидания изменения статуса заказа
+const maxOrderWait = time.Minute
+
+// Загрузка пакета номеров заказов: JSON массив строк или CSV файл.
+// Ответ 207 содержит результат по каждому номеру
+func (s server) addOrders(rw http.ResponseWriter, r *http.Request) {
+	var numbers []string
+	var err error
+
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+
+	switch {
+	case utils.HasContentType(r, "application/json"):
+		err = utils.DecodeJSON(r.Body, &numbers)
+	case utils.HasContentType(r, "text/csv"):
+		numbers, err = readCSVOrders(r.Body)
+	default:
+		err = model.ErrWrongContentType
+	}
+	if err != nil {
+		s.log.Error(err.Error())
+		s.writeError(rw, r, err)
+		return
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"user":   login,
+		"orders": len(numbers),
+	}).Info("Загрузка пакета заказов")
+	results, err := s.service.AddUserOrders(r.Context(), numbers, login)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusMultiStatus, results)
+}
+
+// Номера заказов из CSV: все непустые поля всех строк.
+// Первая строка пропускается, если это заголовок без цифр
+func readCSVOrders(body io.Reader) ([]string, error) {
+	var numbers []string
+
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	for line := 0; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, badRequest(err)
+		}
+		if line == 0 && !strings.ContainsAny(strings.Join(record, ""), "0123456789") {
+			continue
+		}
+		for _, field := range record {
+			if field = strings.TrimSpace(field); field != "" {
+				numbers = append(numbers, field)
+			}
+		}
+	}
+	return numbers, nil
+}
+
+// Заказ с историей изменения статуса и списанием по нему
+func (s server) getOrder(rw http.ResponseWriter, r *http.Request) {
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+	number := chi.URLParam(r, "number")
+	s.log.WithFields(logrus.Fields{"number": number}).Info("Получение заказа")
+
+	// с параметром wait ответ ждет изменения статуса заказа относительно since_status
+	query := r.URL.Query()
+	if !query.Has("wait") {
+		order, err := s.service.GetUserOrder(r.Context(), number, login)
+		if err != nil {
+			s.writeError(rw, r, err)
+			return
+		}
+		s.writeJSON(rw, r, http.StatusOK, order)
+		return
+	}
+
+	wait, err := time.ParseDuration(query.Get("wait"))
+	if err != nil || wait < 0 {
+		s.writeError(rw, r, fmt.Errorf("%w: wait must be a duration like 30s", model.ErrWrongRequest))
+		return
+	}
+	if wait > maxOrderWait {
+		wait = maxOrderWait
+	}
+	order, err := s.service.WaitUserOrder(r.Context(), number, login, query.Get("since_status"), wait)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, order)
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCSVOrders(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "header skipped",
+			body: "order\n12345678903\n79927398713\n",
+			want: []string{"12345678903", "79927398713"},
+		},
+		{
+			name: "first row with digits is not a header",
+			body: "12345678903\n79927398713\n",
+			want: []string{"12345678903", "79927398713"},
+		},
+		{
+			name: "only the first row can be a header",
+			body: "order\nnumber\n12345678903\n",
+			want: []string{"number", "12345678903"},
+		},
+		{
+			name: "several fields and blanks",
+			body: "order, comment\n12345678903, 79927398713\n\n , \n",
+			want: []string{"12345678903", "79927398713"},
+		},
+		{
+			name: "duplicates are kept for the per-number result",
+			body: "12345678903\n12345678903\n",
+			want: []string{"12345678903", "12345678903"},
+		},
+		{
+			name: "header only",
+			body: "order\n",
+		},
+		{
+			name:    "malformed csv",
+			body:    "\"12345678903\n",
+			wantErr: model.ErrWrongRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			numbers, err := readCSVOrders(strings.NewReader(tt.body))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, numbers)
+		})
+	}
+}
+
+func TestAddOrdersBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantNumbers []string
+	}{
+		{
+			name:        "csv",
+			contentType: "text/csv",
+			body:        "order\n12345678903\n79927398713\n",
+			wantStatus:  http.StatusMultiStatus,
+			wantNumbers: []string{"12345678903", "79927398713"},
+		},
+		{
+			name:        "json",
+			contentType: "application/json",
+			body:        `["12345678903", "12345678903"]`,
+			wantStatus:  http.StatusMultiStatus,
+			wantNumbers: []string{"12345678903", "12345678903"},
+		},
+		{
+			name:        "unsupported content type",
+			contentType: "text/plain",
+			body:        "12345678903",
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &fakeService{users: map[string]model.User{"user": {Login: "user"}}}
+			router := newTestRouter(service)
+
+			r := httptest.NewRequest(http.MethodPost, "/api/user/orders/batch", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			r.Header.Set("Authorization", testToken(t, "user", model.RoleUser))
+			rec := serve(router, r)
+
+			require.Equal(t, tt.wantStatus, rec.Code, rec.Body.String())
+			if tt.wantStatus != http.StatusMultiStatus {
+				return
+			}
+			assert.Equal(t, tt.wantNumbers, service.numbers)
+			assert.Equal(t, "user", service.login)
+
+			var results []model.BatchOrderResult
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&results))
+			require.Len(t, results, len(tt.wantNumbers))
+			for i, result := range results {
+				assert.Equal(t, tt.wantNumbers[i], result.Number)
+				assert.Equal(t, http.StatusAccepted, result.Status)
+			}
+		})
+	}
+}
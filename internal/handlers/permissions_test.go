@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartnerPermissions(t *testing.T) {
+	const (
+		readerKey   = "gm_reader00_secret"
+		reverserKey = "gm_reverse0_secret"
+	)
+	users := map[string]model.User{
+		"user":    {Login: "user", Role: model.RoleUser},
+		"blocked": {Login: "blocked", Role: model.RoleUser, Blocked: true},
+	}
+	keys := map[string]model.APIKey{
+		readerKey:   {Partner: "pos", Permissions: []string{model.PermOrdersRead, model.PermBalanceRead}},
+		reverserKey: {Partner: "shop", Permissions: []string{model.PermWithdrawalsReverse}},
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		key        string
+		userLogin  string
+		wantStatus int
+	}{
+		// ключ с правами на чтение заказов и баланса
+		{name: "orders:read granted", method: http.MethodGet, url: "/api/user/orders",
+			key: readerKey, userLogin: "user", wantStatus: http.StatusNoContent},
+		{name: "balance:read granted", method: http.MethodGet, url: "/api/user/balance",
+			key: readerKey, userLogin: "user", wantStatus: http.StatusOK},
+		{name: "withdrawals:read missing", method: http.MethodGet, url: "/api/user/withdrawals",
+			key: readerKey, userLogin: "user", wantStatus: http.StatusForbidden},
+		{name: "withdrawals:reverse missing", method: http.MethodPost, url: "/api/user/withdrawals/12345678903/reversals",
+			key: readerKey, userLogin: "user", wantStatus: http.StatusForbidden},
+		// ключ с правом отмены списаний
+		{name: "withdrawals:reverse granted", method: http.MethodPost, url: "/api/user/withdrawals/12345678903/reversals",
+			key: reverserKey, userLogin: "user", wantStatus: http.StatusCreated},
+		{name: "orders:read missing", method: http.MethodGet, url: "/api/user/orders",
+			key: reverserKey, userLogin: "user", wantStatus: http.StatusForbidden},
+		// операции только для пользователей и администраторов
+		{name: "user only route", method: http.MethodGet, url: "/api/user/sessions",
+			key: readerKey, userLogin: "user", wantStatus: http.StatusForbidden},
+		{name: "admin route", method: http.MethodGet, url: "/api/admin/users/user/withdrawals",
+			key: readerKey, userLogin: "user", wantStatus: http.StatusForbidden},
+		// ошибки аутентификации партнера
+		{name: "unknown or revoked key", method: http.MethodGet, url: "/api/user/orders",
+			key: "gm_revoked_secret", userLogin: "user", wantStatus: http.StatusUnauthorized},
+		{name: "no user header", method: http.MethodGet, url: "/api/user/orders",
+			key: readerKey, wantStatus: http.StatusBadRequest},
+		{name: "unknown user", method: http.MethodGet, url: "/api/user/orders",
+			key: readerKey, userLogin: "ghost", wantStatus: http.StatusNotFound},
+		{name: "blocked user", method: http.MethodGet, url: "/api/user/orders",
+			key: readerKey, userLogin: "blocked", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &fakeService{users: users, keys: keys}
+			r := httptest.NewRequest(tt.method, tt.url, strings.NewReader(`{"sum": 10}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Authorization", apiKeyScheme+tt.key)
+			if tt.userLogin != "" {
+				r.Header.Set(userLoginHeader, tt.userLogin)
+			}
+
+			assert.Equal(t, tt.wantStatus, serve(newTestRouter(service), r).Code)
+			// запрос выполняется от имени пользователя из заголовка, если доходит до сервиса
+			if tt.wantStatus < http.StatusBadRequest {
+				assert.Equal(t, tt.userLogin, service.login)
+			} else {
+				assert.Empty(t, service.login)
+			}
+		})
+	}
+}
+
+func TestUserPermissions(t *testing.T) {
+	service := &fakeService{users: map[string]model.User{"user": {Login: "user", Role: model.RoleUser}}}
+	router := newTestRouter(service)
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		wantStatus int
+	}{
+		{name: "orders", method: http.MethodGet, url: "/api/user/orders", wantStatus: http.StatusNoContent},
+		{name: "withdrawals", method: http.MethodGet, url: "/api/user/withdrawals", wantStatus: http.StatusNoContent},
+		{name: "sessions", method: http.MethodGet, url: "/api/user/sessions", wantStatus: http.StatusOK},
+		{name: "partner only route", method: http.MethodPost, url: "/api/user/withdrawals/12345678903/reversals",
+			wantStatus: http.StatusForbidden},
+		{name: "bad token", method: http.MethodGet, url: "/api/user/orders", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.url, strings.NewReader(`{"sum": 10}`))
+			token := testToken(t, "user", model.RoleUser)
+			if tt.wantStatus == http.StatusUnauthorized {
+				token += "x"
+			}
+			r.Header.Set("Authorization", token)
+			assert.Equal(t, tt.wantStatus, serve(router, r).Code)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+const problemContentType = "application/problem+json"
+
+// Ошибка в ответе клиенту по RFC 7807
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Code     string `json:"code"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// Соответствие доменных ошибок статусу ответа, стабильному коду и сообщению
+type problemType struct {
+	err    error
+	status int
+	code   string
+	title  string
+}
+
+var problemTypes = []problemType{
+	{model.ErrWrongRequest, http.StatusBadRequest, "invalid_request", "Request is malformed"},
+	{model.ErrWrongContentType, http.StatusBadRequest, "invalid_content_type", "Unsupported Content-Type"},
+	{model.ErrNotAuthorized, http.StatusUnauthorized, "unauthorized", "Authentication required"},
+	{model.ErrAuthFailed, http.StatusUnauthorized, "invalid_credentials", "Wrong login or password"},
+	{model.ErrInvalidOTP, http.StatusUnauthorized, "invalid_otp", "One-time code is invalid"},
+	{model.ErrInsufficientBalance, http.StatusPaymentRequired, "insufficient_balance", "Not enough points on balance"},
+	{model.ErrForbidden, http.StatusForbidden, "forbidden", "Access denied"},
+	{model.ErrUserBlocked, http.StatusForbidden, "user_blocked", "User is blocked"},
+	{model.ErrCSRFFailed, http.StatusForbidden, "csrf_failed", "CSRF token is missing or invalid"},
+	{model.ErrSameApprover, http.StatusForbidden, "same_approver", "Adjustment must be approved by another admin"},
+	{model.ErrStepUpRequired, http.StatusForbidden, "step_up_required", "One-time code required for this operation"},
+	{model.ErrUserNotFound, http.StatusNotFound, "user_not_found", "User not found"},
+	{model.ErrOrderNotFound, http.StatusNotFound, "order_not_found", "Order not found"},
+	{model.ErrAdjustmentNotFound, http.StatusNotFound, "adjustment_not_found", "Adjustment not found"},
+	{model.ErrAPIKeyNotFound, http.StatusNotFound, "api_key_not_found", "API key not found"},
+	{model.ErrSessionNotFound, http.StatusNotFound, "session_not_found", "Session not found"},
+	{model.ErrWithdrawalNotFound, http.StatusNotFound, "withdrawal_not_found", "Withdrawal not found"},
+	{model.ErrHoldNotFound, http.StatusNotFound, "hold_not_found", "Hold not found"},
+	{model.ErrTransferNotFound, http.StatusNotFound, "transfer_not_found", "Transfer not found"},
+	{model.ErrLoginExists, http.StatusConflict, "login_exists", "Login is already taken"},
+	{model.ErrOrderExistsDiffUser, http.StatusConflict, "order_owned_by_other_user", "Order number was uploaded by another user"},
+	{model.ErrOrderProcessed, http.StatusConflict, "order_processed", "Order has already been processed"},
+	{model.ErrAdjustmentDecided, http.StatusConflict, "adjustment_decided", "Adjustment has already been decided"},
+	{model.ErrHoldNotActive, http.StatusConflict, "hold_not_active", "Hold has already been captured, voided or expired"},
+	{model.ErrTransferReversed, http.StatusConflict, "transfer_reversed", "Transfer has already been reversed"},
+	{model.ErrTOTPEnabled, http.StatusConflict, "totp_enabled", "Two-factor authentication is already enabled"},
+	{model.ErrTOTPNotEnrolled, http.StatusConflict, "totp_not_enrolled", "Two-factor authentication is not enrolled"},
+	{model.ErrTOTPLocked, http.StatusTooManyRequests, "otp_locked", "Too many invalid one-time codes, try again later"},
+	{model.ErrBodyTooLarge, http.StatusRequestEntityTooLarge, "body_too_large", "Request body is too large"},
+	{model.ErrUnsupportedEncoding, http.StatusUnsupportedMediaType, "unsupported_encoding", "Unsupported Content-Encoding"},
+	{model.ErrNotValidOrderNumber, http.StatusUnprocessableEntity, "invalid_order_number", "Order number is not valid"},
+	{model.ErrWrongReasonCode, http.StatusUnprocessableEntity, "invalid_reason_code", "Unknown adjustment reason code"},
+	{model.ErrWrongPermission, http.StatusUnprocessableEntity, "invalid_permission", "Unknown API key permission"},
+	{model.ErrReversalExceeded, http.StatusUnprocessableEntity, "reversal_exceeded", "Reversal exceeds the amount left to reverse"},
+	{model.ErrRecipientNotFound, http.StatusUnprocessableEntity, "recipient_not_found", "Recipient not found"},
+	{model.ErrRecipientBlocked, http.StatusUnprocessableEntity, "recipient_blocked", "Recipient is blocked"},
+	{model.ErrTransferLimit, http.StatusUnprocessableEntity, "transfer_limit_exceeded", "Daily transfer limit exceeded"},
+	{model.ErrWithdrawalLimit, http.StatusUnprocessableEntity, "withdrawal_limit_exceeded", "Withdrawal limit exceeded"},
+}
+
+var internalProblem = problemType{
+	status: http.StatusInternalServerError,
+	code:   "internal_error",
+	title:  "Internal server error",
+}
+
+// Найти описание для ошибки, неизвестные ошибки - внутренние ошибки сервера
+func problemFor(err error) problemType {
+	for _, p := range problemTypes {
+		if errors.Is(err, p.err) {
+			return p
+		}
+	}
+	return internalProblem
+}
+
+// Записать ошибку в ответ в формате application/problem+json.
+// Подробности передаются только для ошибок клиента
+func writeProblem(rw http.ResponseWriter, r *http.Request, err error) int {
+	p := problemFor(err)
+	body := problem{
+		Type:     "urn:gophermart:problem:" + p.code,
+		Title:    p.title,
+		Status:   p.status,
+		Code:     p.code,
+		Instance: r.URL.Path,
+	}
+	if p.status < http.StatusInternalServerError && err.Error() != p.err.Error() {
+		body.Detail = err.Error()
+	}
+
+	// ETag относится к данным, а не к ошибке
+	rw.Header().Del("ETag")
+	rw.Header().Set("Content-Type", problemContentType)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(p.status)
+	json.NewEncoder(rw).Encode(body)
+	return p.status
+}
+
+// Ошибка разбора тела запроса - 400. Превышение размера тела возвращается как есть
+func badRequest(err error) error {
+	if errors.Is(err, model.ErrBodyTooLarge) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", model.ErrWrongRequest, err)
+}
+
+func (s server) writeError(rw http.ResponseWriter, r *http.Request, err error) {
+	if status := writeProblem(rw, r, err); status >= http.StatusInternalServerError {
+		s.log.Error(err.Error())
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProblemFor(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{model.ErrWrongRequest, http.StatusBadRequest, "invalid_request"},
+		{model.ErrWrongContentType, http.StatusBadRequest, "invalid_content_type"},
+		{model.ErrNotAuthorized, http.StatusUnauthorized, "unauthorized"},
+		{model.ErrAuthFailed, http.StatusUnauthorized, "invalid_credentials"},
+		{model.ErrInvalidOTP, http.StatusUnauthorized, "invalid_otp"},
+		{model.ErrInsufficientBalance, http.StatusPaymentRequired, "insufficient_balance"},
+		{model.ErrForbidden, http.StatusForbidden, "forbidden"},
+		{model.ErrUserBlocked, http.StatusForbidden, "user_blocked"},
+		{model.ErrCSRFFailed, http.StatusForbidden, "csrf_failed"},
+		{model.ErrSameApprover, http.StatusForbidden, "same_approver"},
+		{model.ErrStepUpRequired, http.StatusForbidden, "step_up_required"},
+		{model.ErrUserNotFound, http.StatusNotFound, "user_not_found"},
+		{model.ErrOrderNotFound, http.StatusNotFound, "order_not_found"},
+		{model.ErrAdjustmentNotFound, http.StatusNotFound, "adjustment_not_found"},
+		{model.ErrAPIKeyNotFound, http.StatusNotFound, "api_key_not_found"},
+		{model.ErrSessionNotFound, http.StatusNotFound, "session_not_found"},
+		{model.ErrWithdrawalNotFound, http.StatusNotFound, "withdrawal_not_found"},
+		{model.ErrHoldNotFound, http.StatusNotFound, "hold_not_found"},
+		{model.ErrTransferNotFound, http.StatusNotFound, "transfer_not_found"},
+		{model.ErrLoginExists, http.StatusConflict, "login_exists"},
+		{model.ErrOrderExistsDiffUser, http.StatusConflict, "order_owned_by_other_user"},
+		{model.ErrOrderProcessed, http.StatusConflict, "order_processed"},
+		{model.ErrAdjustmentDecided, http.StatusConflict, "adjustment_decided"},
+		{model.ErrHoldNotActive, http.StatusConflict, "hold_not_active"},
+		{model.ErrTransferReversed, http.StatusConflict, "transfer_reversed"},
+		{model.ErrTOTPEnabled, http.StatusConflict, "totp_enabled"},
+		{model.ErrTOTPNotEnrolled, http.StatusConflict, "totp_not_enrolled"},
+		{model.ErrTOTPLocked, http.StatusTooManyRequests, "otp_locked"},
+		{model.ErrBodyTooLarge, http.StatusRequestEntityTooLarge, "body_too_large"},
+		{model.ErrUnsupportedEncoding, http.StatusUnsupportedMediaType, "unsupported_encoding"},
+		{model.ErrNotValidOrderNumber, http.StatusUnprocessableEntity, "invalid_order_number"},
+		{model.ErrWrongReasonCode, http.StatusUnprocessableEntity, "invalid_reason_code"},
+		{model.ErrWrongPermission, http.StatusUnprocessableEntity, "invalid_permission"},
+		{model.ErrReversalExceeded, http.StatusUnprocessableEntity, "reversal_exceeded"},
+		{model.ErrRecipientNotFound, http.StatusUnprocessableEntity, "recipient_not_found"},
+		{model.ErrRecipientBlocked, http.StatusUnprocessableEntity, "recipient_blocked"},
+		{model.ErrTransferLimit, http.StatusUnprocessableEntity, "transfer_limit_exceeded"},
+		{model.ErrWithdrawalLimit, http.StatusUnprocessableEntity, "withdrawal_limit_exceeded"},
+		// эти ошибки обрабатываются в обработчиках до записи ответа,
+		// если дошли до problemFor - это внутренняя ошибка
+		{model.ErrOrderExistsSameUser, http.StatusInternalServerError, "internal_error"},
+		{model.ErrNoWithdrawals, http.StatusInternalServerError, "internal_error"},
+		{model.ErrNoOrders, http.StatusInternalServerError, "internal_error"},
+		{model.ErrCastingType, http.StatusInternalServerError, "internal_error"},
+		{errors.New("connection refused"), http.StatusInternalServerError, "internal_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code+"/"+tt.err.Error(), func(t *testing.T) {
+			p := problemFor(tt.err)
+			assert.Equal(t, tt.status, p.status)
+			assert.Equal(t, tt.code, p.code)
+
+			wrapped := problemFor(fmt.Errorf("%w: order 12345678903", tt.err))
+			assert.Equal(t, tt.status, wrapped.status)
+			assert.Equal(t, tt.code, wrapped.code)
+		})
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+		wantDetail string
+	}{
+		{
+			name:       "domain error",
+			err:        model.ErrOrderNotFound,
+			wantStatus: http.StatusNotFound,
+			wantCode:   "order_not_found",
+		},
+		{
+			name:       "wrapped client error keeps detail",
+			err:        badRequest(errors.New("unexpected EOF")),
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "invalid_request",
+			wantDetail: "wrong request: unexpected EOF",
+		},
+		{
+			name:       "internal error hides detail",
+			err:        fmt.Errorf("%w: select failed", errors.New("db")),
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   "internal_error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/user/orders", nil)
+			rw := httptest.NewRecorder()
+			rw.Header().Set("ETag", `"stale"`)
+
+			status := writeProblem(rw, r, tt.err)
+			assert.Equal(t, tt.wantStatus, status)
+			assert.Equal(t, tt.wantStatus, rw.Code)
+			assert.Equal(t, problemContentType, rw.Header().Get("Content-Type"))
+			assert.Empty(t, rw.Header().Get("ETag"))
+
+			var body problem
+			require.NoError(t, json.NewDecoder(rw.Body).Decode(&body))
+			assert.Equal(t, tt.wantCode, body.Code)
+			assert.Equal(t, "urn:gophermart:problem:"+tt.wantCode, body.Type)
+			assert.Equal(t, tt.wantStatus, body.Status)
+			assert.Equal(t, tt.wantDetail, body.Detail)
+			assert.Equal(t, "/api/user/orders", body.Instance)
+		})
+	}
+}
+
+// каждая доменная ошибка из problemTypes описана один раз
+func TestProblemTypesUnique(t *testing.T) {
+	codes := make(map[string]bool)
+	for _, p := range problemTypes {
+		assert.False(t, codes[p.code], p.code)
+		codes[p.code] = true
+		for _, other := range problemTypes {
+			if other.err != p.err {
+				assert.False(t, errors.Is(p.err, other.err), "%s matches %s", p.code, other.code)
+			}
+		}
+	}
+}
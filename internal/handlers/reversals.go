@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Отмена списания администратором (логин пользователя в URL)
+// или партнером (логин пользователя в заголовке X-User-Login)
+func (s server) reverseWithdrawal(rw http.ResponseWriter, r *http.Request) {
+	var req model.ReversalRequest
+	var by model.ReversalInitiator
+
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+	if target := chi.URLParam(r, "login"); target != "" {
+		by.Admin = login
+		login = target
+	} else {
+		by.Partner, _ = r.Context().Value(model.KeyPartner).(string)
+	}
+	number := chi.URLParam(r, "number")
+	s.log.WithFields(logrus.Fields{
+		"user":    login,
+		"number":  number,
+		"admin":   by.Admin,
+		"partner": by.Partner,
+	}).Info("Запрос на отмену списания")
+
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		s.log.Error(err.Error())
+		s.writeError(rw, r, err)
+		return
+	}
+
+	// списание не найдено - 404, чужое списание партнера - 403, сумма больше остатка списания - 422
+	reversal, err := s.service.ReverseWithdrawal(r.Context(), login, number, req, by)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	// крупная отмена администратором ждет утверждения - 202
+	status := http.StatusCreated
+	if reversal.Status == model.ReversalPending {
+		status = http.StatusAccepted
+	}
+	s.writeJSON(rw, r, status, reversal)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReverseWithdrawal(t *testing.T) {
+	const shopKey = "gm_shop0000_secret"
+
+	tests := []struct {
+		name       string
+		url        string
+		key        string
+		token      string
+		body       string
+		wantStatus int
+		wantBy     model.ReversalInitiator
+	}{
+		{
+			name:       "partner",
+			url:        "/api/user/withdrawals/12345678903/reversals",
+			key:        shopKey,
+			body:       `{"sum": 5000, "reason": "order cancelled"}`,
+			wantStatus: http.StatusCreated,
+			wantBy:     model.ReversalInitiator{Partner: "shop"},
+		},
+		{
+			name:       "admin below threshold",
+			url:        "/api/admin/users/user/withdrawals/12345678903/reversals",
+			token:      "admin",
+			body:       `{"sum": 100}`,
+			wantStatus: http.StatusCreated,
+			wantBy:     model.ReversalInitiator{Admin: "admin"},
+		},
+		{
+			name:       "admin above threshold waits for approval",
+			url:        "/api/admin/users/user/withdrawals/12345678903/reversals",
+			token:      "admin",
+			body:       `{"sum": 5000}`,
+			wantStatus: http.StatusAccepted,
+			wantBy:     model.ReversalInitiator{Admin: "admin"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &fakeService{
+				users: map[string]model.User{
+					"user":  {Login: "user", Role: model.RoleUser},
+					"admin": {Login: "admin", Role: model.RoleAdmin},
+				},
+				keys: map[string]model.APIKey{
+					shopKey: {Partner: "shop", Permissions: []string{model.PermWithdrawalsReverse}},
+				},
+			}
+			router := newTestRouter(service)
+
+			r := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.key != "" {
+				r.Header.Set("Authorization", apiKeyScheme+tt.key)
+				r.Header.Set(userLoginHeader, "user")
+			} else {
+				r.Header.Set("Authorization", testToken(t, tt.token, model.RoleAdmin))
+			}
+			rec := serve(router, r)
+
+			require.Equal(t, tt.wantStatus, rec.Code, rec.Body.String())
+			assert.Equal(t, "user", service.login)
+			assert.Equal(t, tt.wantBy, service.reversalBy)
+		})
+	}
+}
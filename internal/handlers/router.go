@@ -1,38 +1,127 @@
 package handlers
 
 import (
+	"time"
+
 	"github.com/go-chi/chi/v5"
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/openapi"
 	"github.com/sirupsen/logrus"
 )
 
 type server struct {
-	service ServiceInterface
-	log     *logrus.Logger
+	service        ServiceInterface
+	log            *logrus.Logger
+	sessionCookies bool
+	sseHeartbeat   time.Duration
+	// баллы сгорают, сумма к сгоранию в балансе зависит от дня
+	pointsExpiry bool
+
+	// проверка по спецификации OpenAPI, nil - проверка отключена
+	contract          *openapi.Validator
+	validateRequests  bool
+	validateResponses bool
 }
 
-func NewRouter(service ServiceInterface, log *logrus.Logger) chi.Router {
+func NewRouter(service ServiceInterface, log *logrus.Logger, cfg config.Config) chi.Router {
 	log.Info("Инициализируем роутер")
 	server := &server{
-		service: service,
-		log:     log}
+		service:        service,
+		log:            log,
+		sessionCookies: cfg.SessionCookies,
+		sseHeartbeat:   cfg.SSEHeartbeat,
+		pointsExpiry:   cfg.PointsExpiryMonths > 0}
+	if server.sseHeartbeat <= 0 {
+		server.sseHeartbeat = 15 * time.Second
+	}
+
+	if cfg.OpenAPIValidation || cfg.OpenAPIValidateResponses {
+		contract, err := openapi.NewValidator()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		server.contract = contract
+		server.validateRequests = cfg.OpenAPIValidation
+		server.validateResponses = cfg.OpenAPIValidateResponses
+	}
 
 	router := chi.NewRouter()
+	router.With(gzipHandle).Get("/api/openapi.json", server.getSpec)
 
 	// маршрутизация запросов
 	router.Route("/api/user", func(r chi.Router) {
 		r.Use(gzipHandle)
+		r.Use(limitBody(cfg.MaxBodySize))
+		r.Use(server.validateContract)
 		r.Post("/register", server.userRegstr)
 		r.Post("/login", server.userAuth)
+		r.Post("/login/2fa", server.userAuthOTP)
+		r.Post("/logout", server.userLogout)
 	})
 
 	router.Group(func(r chi.Router) {
 		r.Use(gzipHandle)
+		r.Use(limitBody(cfg.MaxBodySize))
+		r.Use(server.validateContract)
+		r.Use(server.checkUserAuth)
+		r.With(limitBody(cfg.MaxOrderBodySize), server.requirePermission(model.PermOrdersWrite)).
+			Post("/api/user/orders", server.addOrder)
+		r.With(server.requirePermission(model.PermOrdersWrite)).Post("/api/user/orders/batch", server.addOrders)
+		r.With(server.requirePermission(model.PermOrdersRead)).Get("/api/user/orders", server.getOrders)
+		r.With(server.requirePermission(model.PermOrdersRead)).Get("/api/user/orders/{number}", server.getOrder)
+		r.With(server.requirePermission(model.PermBalanceRead)).Get("/api/user/balance", server.getBalance)
+		r.With(server.requirePermission(model.PermBalanceWrite)).Post("/api/user/balance/withdraw", server.withdraw)
+		r.With(server.requirePermission(model.PermBalanceWrite)).Post("/api/user/balance/holds", server.createHold)
+		r.With(server.requirePermission(model.PermBalanceWrite)).
+			Post("/api/user/balance/holds/{id}/capture", server.captureHold)
+		r.With(server.requirePermission(model.PermBalanceWrite)).
+			Post("/api/user/balance/holds/{id}/void", server.voidHold)
+		r.With(server.userOnly).Post("/api/user/balance/transfer", server.transfer)
+		r.With(server.requirePermission(model.PermWithdrawalsRead)).Get("/api/user/withdrawals", server.getWithdrawals)
+		r.With(server.requirePermission(model.PermBalanceRead)).Get("/api/user/transactions", server.getTransactions)
+		r.With(server.requirePermission(model.PermBalanceRead)).Get("/api/user/statement", server.getStatement)
+		r.With(server.partnerOnly, server.requirePermission(model.PermWithdrawalsReverse)).
+			Post("/api/user/withdrawals/{number}/reversals", server.reverseWithdrawal)
+		r.With(server.userOnly).Post("/api/user/2fa/enroll", server.enrollTOTP)
+		r.With(server.userOnly).Post("/api/user/2fa/confirm", server.confirmTOTP)
+		r.With(server.userOnly).Post("/api/user/2fa/disable", server.disableTOTP)
+		r.With(server.userOnly).Get("/api/user/events", server.streamEvents)
+		r.With(server.userOnly).Get("/api/user/sessions", server.getSessions)
+		r.With(server.userOnly).Delete("/api/user/sessions/{id}", server.deleteSession)
+	})
+
+	// запросы администратора
+	router.Route("/api/admin", func(r chi.Router) {
+		r.Use(gzipHandle)
+		r.Use(limitBody(cfg.MaxBodySize))
+		r.Use(server.validateContract)
 		r.Use(server.checkUserAuth)
-		r.Post("/api/user/orders", server.addOrder)
-		r.Get("/api/user/orders", server.getOrders)
-		r.Get("/api/user/balance", server.getBalance)
-		r.Post("/api/user/balance/withdraw", server.withdraw)
-		r.Get("/api/user/withdrawals", server.getWithdrawals)
+		r.Use(server.requireRole(model.RoleAdmin))
+		r.Route("/users/{login}", func(r chi.Router) {
+			r.With(server.targetUser).Get("/orders", server.getOrders)
+			r.With(server.targetUser).Get("/orders/{number}", server.getOrder)
+			r.With(server.targetUser).Get("/balance", server.getBalance)
+			r.With(server.targetUser).Get("/withdrawals", server.getWithdrawals)
+			r.With(server.targetUser).Get("/transactions", server.getTransactions)
+			r.With(server.targetUser).Get("/statement", server.getStatement)
+			r.Post("/withdrawals/{number}/reversals", server.reverseWithdrawal)
+			r.Post("/block", server.blockUser)
+			r.Post("/unblock", server.unblockUser)
+			r.Get("/limits", server.getWithdrawalLimits)
+			r.Put("/limits", server.setWithdrawalLimits)
+			r.Delete("/limits", server.deleteWithdrawalLimits)
+		})
+		r.Post("/orders/{number}/requeue", server.requeueOrder)
+		r.Post("/adjustments", server.createAdjustment)
+		r.Get("/adjustments", server.getAdjustments)
+		r.Post("/adjustments/{id}/approve", server.approveAdjustment)
+		r.Post("/adjustments/{id}/reject", server.rejectAdjustment)
+		r.Get("/clawbacks", server.getClawbacks)
+		r.Post("/transfers/{id}/reverse", server.reverseTransfer)
+		r.Post("/apikeys", server.createAPIKey)
+		r.Get("/apikeys", server.getAPIKeys)
+		r.Delete("/apikeys/{id}", server.revokeAPIKey)
 	})
 
 	return router
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+)
+
+// В режиме сессий токен передается в HttpOnly cookie, недоступной из JavaScript.
+// Изменяющие запросы с такой cookie защищены от CSRF: клиент читает значение
+// cookie gophermart_csrf и повторяет его в заголовке X-CSRF-Token
+const (
+	tokenCookie = "gophermart_token"
+	csrfCookie  = "gophermart_csrf"
+	csrfHeader  = "X-CSRF-Token"
+	csrfLen     = 32
+)
+
+// Создать сессию и выдать токен пользователю в заголовке и,
+// если включен режим сессий, в cookie
+func (s server) authenticate(rw http.ResponseWriter, r *http.Request, user model.User) error {
+	sessionID, err := s.service.CreateSession(r.Context(), user.Login, r.UserAgent(), clientIP(r))
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.AddAuthoriztionHeader(rw, user, sessionID)
+	if err != nil {
+		return err
+	}
+	if !s.sessionCookies {
+		return nil
+	}
+
+	csrf := make([]byte, csrfLen)
+	if _, err = rand.Read(csrf); err != nil {
+		return err
+	}
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     tokenCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(rw, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(csrf),
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// Получить токен из заголовка Authorization или из cookie.
+// fromCookie - токен взят из cookie и запрос нужно проверить на CSRF
+func (s server) requestToken(r *http.Request) (token string, fromCookie bool) {
+	if token = r.Header.Get("Authorization"); token != "" || !s.sessionCookies {
+		return token, false
+	}
+	cookie, err := r.Cookie(tokenCookie)
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// Double-submit проверка: значение заголовка должно совпадать со значением cookie
+func checkCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	header := r.Header.Get(csrfHeader)
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1
+}
+
+// Завершить текущую сессию и удалить cookie
+func (s server) userLogout(rw http.ResponseWriter, r *http.Request) {
+	token, fromCookie := s.requestToken(r)
+	// иначе сторонний сайт мог бы завершить сессию пользователя
+	if fromCookie && !checkCSRF(r) {
+		s.log.Error("Не пройдена проверка CSRF токена")
+		s.writeError(rw, r, model.ErrCSRFFailed)
+		return
+	}
+	if token != "" {
+		if tk, err := utils.ParseToken(token); err == nil && tk.SessionID != "" {
+			err = s.service.TerminateSession(r.Context(), tk.Login, tk.SessionID)
+			if err != nil && !errors.Is(err, model.ErrSessionNotFound) {
+				s.writeError(rw, r, err)
+				return
+			}
+		}
+	}
+
+	for _, name := range []string{tokenCookie, csrfCookie} {
+		http.SetCookie(rw, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name == tokenCookie,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+	s.log.Info("Пользователь вышел из системы")
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (s server) getSessions(rw http.ResponseWriter, r *http.Request) {
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+	current, _ := r.Context().Value(model.KeySession).(string)
+
+	sessions, err := s.service.GetSessions(r.Context(), login, current)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, sessions)
+}
+
+// Завершить сессию пользователя, например на другом устройстве
+func (s server) deleteSession(rw http.ResponseWriter, r *http.Request) {
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+
+	err := s.service.TerminateSession(r.Context(), login, chi.URLParam(r, "id"))
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+// адрес клиента без порта
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
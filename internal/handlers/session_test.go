@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCSRF(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		header string
+		want   bool
+	}{
+		{name: "safe method", method: http.MethodGet, want: true},
+		{name: "match", method: http.MethodPost, cookie: "abc", header: "abc", want: true},
+		{name: "no header", method: http.MethodPost, cookie: "abc", want: false},
+		{name: "mismatch", method: http.MethodDelete, cookie: "abc", header: "abd", want: false},
+		{name: "no cookie", method: http.MethodPost, header: "abc", want: false},
+		{name: "both empty", method: http.MethodPost, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfCookie, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				r.Header.Set(csrfHeader, tt.header)
+			}
+			assert.Equal(t, tt.want, checkCSRF(r))
+		})
+	}
+}
+
+func TestCookieSessionCSRF(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		cookie     bool
+		csrf       string
+		wantStatus int
+	}{
+		{name: "cookie with csrf", url: "/api/user/sessions/s1", cookie: true, csrf: "token",
+			wantStatus: http.StatusOK},
+		{name: "cookie without csrf", url: "/api/user/sessions/s1", cookie: true,
+			wantStatus: http.StatusForbidden},
+		{name: "cookie with wrong csrf", url: "/api/user/sessions/s1", cookie: true, csrf: "forged",
+			wantStatus: http.StatusForbidden},
+		// заголовок Authorization сторонний сайт подставить не может
+		{name: "header without csrf", url: "/api/user/sessions/s1", wantStatus: http.StatusOK},
+		{name: "logout with csrf", url: "/api/user/logout", cookie: true, csrf: "token",
+			wantStatus: http.StatusOK},
+		{name: "logout without csrf", url: "/api/user/logout", cookie: true,
+			wantStatus: http.StatusForbidden},
+		{name: "logout by header", url: "/api/user/logout", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &fakeService{users: map[string]model.User{"user": {Login: "user", Role: model.RoleUser}}}
+			router := NewRouter(service, logger.InitLog(), config.Config{SessionCookies: true})
+
+			method := http.MethodDelete
+			if tt.url == "/api/user/logout" {
+				method = http.MethodPost
+			}
+			r := httptest.NewRequest(method, tt.url, nil)
+			token := testToken(t, "user", model.RoleUser)
+			if tt.cookie {
+				r.AddCookie(&http.Cookie{Name: tokenCookie, Value: token})
+				r.AddCookie(&http.Cookie{Name: csrfCookie, Value: "token"})
+			} else {
+				r.Header.Set("Authorization", token)
+			}
+			if tt.csrf != "" {
+				r.Header.Set(csrfHeader, tt.csrf)
+			}
+
+			assert.Equal(t, tt.wantStatus, serve(router, r).Code)
+			// отклоненный запрос не завершает сессию
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, "user", service.login)
+			} else {
+				assert.Empty(t, service.login)
+			}
+		})
+	}
+}
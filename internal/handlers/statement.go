@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// через сколько операций выписка отправляется клиенту
+const statementFlushRows = 100
+
+type statementFormat struct {
+	contentType string
+	newWriter   func(w io.Writer, login string, from *time.Time, to time.Time) model.StatementWriter
+}
+
+var statementFormats = map[string]statementFormat{
+	"csv":   {contentType: "text/csv; charset=utf-8", newWriter: newCSVStatement},
+	"jsonl": {contentType: "application/x-ndjson", newWriter: newJSONLStatement},
+	"ofx":   {contentType: "application/x-ofx", newWriter: newOFXStatement},
+}
+
+// Выписка по счету за период в CSV, JSON Lines или OFX. Операции читаются
+// из базы и отправляются клиенту по мере чтения
+func (s server) getStatement(rw http.ResponseWriter, r *http.Request) {
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "csv"
+	}
+	format, ok := statementFormats[name]
+	if !ok {
+		s.writeError(rw, r, fmt.Errorf("%w: format must be csv, jsonl or ofx", model.ErrWrongRequest))
+		return
+	}
+	filter, err := parseListFilter(r)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	// выписка не делится на страницы; конец периода фиксируется, чтобы
+	// баланс на конец не зависел от операций, проведенных во время выгрузки
+	filter.Limit, filter.Offset = 0, 0
+	if filter.To == nil {
+		now := time.Now()
+		filter.To = &now
+	}
+	s.log.WithFields(logrus.Fields{
+		"user":   login,
+		"format": name,
+	}).Info("Выгрузка выписки")
+
+	buf := bufio.NewWriter(rw)
+	stream := &statementStream{
+		rw:          rw,
+		buf:         buf,
+		writer:      format.newWriter(buf, login, filter.From, *filter.To),
+		contentType: format.contentType,
+		filename:    statementFilename(filter, name),
+	}
+	err = s.service.GetStatement(r.Context(), login, filter, stream)
+	if err == nil {
+		return
+	}
+	// после начала выгрузки статус уже отправлен, остается оборвать ответ
+	if !stream.started {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.log.WithFields(logrus.Fields{"user": login}).Error(err.Error())
+}
+
+func statementFilename(filter model.ListFilter, ext string) string {
+	if filter.From == nil {
+		return fmt.Sprintf("statement-%s.%s", filter.To.Format("2006-01-02"), ext)
+	}
+	return fmt.Sprintf("statement-%s-%s.%s", filter.From.Format("2006-01-02"),
+		filter.To.Format("2006-01-02"), ext)
+}
+
+// Отправляет заголовки ответа перед первой записью и периодически
+// передает накопленную выписку клиенту
+type statementStream struct {
+	rw          http.ResponseWriter
+	buf         *bufio.Writer
+	writer      model.StatementWriter
+	contentType string
+	filename    string
+	rows        int
+	started     bool
+}
+
+func (s *statementStream) Opening(balance float64) error {
+	s.rw.Header().Set("Content-Type", s.contentType)
+	s.rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", s.filename))
+	s.rw.Header().Set("Cache-Control", "private")
+	s.rw.WriteHeader(http.StatusOK)
+	s.started = true
+	return s.writer.Opening(balance)
+}
+
+func (s *statementStream) Transaction(tx model.Transaction) error {
+	if err := s.writer.Transaction(tx); err != nil {
+		return err
+	}
+	s.rows++
+	if s.rows%statementFlushRows == 0 {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *statementStream) Closing(balance float64) error {
+	if err := s.writer.Closing(balance); err != nil {
+		return err
+	}
+	return s.flush()
+}
+
+func (s *statementStream) flush() error {
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	if f, ok := s.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// CSV: строка заголовка, баланс на начало (opening), операции, баланс на конец (closing)
+type csvStatement struct {
+	w    *csv.Writer
+	from *time.Time
+	to   time.Time
+}
+
+func newCSVStatement(w io.Writer, login string, from *time.Time, to time.Time) model.StatementWriter {
+	return &csvStatement{w: csv.NewWriter(w), from: from, to: to}
+}
+
+func (c *csvStatement) write(record ...string) error {
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvStatement) Opening(balance float64) error {
+	if err := c.write("id", "type", "processed_at", "order", "amount", "balance"); err != nil {
+		return err
+	}
+	var from string
+	if c.from != nil {
+		from = c.from.Format(time.RFC3339)
+	}
+	return c.write("", "opening", from, "", "", formatAmount(balance))
+}
+
+func (c *csvStatement) Transaction(tx model.Transaction) error {
+	return c.write(strconv.FormatInt(tx.ID, 10), tx.Type, tx.Time.Format(time.RFC3339), tx.Order,
+		formatAmount(tx.Amount), formatAmount(tx.Balance))
+}
+
+func (c *csvStatement) Closing(balance float64) error {
+	return c.write("", "closing", c.to.Format(time.RFC3339), "", "", formatAmount(balance))
+}
+
+// JSON Lines: объект на строку, первая и последняя строки - балансы на начало и конец
+type jsonlStatement struct {
+	enc  *json.Encoder
+	from *time.Time
+	to   time.Time
+}
+
+type statementBalance struct {
+	Type    string     `json:"type"`
+	Balance float64    `json:"balance"`
+	At      *time.Time `json:"at,omitempty"`
+}
+
+func newJSONLStatement(w io.Writer, login string, from *time.Time, to time.Time) model.StatementWriter {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonlStatement{enc: enc, from: from, to: to}
+}
+
+func (j *jsonlStatement) Opening(balance float64) error {
+	return j.enc.Encode(statementBalance{Type: "opening", Balance: balance, At: j.from})
+}
+
+func (j *jsonlStatement) Transaction(tx model.Transaction) error {
+	return j.enc.Encode(tx)
+}
+
+func (j *jsonlStatement) Closing(balance float64) error {
+	return j.enc.Encode(statementBalance{Type: "closing", Balance: balance, At: &j.to})
+}
+
+// OFX 2.2: операции в BANKTRANLIST, баланс на конец в LEDGERBAL,
+// баланс на начало периода - в BALLIST
+type ofxStatement struct {
+	w       io.Writer
+	login   string
+	from    *time.Time
+	to      time.Time
+	opening float64
+	started bool
+	err     error
+}
+
+func newOFXStatement(w io.Writer, login string, from *time.Time, to time.Time) model.StatementWriter {
+	return &ofxStatement{w: w, login: login, from: from, to: to}
+}
+
+func ofxTime(t time.Time) string {
+	return t.UTC().Format("20060102150405") + "[0:GMT]"
+}
+
+func ofxEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// запоминает первую ошибку записи, чтобы не проверять каждую строку
+func (o *ofxStatement) printf(format string, args ...interface{}) {
+	if o.err != nil {
+		return
+	}
+	_, o.err = fmt.Fprintf(o.w, format, args...)
+}
+
+func (o *ofxStatement) Opening(balance float64) error {
+	o.opening = balance
+	o.printf("<?xml version=\"1.0\" encoding=\"UTF-8\" standalone=\"no\"?>\n")
+	o.printf("<?OFX OFXHEADER=\"200\" VERSION=\"220\" SECURITY=\"NONE\" OLDFILEUID=\"NONE\" NEWFILEUID=\"NONE\"?>\n")
+	o.printf("<OFX>\n<SIGNONMSGSRSV1><SONRS>")
+	o.printf("<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>")
+	o.printf("<DTSERVER>%s</DTSERVER><LANGUAGE>RUS</LANGUAGE></SONRS></SIGNONMSGSRSV1>\n", ofxTime(time.Now()))
+	o.printf("<BANKMSGSRSV1><STMTTRNRS><TRNUID>0</TRNUID>")
+	o.printf("<STATUS><CODE>0</CODE><SEVERITY>INFO</SEVERITY></STATUS>\n<STMTRS><CURDEF>RUB</CURDEF>\n")
+	o.printf("<BANKACCTFROM><BANKID>GOPHERMART</BANKID><ACCTID>%s</ACCTID><ACCTTYPE>CHECKING</ACCTTYPE></BANKACCTFROM>\n",
+		ofxEscape(o.login))
+	return o.err
+}
+
+// начало периода без from - время первой операции
+func (o *ofxStatement) startList(first time.Time) {
+	if o.started {
+		return
+	}
+	o.started = true
+	if o.from != nil {
+		first = *o.from
+	}
+	o.printf("<BANKTRANLIST><DTSTART>%s</DTSTART><DTEND>%s</DTEND>\n", ofxTime(first), ofxTime(o.to))
+}
+
+func (o *ofxStatement) Transaction(tx model.Transaction) error {
+	o.startList(tx.Time)
+	trnType := "CREDIT"
+	if tx.Amount < 0 {
+		trnType = "DEBIT"
+	}
+	o.printf("<STMTTRN><TRNTYPE>%s</TRNTYPE><DTPOSTED>%s</DTPOSTED><TRNAMT>%s</TRNAMT><FITID>%d</FITID><NAME>%s</NAME>",
+		trnType, ofxTime(tx.Time), formatAmount(tx.Amount), tx.ID, tx.Type)
+	if tx.Order != "" {
+		o.printf("<MEMO>%s</MEMO>", ofxEscape(tx.Order))
+	}
+	o.printf("</STMTTRN>\n")
+	return o.err
+}
+
+func (o *ofxStatement) Closing(balance float64) error {
+	o.startList(o.to)
+	o.printf("</BANKTRANLIST>\n")
+	o.printf("<LEDGERBAL><BALAMT>%s</BALAMT><DTASOF>%s</DTASOF></LEDGERBAL>\n", formatAmount(balance), ofxTime(o.to))
+	o.printf("<BALLIST><BAL><NAME>Opening balance</NAME><DESC>Balance at the start of the period</DESC>")
+	o.printf("<BALTYPE>DOLLAR</BALTYPE><VALUE>%s</VALUE>", formatAmount(o.opening))
+	if o.from != nil {
+		o.printf("<DTASOF>%s</DTASOF>", ofxTime(*o.from))
+	}
+	o.printf("</BAL></BALLIST>\n</STMTRS></STMTTRNRS></BANKMSGSRSV1>\n</OFX>\n")
+	return o.err
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatementFormats(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	transactions := []model.Transaction{
+		{ID: 7, Type: model.TxAccrual, Amount: 500, Balance: 600, Order: "12345678903",
+			Time: time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)},
+		{ID: 9, Type: model.TxWithdrawal, Amount: -150.5, Balance: 449.5, Order: "2377225624",
+			Time: time.Date(2024, 3, 7, 12, 30, 0, 0, time.UTC)},
+	}
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{
+			format: "csv",
+			want: []string{
+				"id,type,processed_at,order,amount,balance\n" +
+					",opening,2024-03-01T00:00:00Z,,,100.00\n" +
+					"7,accrual,2024-03-05T10:00:00Z,12345678903,500.00,600.00\n" +
+					"9,withdrawal,2024-03-07T12:30:00Z,2377225624,-150.50,449.50\n" +
+					",closing,2024-04-01T00:00:00Z,,,449.50\n",
+			},
+		},
+		{
+			format: "jsonl",
+			want: []string{
+				`{"type":"opening","balance":100,"at":"2024-03-01T00:00:00Z"}` + "\n" +
+					`{"id":7,"type":"accrual","amount":500,"balance":600,"order":"12345678903","processed_at":"2024-03-05T10:00:00Z"}` + "\n" +
+					`{"id":9,"type":"withdrawal","amount":-150.5,"balance":449.5,"order":"2377225624","processed_at":"2024-03-07T12:30:00Z"}` + "\n" +
+					`{"type":"closing","balance":449.5,"at":"2024-04-01T00:00:00Z"}` + "\n",
+			},
+		},
+		{
+			format: "ofx",
+			want: []string{
+				"<ACCTID>a&amp;b</ACCTID>",
+				"<BANKTRANLIST><DTSTART>20240301000000[0:GMT]</DTSTART><DTEND>20240401000000[0:GMT]</DTEND>",
+				"<TRNTYPE>CREDIT</TRNTYPE><DTPOSTED>20240305100000[0:GMT]</DTPOSTED><TRNAMT>500.00</TRNAMT><FITID>7</FITID>",
+				"<TRNTYPE>DEBIT</TRNTYPE>",
+				"<LEDGERBAL><BALAMT>449.50</BALAMT>",
+				"<VALUE>100.00</VALUE>",
+				"</OFX>\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := statementFormats[tt.format].newWriter(&buf, "a&b", &from, to)
+			require.NoError(t, w.Opening(100))
+			for _, tx := range transactions {
+				require.NoError(t, w.Transaction(tx))
+			}
+			require.NoError(t, w.Closing(449.5))
+
+			if len(tt.want) == 1 {
+				assert.Equal(t, tt.want[0], buf.String())
+				return
+			}
+			for _, part := range tt.want {
+				assert.True(t, strings.Contains(buf.String(), part), part)
+			}
+		})
+	}
+}
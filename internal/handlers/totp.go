@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// заголовок с кодом 2FA для подтверждения крупных операций
+const otpHeader = "X-OTP-Code"
+
+func (s server) loginChallenge(rw http.ResponseWriter, r *http.Request, user model.User) {
+	challenge, err := s.service.NewLoginChallenge(r.Context(), user.Login)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.log.WithFields(logrus.Fields{"user": user.Login}).Info("Требуется код 2FA")
+	s.writeJSON(rw, r, http.StatusOK, challenge)
+}
+
+// Второй шаг входа: токен первого шага и код из приложения или код восстановления
+func (s server) userAuthOTP(rw http.ResponseWriter, r *http.Request) {
+	var req model.OTPRequest
+
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	if req.ChallengeToken == "" || req.Code == "" {
+		s.writeError(rw, r, model.ErrWrongRequest)
+		return
+	}
+
+	user, err := s.service.CompleteLogin(r.Context(), req)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+
+	if err = s.authenticate(rw, r, user); err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.log.Info("Пользователь успешно аутентифицирован с 2FA")
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (s server) enrollTOTP(rw http.ResponseWriter, r *http.Request) {
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+
+	enrollment, err := s.service.EnrollTOTP(r.Context(), login)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, enrollment)
+}
+
+func (s server) confirmTOTP(rw http.ResponseWriter, r *http.Request) {
+	s.changeTOTP(rw, r, s.service.ConfirmTOTP)
+}
+
+func (s server) disableTOTP(rw http.ResponseWriter, r *http.Request) {
+	s.changeTOTP(rw, r, s.service.DisableTOTP)
+}
+
+func (s server) changeTOTP(rw http.ResponseWriter, r *http.Request,
+	change func(ctx context.Context, login string, code string) error) {
+
+	var req model.OTPRequest
+
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	if req.Code == "" {
+		s.writeError(rw, r, model.ErrWrongRequest)
+		return
+	}
+
+	if err := change(r.Context(), login, req.Code); err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Перевод баллов другому пользователю
+func (s server) transfer(rw http.ResponseWriter, r *http.Request) {
+	var req model.TransferRequest
+
+	login, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+	if err := utils.DecodeJSON(r.Body, &req); err != nil {
+		s.log.Error(err.Error())
+		s.writeError(rw, r, err)
+		return
+	}
+	s.log.WithFields(logrus.Fields{
+		"sender":    login,
+		"recipient": req.Recipient,
+	}).Info("Перевод баллов")
+
+	// крупные переводы, как и списания, подтверждаются кодом 2FA
+	err := s.service.CheckStepUp(r.Context(), login, req.Sum, r.Header.Get(otpHeader))
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+
+	transfer, err := s.service.Transfer(r.Context(), login, req)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, transfer)
+}
+
+// Отмена перевода администратором
+func (s server) reverseTransfer(rw http.ResponseWriter, r *http.Request) {
+	admin, ok := r.Context().Value(model.KeyLogin).(string)
+	if !ok {
+		s.writeError(rw, r, model.ErrCastingType)
+		return
+	}
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		s.writeError(rw, r, model.ErrTransferNotFound)
+		return
+	}
+
+	transfer, err := s.service.ReverseTransfer(r.Context(), id, admin)
+	if err != nil {
+		s.writeError(rw, r, err)
+		return
+	}
+	s.writeJSON(rw, r, http.StatusOK, transfer)
+}
@@ -0,0 +1,154 @@
+package hasher
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	SchemeBcrypt   = "bcrypt"
+	SchemeArgon2id = "argon2id"
+
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+var (
+	ErrUnknownScheme = errors.New("unknown password hash scheme")
+	ErrWrongFormat   = errors.New("wrong password hash format")
+)
+
+// Параметры хэширования паролей
+type Params struct {
+	Scheme        string
+	BcryptCost    int
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+type Hasher struct {
+	params Params
+}
+
+func NewHasher(params Params) (*Hasher, error) {
+	switch params.Scheme {
+	case SchemeBcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost %d out of range", params.BcryptCost)
+		}
+	case SchemeArgon2id:
+		if params.Argon2Time == 0 || params.Argon2Memory == 0 || params.Argon2Threads == 0 {
+			return nil, errors.New("argon2id params must be positive")
+		}
+	default:
+		return nil, ErrUnknownScheme
+	}
+	return &Hasher{params: params}, nil
+}
+
+// Хэш пароля в самоописываемом формате:
+// bcrypt - $2a$<cost>$..., argon2id - $argon2id$v=19$m=..,t=..,p=..$<salt>$<hash>
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.params.Scheme == SchemeBcrypt {
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.params.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(bytes), nil
+	}
+
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.params.Argon2Time,
+		h.params.Argon2Memory, h.params.Argon2Threads, argon2KeyLen)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", SchemeArgon2id, argon2.Version,
+		h.params.Argon2Memory, h.params.Argon2Time, h.params.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Проверить пароль по хэшу, схема определяется по самому хэшу
+func (h *Hasher) Verify(password string, encoded string) (bool, error) {
+	if !strings.HasPrefix(encoded, "$"+SchemeArgon2id+"$") {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	params, salt, key, err := decodeArgon2(encoded)
+	if err != nil {
+		return false, err
+	}
+	checkKey := argon2.IDKey([]byte(password), salt, params.Argon2Time,
+		params.Argon2Memory, params.Argon2Threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, checkKey) == 1, nil
+}
+
+// Хэш устарел: другая схема или другие параметры
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	if !strings.HasPrefix(encoded, "$"+SchemeArgon2id+"$") {
+		if h.params.Scheme != SchemeBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != h.params.BcryptCost
+	}
+
+	if h.params.Scheme != SchemeArgon2id {
+		return true
+	}
+	params, _, key, err := decodeArgon2(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Argon2Time != h.params.Argon2Time ||
+		params.Argon2Memory != h.params.Argon2Memory ||
+		params.Argon2Threads != h.params.Argon2Threads ||
+		len(key) != argon2KeyLen
+}
+
+func decodeArgon2(encoded string) (Params, []byte, []byte, error) {
+	var params Params
+	var version int
+
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Params{}, nil, nil, ErrWrongFormat
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return Params{}, nil, nil, ErrWrongFormat
+	}
+	if version != argon2.Version {
+		return Params{}, nil, nil, ErrWrongFormat
+	}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d",
+		&params.Argon2Memory, &params.Argon2Time, &params.Argon2Threads)
+	if err != nil {
+		return Params{}, nil, nil, ErrWrongFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, ErrWrongFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Params{}, nil, nil, ErrWrongFormat
+	}
+	params.Scheme = SchemeArgon2id
+	return params, salt, key, nil
+}
@@ -0,0 +1,75 @@
+package hasher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHasher_Rehash(t *testing.T) {
+	bcryptOld := Params{Scheme: SchemeBcrypt, BcryptCost: 4}
+	bcryptNew := Params{Scheme: SchemeBcrypt, BcryptCost: 5}
+	argonOld := Params{Scheme: SchemeArgon2id, Argon2Time: 1, Argon2Memory: 1024, Argon2Threads: 1}
+	argonNew := Params{Scheme: SchemeArgon2id, Argon2Time: 2, Argon2Memory: 1024, Argon2Threads: 1}
+
+	tests := []struct {
+		name        string
+		hashParams  Params
+		checkParams Params
+		wantRehash  bool
+	}{
+		{
+			name:        "bcrypt same cost",
+			hashParams:  bcryptOld,
+			checkParams: bcryptOld,
+			wantRehash:  false,
+		},
+		{
+			name:        "bcrypt cost changed",
+			hashParams:  bcryptOld,
+			checkParams: bcryptNew,
+			wantRehash:  true,
+		},
+		{
+			name:        "bcrypt to argon2id",
+			hashParams:  bcryptOld,
+			checkParams: argonOld,
+			wantRehash:  true,
+		},
+		{
+			name:        "argon2id same params",
+			hashParams:  argonOld,
+			checkParams: argonOld,
+			wantRehash:  false,
+		},
+		{
+			name:        "argon2id params changed",
+			hashParams:  argonOld,
+			checkParams: argonNew,
+			wantRehash:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := NewHasher(tt.hashParams)
+			require.NoError(t, err)
+			hash, err := h.Hash("1234")
+			require.NoError(t, err)
+
+			check, err := NewHasher(tt.checkParams)
+			require.NoError(t, err)
+
+			ok, err := check.Verify("1234", hash)
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			ok, err = check.Verify("12345", hash)
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			assert.Equal(t, tt.wantRehash, check.NeedsRehash(hash))
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -10,14 +11,97 @@ import (
 type User struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
+	Role     string `json:"-"`
+	Blocked  bool   `json:"-"`
+	// включена двухфакторная аутентификация
+	TOTPEnabled bool `json:"-"`
+}
+
+// Роли пользователей, partner - запросы партнеров по API ключу
+const (
+	RoleUser    = "user"
+	RoleAdmin   = "admin"
+	RolePartner = "partner"
+)
+
+// API ключ партнера. Сам ключ возвращается только при создании
+type APIKey struct {
+	ID          int64      `json:"id"`
+	Prefix      string     `json:"prefix"`
+	Key         string     `json:"key,omitempty"`
+	Partner     string     `json:"partner"`
+	Permissions []string   `json:"permissions"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Права API ключей
+const (
+	PermOrdersRead      = "orders:read"
+	PermOrdersWrite     = "orders:write"
+	PermBalanceRead     = "balance:read"
+	PermBalanceWrite    = "balance:write"
+	PermWithdrawalsRead = "withdrawals:read"
+	// отмена списаний, например при отмене заказа магазином
+	PermWithdrawalsReverse = "withdrawals:reverse"
+)
+
+var Permissions = map[string]bool{
+	PermOrdersRead:         true,
+	PermOrdersWrite:        true,
+	PermBalanceRead:        true,
+	PermBalanceWrite:       true,
+	PermWithdrawalsRead:    true,
+	PermWithdrawalsReverse: true,
 }
 
 // Структура прав доступа JWT
 type Token struct {
 	Login string
+	Role  string
+	// непустое назначение у промежуточных токенов, например при входе с 2FA
+	Purpose   string `json:",omitempty"`
+	SessionID string `json:",omitempty"`
 	jwt.StandardClaims
 }
 
+// Сессия пользователя, создается при каждом входе
+type Session struct {
+	ID        string    `json:"id"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Current   bool      `json:"current"`
+}
+
+const TokenPurpose2FA = "2fa"
+
+// Данные для подключения приложения-аутентификатора
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"otpauth_uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Код подтверждения 2FA, при входе вместе с токеном первого шага
+type OTPRequest struct {
+	ChallengeToken string `json:"challenge_token,omitempty"`
+	Code           string `json:"code"`
+}
+
+// Ограничение неверных кодов при входе с 2FA, 0 неверных кодов - без ограничения
+type OTPAttemptLimits struct {
+	MaxFailures int
+	Lockout     time.Duration
+}
+
+// Ответ на вход пользователя с включенной 2FA
+type LoginChallenge struct {
+	ChallengeToken string    `json:"challenge_token"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
 type OrdersResponse struct {
 	Number  string    `json:"number"`
 	Status  string    `json:"status"`
@@ -26,6 +110,83 @@ type OrdersResponse struct {
 	Login   string
 }
 
+// Статусы заказа в системе начислений
+const (
+	OrderNew        = "NEW"
+	OrderProcessing = "PROCESSING"
+	OrderInvalid    = "INVALID"
+	OrderProcessed  = "PROCESSED"
+)
+
+// Источники изменения статуса заказа
+const (
+	OrderSourceUpload  = "upload"
+	OrderSourcePoll    = "poll"
+	OrderSourceWebhook = "webhook"
+	OrderSourceAdmin   = "admin"
+)
+
+// Изменение статуса заказа
+type OrderStatusChange struct {
+	Status    string    `json:"status"`
+	Accrual   float64   `json:"accrual"`
+	Source    string    `json:"source"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Заказ с историей статусов и списанием по нему. Заказ, созданный
+// только списанием, не загружался пользователем и не имеет uploaded_at
+type OrderDetail struct {
+	Number     string              `json:"number"`
+	Status     string              `json:"status"`
+	Accrual    float64             `json:"accrual"`
+	UploadedAt *time.Time          `json:"uploaded_at,omitempty"`
+	History    []OrderStatusChange `json:"history"`
+	Withdrawal *OrderWithdraw      `json:"withdrawal,omitempty"`
+}
+
+// Событие по счету пользователя для потока /api/user/events
+type Event struct {
+	ID        int64           `json:"id"`
+	Login     string          `json:"login"`
+	Type      string          `json:"type"`
+	Data      json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Типы событий
+const (
+	EventOrderStatusChanged = "order.status_changed"
+	EventBalanceChanged     = "balance.changed"
+	EventWithdrawalCreated  = "withdrawal.created"
+	EventWithdrawalReversed = "withdrawal.reversed"
+)
+
+// Данные события изменения статуса заказа
+type OrderStatusEvent struct {
+	Number  string  `json:"number"`
+	Status  string  `json:"status"`
+	Accrual float64 `json:"accrual"`
+	Source  string  `json:"source"`
+}
+
+// Результат загрузки номера заказа из пакета
+type BatchOrderResult struct {
+	Number string `json:"number"`
+	Result string `json:"result"`
+	// код ответа, который вернула бы загрузка этого номера по одному
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Результаты загрузки номеров заказов пакетом
+const (
+	BatchAccepted  = "accepted"
+	BatchDuplicate = "duplicate"
+	BatchConflict  = "conflict"
+	BatchInvalid   = "invalid"
+)
+
 type PointsAppResponse struct {
 	Number  string  `json:"order"`
 	Status  string  `json:"status"`
@@ -36,11 +197,248 @@ type OrderWithdraw struct {
 	Number   string    `json:"order"`
 	Withdraw float64   `json:"sum"`
 	Time     time.Time `json:"processed_at"`
+	// стоимость заказа, если известна, для лимита доли заказа; не хранится
+	OrderValue float64 `json:"order_value,omitempty"`
+	// партнер, проводящий списание по API ключу; отменить его списание может только он
+	Partner string `json:"-"`
+	// возвращенная сумма и статус отмены, если списание отменялось
+	Reversed float64 `json:"reversed,omitempty"`
+	Status   string  `json:"status,omitempty"`
+}
+
+// Статусы отмененных списаний
+const (
+	WithdrawalReversed          = "REVERSED"
+	WithdrawalPartiallyReversed = "PARTIALLY_REVERSED"
+)
+
+// Запрос на отмену списания, без суммы - отмена всего остатка списания
+type ReversalRequest struct {
+	Sum    float64 `json:"sum,omitempty"`
+	Reason string  `json:"reason"`
 }
 
+// Отмена списания: баллы возвращаются на счет пользователя
+type Reversal struct {
+	ID     int64   `json:"id"`
+	Number string  `json:"order"`
+	Sum    float64 `json:"sum"`
+	// сколько еще можно вернуть по списанию
+	Remaining   float64   `json:"remaining"`
+	Reason      string    `json:"reason,omitempty"`
+	InitiatedBy string    `json:"initiated_by"`
+	Time        time.Time `json:"processed_at"`
+	// PENDING - отмена ждет утверждения корректировки AdjustmentID вторым администратором
+	Status       string `json:"status"`
+	AdjustmentID int64  `json:"adjustment_id,omitempty"`
+}
+
+// Статусы отмены списания
+const (
+	ReversalApplied = "APPLIED"
+	ReversalPending = "PENDING"
+)
+
+// Кто отменяет списание: администратор или партнер. Партнер может отменить только
+// свои списания. Отмена администратором больше ApprovalThreshold копеек
+// ждет утверждения вторым администратором, как и корректировки
+type ReversalInitiator struct {
+	Admin             string
+	Partner           string
+	ApprovalThreshold int64
+}
+
+// Пересмотр начисления по заказу в меньшую сторону. Debt - часть суммы,
+// которую не удалось списать с баланса, она гасится будущими начислениями
+type Clawback struct {
+	ID        int64     `json:"id"`
+	Login     string    `json:"login"`
+	Number    string    `json:"order"`
+	Status    string    `json:"status"`
+	Credited  float64   `json:"credited"`
+	Accrual   float64   `json:"accrual"`
+	Amount    float64   `json:"amount"`
+	Debt      float64   `json:"debt"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Лимиты списаний в рублях, доля заказа - от 0 до 1, 0 - без ограничения.
+// В переопределениях пользователя пустое поле означает значение по умолчанию
+type WithdrawalLimits struct {
+	Min               *float64 `json:"min,omitempty"`
+	MaxPerTransaction *float64 `json:"max_per_transaction,omitempty"`
+	MaxDaily          *float64 `json:"max_daily,omitempty"`
+	Max30Days         *float64 `json:"max_30d,omitempty"`
+	MaxOrderFraction  *float64 `json:"max_order_fraction,omitempty"`
+}
+
+// Лимиты пользователя: переопределенные администратором и действующие
+type UserWithdrawalLimits struct {
+	Overrides WithdrawalLimits `json:"overrides"`
+	Effective WithdrawalLimits `json:"effective"`
+}
+
+// Списания пользователя за скользящие 24 часа и 30 дней в копейках, включая
+// действующие удержания, и его переопределенные лимиты
+type WithdrawalUsage struct {
+	Overrides WithdrawalLimits
+	Daily     int64
+	Monthly   int64
+}
+
+// Проверка списания по лимитам, хранилище вызывает ее под блокировкой баланса
+type WithdrawalCheck func(usage WithdrawalUsage) error
+
+// Запрос на перевод баллов другому пользователю
+type TransferRequest struct {
+	Recipient string  `json:"recipient"`
+	Sum       float64 `json:"sum"`
+}
+
+type Transfer struct {
+	ID         int64      `json:"id"`
+	Sender     string     `json:"from"`
+	Recipient  string     `json:"to"`
+	Sum        float64    `json:"sum"`
+	Status     string     `json:"status"`
+	Time       time.Time  `json:"processed_at"`
+	ReversedBy string     `json:"reversed_by,omitempty"`
+	ReversedAt *time.Time `json:"reversed_at,omitempty"`
+}
+
+// Статусы переводов
+const (
+	TransferCompleted = "COMPLETED"
+	TransferReversed  = "REVERSED"
+)
+
+// Ограничения на переводы отправителя за последние сутки, 0 - без ограничения
+type TransferLimits struct {
+	DailyAmount int64
+	DailyCount  int
+}
+
+// Запрос на удержание баллов под заказ, TTL - длительность в формате Go (15m, 1h)
+type HoldRequest struct {
+	Number     string  `json:"order"`
+	Sum        float64 `json:"sum"`
+	TTL        string  `json:"ttl,omitempty"`
+	OrderValue float64 `json:"order_value,omitempty"`
+	// партнер, создающий удержание; списание при capture записывается на него
+	Partner string `json:"-"`
+}
+
+// Удержание баллов: уменьшает доступный баланс до списания (capture),
+// отмены (void) или истечения срока
+type Hold struct {
+	ID        int64     `json:"id"`
+	Number    string    `json:"order"`
+	Sum       float64   `json:"sum"`
+	Status    string    `json:"status"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	// партнер, создавший удержание
+	Partner string `json:"-"`
+}
+
+// Статусы удержаний
+const (
+	HoldActive   = "ACTIVE"
+	HoldCaptured = "CAPTURED"
+	HoldVoided   = "VOIDED"
+	HoldExpired  = "EXPIRED"
+)
+
+// Available - баланс за вычетом действующих удержаний (Held),
+// Expiring - баллы, которые сгорят в ближайшие 30 дней
 type Balance struct {
 	Balance   float64 `json:"current"`
+	Available float64 `json:"available"`
+	Held      float64 `json:"held"`
 	Withdrawn float64 `json:"withdrawn"`
+	Expiring  float64 `json:"expiring,omitempty"`
+}
+
+// Типы записей в истории операций по счету
+const (
+	TxAccrual    = "accrual"
+	TxWithdrawal = "withdrawal"
+	TxAdjustment = "adjustment"
+	TxReversal   = "reversal"
+	TxExpiry     = "expiry"
+	TxClawback   = "clawback"
+	// перевод другому пользователю, получение перевода и отмена перевода администратором
+	TxTransferOut      = "transfer_out"
+	TxTransferIn       = "transfer_in"
+	TxTransferReversal = "transfer_reversal"
+)
+
+// Операция по счету: сумма со знаком и баланс после нее
+type Transaction struct {
+	ID      int64   `json:"id"`
+	Type    string  `json:"type"`
+	Amount  float64 `json:"amount"`
+	Balance float64 `json:"balance"`
+	Order   string  `json:"order,omitempty"`
+	// перевод и второй его участник, для операций переводов
+	Transfer     int64     `json:"transfer,omitempty"`
+	Counterparty string    `json:"counterparty,omitempty"`
+	Time         time.Time `json:"processed_at"`
+}
+
+// Получатель выписки: баланс на начало периода, операции по порядку
+// и баланс на конец. Операции передаются по одной, без загрузки всей выписки в память
+type StatementWriter interface {
+	Opening(balance float64) error
+	Transaction(tx Transaction) error
+	Closing(balance float64) error
+}
+
+// Фильтр списков: период [From, To) и страница
+type ListFilter struct {
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Offset int
+}
+
+// Ручная корректировка баланса администратором
+type Adjustment struct {
+	ID    int64  `json:"id"`
+	Login string `json:"login"`
+	// списание, которое отменяет корректировка; при утверждении проводится как отмена списания
+	Number     string     `json:"order,omitempty"`
+	Type       string     `json:"type"`
+	Amount     float64    `json:"amount"`
+	Reason     string     `json:"reason_code"`
+	Comment    string     `json:"comment"`
+	Status     string     `json:"status"`
+	CreatedBy  string     `json:"created_by"`
+	ApprovedBy string     `json:"approved_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	DecidedAt  *time.Time `json:"decided_at,omitempty"`
+}
+
+// Направление и статусы корректировок
+const (
+	AdjustmentCredit = "credit"
+	AdjustmentDebit  = "debit"
+
+	AdjustmentPending  = "PENDING"
+	AdjustmentApplied  = "APPLIED"
+	AdjustmentRejected = "REJECTED"
+)
+
+// Коды причин корректировок
+// Код причины корректировок, созданных отменой списания; вручную его не задать
+const AdjustmentReasonReversal = "WITHDRAWAL_REVERSAL"
+
+var AdjustmentReasons = map[string]bool{
+	"COMPENSATION":       true,
+	"ACCRUAL_CORRECTION": true,
+	"GOODWILL":           true,
+	"FRAUD":              true,
+	"OTHER":              true,
 }
 
 //описать ошибки для разных кодов ответа
@@ -56,6 +454,38 @@ var (
 	ErrInsufficientBalance = errors.New("insufficient funds")
 	ErrNoWithdrawals       = errors.New("no withdrawals")
 	ErrCastingType         = errors.New("casting types error")
+	ErrUserBlocked         = errors.New("user is blocked")
+	ErrForbidden           = errors.New("access denied")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrOrderNotFound       = errors.New("order not found")
+	ErrOrderProcessed      = errors.New("order has already been processed")
+	ErrWrongReasonCode     = errors.New("unknown adjustment reason code")
+	ErrAdjustmentNotFound  = errors.New("adjustment not found")
+	ErrAdjustmentDecided   = errors.New("adjustment has already been decided")
+	ErrSameApprover        = errors.New("adjustment must be approved by another admin")
+	ErrWrongPermission     = errors.New("unknown api key permission")
+	ErrAPIKeyNotFound      = errors.New("api key not found")
+	ErrTOTPNotEnrolled     = errors.New("two-factor authentication is not enrolled")
+	ErrTOTPEnabled         = errors.New("two-factor authentication is already enabled")
+	ErrInvalidOTP          = errors.New("invalid one-time code")
+	ErrTOTPLocked          = errors.New("too many invalid one-time codes")
+	ErrStepUpRequired      = errors.New("one-time code required for this operation")
+	ErrSessionNotFound     = errors.New("session not found or terminated")
+	ErrWrongContentType    = errors.New("unsupported content type")
+	ErrCSRFFailed          = errors.New("csrf token is missing or invalid")
+	ErrNoOrders            = errors.New("no orders")
+	ErrBodyTooLarge        = errors.New("request body too large")
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+	ErrWithdrawalNotFound  = errors.New("withdrawal not found")
+	ErrReversalExceeded    = errors.New("reversal exceeds the amount left to reverse")
+	ErrHoldNotFound        = errors.New("hold not found")
+	ErrHoldNotActive       = errors.New("hold is not active")
+	ErrRecipientNotFound   = errors.New("recipient not found")
+	ErrRecipientBlocked    = errors.New("recipient is blocked")
+	ErrTransferLimit       = errors.New("daily transfer limit exceeded")
+	ErrWithdrawalLimit     = errors.New("withdrawal limit exceeded")
+	ErrTransferNotFound    = errors.New("transfer not found")
+	ErrTransferReversed    = errors.New("transfer has already been reversed")
 
 	Secretkey = []byte("secret key")
 )
@@ -64,4 +494,10 @@ type keyLogin string
 
 const (
 	KeyLogin keyLogin = "login"
+	KeyRole  keyLogin = "role"
+	// идентификатор сессии токена
+	KeySession keyLogin = "session"
+	// партнер и права API ключа, если запрос выполнен по ключу
+	KeyPartner     keyLogin = "partner"
+	KeyPermissions keyLogin = "permissions"
 )
@@ -0,0 +1,308 @@
+// Пакет openapi содержит спецификацию API сервиса и проверку запросов
+// и ответов по ней
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// ответ сервиса не соответствует спецификации
+var ErrContract = errors.New("response does not match api contract")
+
+// Спецификация OpenAPI в формате JSON
+func Spec() []byte {
+	return spec
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Ref      string               `json:"$ref"`
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Ref     string               `json:"$ref"`
+	Content map[string]mediaType `json:"content"`
+}
+
+type operation struct {
+	Parameters  []parameter         `json:"parameters"`
+	RequestBody *requestBody        `json:"requestBody"`
+	Responses   map[string]response `json:"responses"`
+}
+
+type document struct {
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components struct {
+		Schemas       map[string]*Schema     `json:"schemas"`
+		Parameters    map[string]parameter   `json:"parameters"`
+		RequestBodies map[string]requestBody `json:"requestBodies"`
+		Responses     map[string]response    `json:"responses"`
+	} `json:"components"`
+}
+
+// шаблон пути из спецификации, сегменты вида {login} совпадают с любым значением
+type route struct {
+	segments   []string
+	operations map[string]operation
+}
+
+// Проверка запросов и ответов по спецификации
+type Validator struct {
+	schemas map[string]*Schema
+	routes  []route
+}
+
+func NewValidator() (*Validator, error) {
+	var doc document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+
+	v := &Validator{schemas: doc.Components.Schemas}
+	for path, item := range doc.Paths {
+		operations := make(map[string]operation, len(item))
+		for method, op := range item {
+			// ссылки на общие компоненты разрешаем сразу, кроме схем
+			for i, p := range op.Parameters {
+				if p.Ref != "" {
+					op.Parameters[i] = doc.Components.Parameters[refName(p.Ref)]
+				}
+			}
+			if op.RequestBody != nil && op.RequestBody.Ref != "" {
+				body, ok := doc.Components.RequestBodies[refName(op.RequestBody.Ref)]
+				if !ok {
+					return nil, fmt.Errorf("unknown reference %s", op.RequestBody.Ref)
+				}
+				op.RequestBody = &body
+			}
+			for status, resp := range op.Responses {
+				if resp.Ref != "" {
+					op.Responses[status] = doc.Components.Responses[refName(resp.Ref)]
+				}
+			}
+			operations[strings.ToUpper(method)] = op
+		}
+		v.routes = append(v.routes, route{
+			segments:   strings.Split(path, "/"),
+			operations: operations,
+		})
+	}
+	return v, nil
+}
+
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+// Найти операцию по методу и пути запроса. Если подходят несколько
+// шаблонов, выбирается шаблон с наибольшим числом совпавших сегментов
+func (v *Validator) find(method, path string) (operation, bool) {
+	segments := strings.Split(path, "/")
+	best, bestScore := -1, -1
+
+	for i, rt := range v.routes {
+		if len(rt.segments) != len(segments) {
+			continue
+		}
+		score := 0
+		for j, seg := range rt.segments {
+			if seg == segments[j] {
+				score++
+				continue
+			}
+			if !strings.HasPrefix(seg, "{") || segments[j] == "" {
+				score = -1
+				break
+			}
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return operation{}, false
+	}
+	op, ok := v.routes[best].operations[method]
+	return op, ok
+}
+
+// Описана ли операция в спецификации
+func (v *Validator) HasOperation(method, path string) bool {
+	_, ok := v.find(method, path)
+	return ok
+}
+
+// Проверить запрос: обязательные параметры, Content-Type и тело.
+// Запросы к неописанным путям не проверяются, на них ответит роутер
+func (v *Validator) ValidateRequest(r *http.Request, body []byte) error {
+	op, ok := v.find(r.Method, r.URL.Path)
+	if !ok {
+		return nil
+	}
+
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var value string
+		var present bool
+		switch p.In {
+		case "query":
+			value, present = query.Get(p.Name), query.Has(p.Name)
+		case "header":
+			value = r.Header.Get(p.Name)
+			present = value != ""
+		default:
+			continue
+		}
+		if !present {
+			if p.Required {
+				return fmt.Errorf("%w: parameter %s is required", model.ErrWrongRequest, p.Name)
+			}
+			continue
+		}
+		if err := v.validateParam(value, p.Schema, p.Name); err != nil {
+			return fmt.Errorf("%w: %v", model.ErrWrongRequest, err)
+		}
+	}
+
+	if op.RequestBody == nil {
+		return nil
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			return fmt.Errorf("%w: request body is required", model.ErrWrongRequest)
+		}
+		return nil
+	}
+
+	media, ok := lookupMedia(op.RequestBody.Content, r.Header.Get("Content-Type"))
+	if !ok {
+		return model.ErrWrongContentType
+	}
+	if err := v.validateBody(body, media, r.Header.Get("Content-Type")); err != nil {
+		return fmt.Errorf("%w: %v", model.ErrWrongRequest, err)
+	}
+	return nil
+}
+
+// Проверить ответ: статус должен быть описан, тело - соответствовать схеме.
+// Пустое тело допускается всегда, например для 204 и ответа на вход без 2FA
+func (v *Validator) ValidateResponse(method, path string, status int, header http.Header, body []byte) error {
+	if len(body) == 0 {
+		_, _, err := v.responseMedia(method, path, status, header, false)
+		return err
+	}
+
+	media, ok, err := v.responseMedia(method, path, status, header, true)
+	if err != nil || !ok {
+		return err
+	}
+	if err = v.validateBody(body, media, header.Get("Content-Type")); err != nil {
+		return fmt.Errorf("%w: %s %s %d: %v", ErrContract, method, path, status, err)
+	}
+	return nil
+}
+
+// Проверить статус и Content-Type потокового ответа, тело которого
+// передается клиенту по частям и целиком не проверяется
+func (v *Validator) ValidateResponseHeader(method, path string, status int, header http.Header) error {
+	_, _, err := v.responseMedia(method, path, status, header, true)
+	return err
+}
+
+// Найти описание тела ответа. ok - операция описана в спецификации
+func (v *Validator) responseMedia(method, path string, status int, header http.Header,
+	withBody bool) (media mediaType, ok bool, err error) {
+
+	op, ok := v.find(method, path)
+	if !ok {
+		return mediaType{}, false, nil
+	}
+
+	resp, found := op.Responses[strconv.Itoa(status)]
+	if !found {
+		if resp, found = op.Responses["default"]; !found {
+			return mediaType{}, true, fmt.Errorf("%w: status %d is not described for %s %s",
+				ErrContract, status, method, path)
+		}
+	}
+	if !withBody {
+		return mediaType{}, true, nil
+	}
+	if len(resp.Content) == 0 {
+		return mediaType{}, true, fmt.Errorf("%w: %s %s %d must not have a body", ErrContract, method, path, status)
+	}
+
+	contentType := header.Get("Content-Type")
+	media, found = lookupMedia(resp.Content, contentType)
+	if !found {
+		return mediaType{}, true, fmt.Errorf("%w: %s %s %d: unexpected Content-Type %q",
+			ErrContract, method, path, status, contentType)
+	}
+	return media, true, nil
+}
+
+// Найти описание тела по Content-Type без учета параметров, например charset
+func lookupMedia(content map[string]mediaType, contentType string) (mediaType, bool) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return mediaType{}, false
+	}
+	media, ok := content[mt]
+	return media, ok
+}
+
+func (v *Validator) validateBody(body []byte, media mediaType, contentType string) error {
+	if media.Schema == nil {
+		return nil
+	}
+	mt, _, _ := mime.ParseMediaType(contentType)
+	if mt != "application/json" && !strings.HasSuffix(mt, "+json") {
+		return v.validate(string(body), media.Schema, "body")
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("body is not valid JSON: %v", err)
+	}
+	return v.validate(value, media.Schema, "body")
+}
+
+// значения параметров приходят строками, числа приводим к типу схемы
+func (v *Validator) validateParam(value string, schema *Schema, name string) error {
+	if schema == nil {
+		return nil
+	}
+	schema = v.resolve(schema)
+	if schema.Type == "integer" || schema.Type == "number" {
+		return v.validate(json.Number(value), schema, name)
+	}
+	return v.validate(value, schema, name)
+}
@@ -0,0 +1,168 @@
+package openapi_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/handlers"
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/openapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Каждый маршрут роутера должен быть описан в спецификации
+func TestSpecCoversRouter(t *testing.T) {
+	v, err := openapi.NewValidator()
+	require.NoError(t, err)
+
+	router := handlers.NewRouter(nil, logger.InitLog(), config.Config{})
+	err = chi.Walk(router, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		assert.True(t, v.HasOperation(method, route), "%s %s is not described", method, route)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestValidateRequest(t *testing.T) {
+	v, err := openapi.NewValidator()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		wantErr     error
+	}{
+		{
+			name:        "valid credentials",
+			method:      http.MethodPost,
+			path:        "/api/user/register",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"login": "user", "password": "1234"}`,
+		},
+		{
+			name:        "missing password",
+			method:      http.MethodPost,
+			path:        "/api/user/login",
+			contentType: "application/json",
+			body:        `{"login": "user"}`,
+			wantErr:     model.ErrWrongRequest,
+		},
+		{
+			name:        "wrong content type",
+			method:      http.MethodPost,
+			path:        "/api/user/orders",
+			contentType: "application/json",
+			body:        `12345678903`,
+			wantErr:     model.ErrWrongContentType,
+		},
+		{
+			name:        "sum is not a number",
+			method:      http.MethodPost,
+			path:        "/api/user/balance/withdraw",
+			contentType: "application/json",
+			body:        `{"order": "2377225624", "sum": "751"}`,
+			wantErr:     model.ErrWrongRequest,
+		},
+		{
+			name:    "unknown adjustment status",
+			method:  http.MethodGet,
+			path:    "/api/admin/adjustments?status=DONE",
+			wantErr: model.ErrWrongRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			err := v.ValidateRequest(r, []byte(tt.body))
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	v, err := openapi.NewValidator()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		path        string
+		status      int
+		contentType string
+		body        string
+		wantErr     bool
+	}{
+		{
+			name:        "orders",
+			path:        "/api/user/orders",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `[{"number": "9278923470", "status": "PROCESSED", "accrual": 500, "uploaded_at": "2020-12-10T15:15:45+03:00"}]`,
+		},
+		{
+			name:        "unknown order status",
+			path:        "/api/user/orders",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `[{"number": "9278923470", "status": "DONE", "uploaded_at": "2020-12-10T15:15:45+03:00"}]`,
+			wantErr:     true,
+		},
+		{
+			name:        "balance without withdrawn",
+			path:        "/api/user/balance",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"current": 500.5}`,
+			wantErr:     true,
+		},
+		{
+			name:   "no content",
+			path:   "/api/user/withdrawals",
+			status: http.StatusNoContent,
+		},
+		{
+			name:        "problem",
+			path:        "/api/user/balance",
+			status:      http.StatusUnauthorized,
+			contentType: "application/problem+json",
+			body:        `{"type": "urn:gophermart:problem:unauthorized", "title": "Authentication required", "status": 401, "code": "unauthorized"}`,
+		},
+		{
+			name:        "error as plain text",
+			path:        "/api/user/balance",
+			status:      http.StatusInternalServerError,
+			contentType: "text/plain",
+			body:        "internal error",
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Content-Type", tt.contentType)
+			err := v.ValidateResponse(http.MethodGet, tt.path, tt.status, header, []byte(tt.body))
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, openapi.ErrContract)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// Схема JSON Schema в объеме, который используется в спецификации
+type Schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format"`
+	Enum       []interface{}      `json:"enum"`
+	Required   []string           `json:"required"`
+	Properties map[string]*Schema `json:"properties"`
+	Items      *Schema            `json:"items"`
+	MinLength  *int               `json:"minLength"`
+	Minimum    *float64           `json:"minimum"`
+	Pattern    string             `json:"pattern"`
+}
+
+func (v *Validator) resolve(s *Schema) *Schema {
+	for s.Ref != "" {
+		next, ok := v.schemas[refName(s.Ref)]
+		if !ok {
+			return &Schema{}
+		}
+		s = next
+	}
+	return s
+}
+
+// Проверить значение, полученное json.Decoder с UseNumber, по схеме
+func (v *Validator) validate(value interface{}, s *Schema, path string) error {
+	s = v.resolve(s)
+
+	if value == nil {
+		if s.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s must be %s, got null", path, s.Type)
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be object", path)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		for name, prop := range s.Properties {
+			field, ok := obj[name]
+			if !ok {
+				continue
+			}
+			if err := v.validate(field, prop, path+"."+name); err != nil {
+				return err
+			}
+		}
+
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be array", path)
+		}
+		if s.Items == nil {
+			break
+		}
+		for i, item := range items {
+			if err := v.validate(item, s.Items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be string", path)
+		}
+		if s.MinLength != nil && utf8.RuneCountInString(str) < *s.MinLength {
+			return fmt.Errorf("%s must be at least %d characters", path, *s.MinLength)
+		}
+		if s.Pattern != "" {
+			matched, err := regexp.MatchString(s.Pattern, str)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				return fmt.Errorf("%s must match %s", path, s.Pattern)
+			}
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("%s must be date-time", path)
+			}
+		}
+
+	case "number", "integer":
+		num, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s must be %s", path, s.Type)
+		}
+		if s.Type == "integer" {
+			if _, err := num.Int64(); err != nil {
+				return fmt.Errorf("%s must be integer", path)
+			}
+		}
+		f, err := num.Float64()
+		if err != nil {
+			return fmt.Errorf("%s must be %s", path, s.Type)
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return fmt.Errorf("%s must be at least %v", path, *s.Minimum)
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be boolean", path)
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s must be one of %v", path, s.Enum)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"math"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Создать корректировку баланса. Корректировки больше порога ждут
+// утверждения вторым администратором, остальные проводятся сразу
+func (s ServiceStruct) CreateAdjustment(ctx context.Context, adj model.Adjustment,
+	admin string) (model.Adjustment, error) {
+
+	// переводим в копейки, сумма меньше копейки не проходит проверку
+	amount := int64(math.Round(adj.Amount * 100))
+	if adj.Login == "" || adj.Comment == "" || amount <= 0 {
+		s.Log.Error(model.ErrWrongRequest.Error())
+		return model.Adjustment{}, model.ErrWrongRequest
+	}
+	if adj.Type != model.AdjustmentCredit && adj.Type != model.AdjustmentDebit {
+		s.Log.Error(model.ErrWrongRequest.Error())
+		return model.Adjustment{}, model.ErrWrongRequest
+	}
+	if !model.AdjustmentReasons[adj.Reason] {
+		s.Log.WithFields(logrus.Fields{"reason": adj.Reason}).Error(model.ErrWrongReasonCode.Error())
+		return model.Adjustment{}, model.ErrWrongReasonCode
+	}
+
+	// копейки со знаком
+	if adj.Type == model.AdjustmentDebit {
+		amount = -amount
+	}
+	apply := adj.Amount <= s.cfg.AdjustmentThreshold
+
+	adj.CreatedBy = admin
+	s.Log.WithFields(logrus.Fields{
+		"login":  adj.Login,
+		"amount": amount,
+		"apply":  apply,
+	}).Info("Корректировка баланса")
+	return s.storage.AddAdjustment(ctx, adj, amount, apply)
+}
+
+func (s ServiceStruct) DecideAdjustment(ctx context.Context, id int64, admin string,
+	approve bool) (model.Adjustment, error) {
+	return s.storage.DecideAdjustment(ctx, id, admin, approve)
+}
+
+func (s ServiceStruct) GetAdjustments(ctx context.Context, status string) ([]model.Adjustment, error) {
+	return s.storage.GetAdjustments(ctx, status)
+}
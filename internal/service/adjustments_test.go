@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// хранилище запоминает корректировку, которую сервис передал на запись
+type adjustmentStorer struct {
+	Storer
+	amount int64
+	apply  bool
+}
+
+func (a *adjustmentStorer) AddAdjustment(ctx context.Context, adj model.Adjustment, amount int64,
+	apply bool) (model.Adjustment, error) {
+	a.amount = amount
+	a.apply = apply
+	return adj, nil
+}
+
+func TestCreateAdjustment(t *testing.T) {
+	adjustment := func(adjType string, amount float64, reason string) model.Adjustment {
+		return model.Adjustment{Login: "user", Type: adjType, Amount: amount, Reason: reason, Comment: "ticket 42"}
+	}
+
+	tests := []struct {
+		name    string
+		adj     model.Adjustment
+		wantErr error
+		amount  int64
+		apply   bool
+	}{
+		{name: "credit below threshold", adj: adjustment(model.AdjustmentCredit, 100.5, "GOODWILL"),
+			amount: 10050, apply: true},
+		{name: "debit at threshold", adj: adjustment(model.AdjustmentDebit, 1000, "FRAUD"),
+			amount: -100000, apply: true},
+		{name: "above threshold waits for approval", adj: adjustment(model.AdjustmentCredit, 1000.01, "COMPENSATION"),
+			amount: 100001, apply: false},
+		{name: "unknown reason", adj: adjustment(model.AdjustmentCredit, 10, "BIRTHDAY"),
+			wantErr: model.ErrWrongReasonCode},
+		{name: "no reason", adj: adjustment(model.AdjustmentCredit, 10, ""), wantErr: model.ErrWrongReasonCode},
+		{name: "unknown type", adj: adjustment("bonus", 10, "OTHER"), wantErr: model.ErrWrongRequest},
+		{name: "no comment", adj: model.Adjustment{Login: "user", Type: model.AdjustmentCredit, Amount: 10,
+			Reason: "OTHER"}, wantErr: model.ErrWrongRequest},
+		{name: "negative amount", adj: adjustment(model.AdjustmentCredit, -10, "OTHER"), wantErr: model.ErrWrongRequest},
+		{name: "less than a kopeck", adj: adjustment(model.AdjustmentCredit, 0.004, "OTHER"),
+			wantErr: model.ErrWrongRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &adjustmentStorer{}
+			s := ServiceStruct{
+				storage: storage,
+				cfg:     config.Config{AdjustmentThreshold: 1000},
+				Log:     logger.InitLog(),
+			}
+
+			adj, err := s.CreateAdjustment(context.Background(), tt.adj, "admin")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.amount, storage.amount)
+			assert.Equal(t, tt.apply, storage.apply)
+			assert.Equal(t, "admin", adj.CreatedBy)
+		})
+	}
+}
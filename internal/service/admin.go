@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Проверить, что пользователь из токена существует и не заблокирован
+func (s ServiceStruct) CheckUserAccess(ctx context.Context, login string) (model.User, error) {
+	user, err := s.storage.GetUser(ctx, login)
+	if err != nil {
+		if errors.Is(err, model.ErrUserNotFound) {
+			return model.User{}, model.ErrNotAuthorized
+		}
+		return model.User{}, err
+	}
+	if user.Blocked {
+		s.Log.WithFields(logrus.Fields{"user": login}).Error(model.ErrUserBlocked.Error())
+		return model.User{}, model.ErrUserBlocked
+	}
+	return user, nil
+}
+
+func (s ServiceStruct) GetUser(ctx context.Context, login string) (model.User, error) {
+	return s.storage.GetUser(ctx, login)
+}
+
+func (s ServiceStruct) BlockUser(ctx context.Context, login string, blocked bool) error {
+	s.Log.WithFields(logrus.Fields{
+		"user":    login,
+		"blocked": blocked,
+	}).Info("Изменение блокировки пользователя")
+	return s.storage.SetUserBlocked(ctx, login, blocked)
+}
+
+func (s ServiceStruct) RequeueOrder(ctx context.Context, number string, reverify bool) error {
+	return s.storage.RequeueOrder(ctx, number, reverify)
+}
+
+// Создать администратора или выдать права администратора существующему пользователю,
+// пароль существующего пользователя заменяется указанным.
+// Используется только из командной строки
+func CreateAdmin(ctx context.Context, storage Storer, log *logrus.Logger, cfg config.Config, user model.User) error {
+	passwordHasher, err := newPasswordHasher(cfg)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	hash, err := passwordHasher.Hash(user.Password)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	err = storage.AddUser(ctx, model.User{Login: user.Login, Password: hash})
+	if errors.Is(err, model.ErrLoginExists) {
+		log.WithFields(logrus.Fields{"user": user.Login}).
+			Info("Пользователь уже существует, пароль заменяется указанным")
+		err = storage.UpdatePassword(ctx, user.Login, hash)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = storage.SetUserRole(ctx, user.Login, model.RoleAdmin); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{"user": user.Login}).Info("Пользователю выданы права администратора")
+	return nil
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Ключ имеет вид gm_<префикс>_<секрет>. Префикс хранится открыто и
+// виден в списке ключей, от всего ключа хранится только хэш
+const (
+	apiKeyTag       = "gm"
+	apiKeyPrefixLen = 8
+	apiKeySecretLen = 32
+)
+
+func (s ServiceStruct) CreateAPIKey(ctx context.Context, key model.APIKey) (model.APIKey, error) {
+	if key.Partner == "" || len(key.Permissions) == 0 {
+		s.Log.Error(model.ErrWrongRequest.Error())
+		return model.APIKey{}, model.ErrWrongRequest
+	}
+	for _, perm := range key.Permissions {
+		if !model.Permissions[perm] {
+			s.Log.WithFields(logrus.Fields{"permission": perm}).Error(model.ErrWrongPermission.Error())
+			return model.APIKey{}, model.ErrWrongPermission
+		}
+	}
+
+	prefix := make([]byte, apiKeyPrefixLen/2)
+	if _, err := rand.Read(prefix); err != nil {
+		return model.APIKey{}, err
+	}
+	secret := make([]byte, apiKeySecretLen)
+	if _, err := rand.Read(secret); err != nil {
+		return model.APIKey{}, err
+	}
+
+	key.Prefix = hex.EncodeToString(prefix)
+	rawKey := apiKeyTag + "_" + key.Prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+
+	key, err := s.storage.AddAPIKey(ctx, key, hashAPIKey(rawKey))
+	if err != nil {
+		return model.APIKey{}, err
+	}
+	key.Key = rawKey
+	return key, nil
+}
+
+// Проверить API ключ из заголовка Authorization
+func (s ServiceStruct) AuthAPIKey(ctx context.Context, rawKey string) (model.APIKey, error) {
+	parts := strings.SplitN(rawKey, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyTag {
+		return model.APIKey{}, model.ErrNotAuthorized
+	}
+
+	key, hash, err := s.storage.GetAPIKey(ctx, parts[1])
+	if err != nil {
+		if errors.Is(err, model.ErrAPIKeyNotFound) {
+			return model.APIKey{}, model.ErrNotAuthorized
+		}
+		return model.APIKey{}, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(hashAPIKey(rawKey))) != 1 {
+		s.Log.WithFields(logrus.Fields{"prefix": parts[1]}).Error("Неверный API ключ")
+		return model.APIKey{}, model.ErrNotAuthorized
+	}
+	return key, nil
+}
+
+func (s ServiceStruct) GetAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	return s.storage.GetAPIKeys(ctx)
+}
+
+func (s ServiceStruct) RevokeAPIKey(ctx context.Context, id int64) error {
+	return s.storage.RevokeAPIKey(ctx, id)
+}
+
+// ключ содержит 256 бит случайных данных, поэтому медленный хэш не нужен
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// хранилище ключей в памяти, отозванные ключи не находятся, как и в базе
+type apiKeyStorer struct {
+	Storer
+	keys    map[string]model.APIKey
+	hashes  map[string]string
+	revoked map[string]bool
+}
+
+func (a *apiKeyStorer) AddAPIKey(ctx context.Context, key model.APIKey, hash string) (model.APIKey, error) {
+	a.keys[key.Prefix] = key
+	a.hashes[key.Prefix] = hash
+	return key, nil
+}
+
+func (a *apiKeyStorer) GetAPIKey(ctx context.Context, prefix string) (model.APIKey, string, error) {
+	key, ok := a.keys[prefix]
+	if !ok || a.revoked[prefix] {
+		return model.APIKey{}, "", model.ErrAPIKeyNotFound
+	}
+	return key, a.hashes[prefix], nil
+}
+
+func TestAuthAPIKey(t *testing.T) {
+	storage := &apiKeyStorer{
+		keys:    map[string]model.APIKey{},
+		hashes:  map[string]string{},
+		revoked: map[string]bool{},
+	}
+	s := ServiceStruct{storage: storage, Log: logger.InitLog()}
+	ctx := context.Background()
+
+	_, err := s.CreateAPIKey(ctx, model.APIKey{Partner: "pos", Permissions: []string{"orders:delete"}})
+	assert.ErrorIs(t, err, model.ErrWrongPermission)
+
+	key, err := s.CreateAPIKey(ctx, model.APIKey{Partner: "pos", Permissions: []string{model.PermOrdersWrite}})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(key.Key, "gm_"+key.Prefix+"_"))
+	// открытый ключ не хранится
+	assert.NotContains(t, storage.hashes[key.Prefix], key.Key)
+
+	revoked, err := s.CreateAPIKey(ctx, model.APIKey{Partner: "old", Permissions: []string{model.PermOrdersRead}})
+	require.NoError(t, err)
+	storage.revoked[revoked.Prefix] = true
+
+	secret := strings.TrimPrefix(key.Key, "gm_"+key.Prefix+"_")
+	wrong := []byte(secret)
+	if wrong[0] == 'A' {
+		wrong[0] = 'B'
+	} else {
+		wrong[0] = 'A'
+	}
+	tests := []struct {
+		name    string
+		rawKey  string
+		wantErr error
+	}{
+		{name: "valid", rawKey: key.Key},
+		{name: "wrong secret", rawKey: "gm_" + key.Prefix + "_" + string(wrong), wantErr: model.ErrNotAuthorized},
+		{name: "other tag", rawKey: "xx_" + key.Prefix + "_" + secret, wantErr: model.ErrNotAuthorized},
+		{name: "no secret", rawKey: "gm_" + key.Prefix, wantErr: model.ErrNotAuthorized},
+		{name: "unknown prefix", rawKey: "gm_00000000_" + secret, wantErr: model.ErrNotAuthorized},
+		{name: "revoked", rawKey: revoked.Key, wantErr: model.ErrNotAuthorized},
+		{name: "empty", rawKey: "", wantErr: model.ErrNotAuthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.AuthAPIKey(ctx, tt.rawKey)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "pos", got.Partner)
+			assert.Equal(t, []string{model.PermOrdersWrite}, got.Permissions)
+		})
+	}
+}
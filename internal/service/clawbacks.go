@@ -0,0 +1,11 @@
+package service
+
+import (
+	"context"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+func (s ServiceStruct) GetClawbacks(ctx context.Context, openOnly bool) ([]model.Clawback, error) {
+	return s.storage.GetClawbacks(ctx, openOnly)
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// размер очереди событий подписчика; отстающий подписчик отключается
+// и догоняет по Last-Event-ID после переподключения
+const subscriberBuffer = 64
+
+// Подписчики на события этого экземпляра сервиса
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan model.Event]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[string]map[chan model.Event]struct{})}
+}
+
+func (h *eventHub) subscribe(login string) chan model.Event {
+	ch := make(chan model.Event, subscriberBuffer)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[login] == nil {
+		h.subscribers[login] = make(map[chan model.Event]struct{})
+	}
+	h.subscribers[login][ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(login string, ch chan model.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(login, ch)
+}
+
+// вызывается под блокировкой
+func (h *eventHub) remove(login string, ch chan model.Event) {
+	subs, ok := h.subscribers[login]
+	if !ok {
+		return
+	}
+	if _, ok = subs[ch]; !ok {
+		return
+	}
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(h.subscribers, login)
+	}
+}
+
+// Разослать событие подписчикам пользователя без ожидания
+func (h *eventHub) publish(event model.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers[event.Login] {
+		select {
+		case ch <- event:
+		default:
+			h.remove(event.Login, ch)
+		}
+	}
+}
+
+// Получать события всех экземпляров из Postgres, переподключаясь при обрыве
+func (s ServiceStruct) ListenEvents(ctx context.Context) {
+	for {
+		err := s.storage.ListenEvents(ctx, s.events.publish)
+		if ctx.Err() != nil {
+			return
+		}
+		s.Log.WithFields(logrus.Fields{"error": err}).Error("Подписка на события прервана")
+
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Удалять события старше срока хранения, за который клиент может их догнать
+func (s ServiceStruct) cleanupEvents(ctx context.Context) {
+	if s.cfg.EventRetention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := s.storage.DeleteEvents(ctx, time.Now().Add(-s.cfg.EventRetention))
+			if err != nil {
+				continue
+			}
+			s.Log.WithFields(logrus.Fields{"events": n}).Info("Удалены старые события")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Подписаться на события пользователя. Если lastID больше нуля, возвращаются
+// пропущенные после него события, не больше EventReplayLimit последних.
+// Подписка оформляется до чтения пропущенных событий, поэтому повторы
+// возможны, а пропуски нет: клиент отбрасывает события с id не больше уже полученного
+func (s ServiceStruct) SubscribeEvents(ctx context.Context, login string,
+	lastID int64) ([]model.Event, <-chan model.Event, func(), error) {
+
+	ch := s.events.subscribe(login)
+	cancel := func() { s.events.unsubscribe(login, ch) }
+
+	if lastID <= 0 {
+		return nil, ch, cancel, nil
+	}
+	replay, err := s.storage.GetEvents(ctx, login, lastID, s.cfg.EventReplayLimit)
+	if err != nil {
+		cancel()
+		return nil, nil, nil, err
+	}
+	return replay, ch, cancel, nil
+}
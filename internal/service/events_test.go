@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventHub(t *testing.T) {
+	hub := newEventHub()
+	user := hub.subscribe("user")
+	slow := hub.subscribe("user")
+	other := hub.subscribe("other")
+
+	// медленный подписчик не читает события и отключается при переполнении очереди
+	for i := 1; i <= subscriberBuffer+1; i++ {
+		hub.publish(model.Event{ID: int64(i), Login: "user"})
+		event := <-user
+		assert.Equal(t, int64(i), event.ID)
+	}
+	assert.Len(t, other, 0)
+
+	received := 0
+	for range slow {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+
+	hub.unsubscribe("user", user)
+	_, ok := <-user
+	assert.False(t, ok)
+	// повторная отписка безопасна
+	hub.unsubscribe("user", user)
+	hub.unsubscribe("user", slow)
+}
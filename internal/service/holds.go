@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// как часто снимать просроченные удержания
+const holdExpiryInterval = time.Minute
+
+// Удержать баллы под заказ. Без ttl удержание действует HoldTTL,
+// запросить больше HoldMaxTTL нельзя
+func (s ServiceStruct) CreateHold(ctx context.Context, login string, req model.HoldRequest) (model.Hold, error) {
+	if !utils.CheckLuhnAlg(req.Number) {
+		s.Log.Error(model.ErrNotValidOrderNumber.Error())
+		return model.Hold{}, model.ErrNotValidOrderNumber
+	}
+	amount := int64(math.Round(req.Sum * 100))
+	if amount <= 0 {
+		return model.Hold{}, fmt.Errorf("%w: sum must be positive", model.ErrWrongRequest)
+	}
+
+	ttl := s.cfg.HoldTTL
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil {
+			return model.Hold{}, fmt.Errorf("%w: ttl: %v", model.ErrWrongRequest, err)
+		}
+	}
+	if ttl <= 0 || ttl > s.cfg.HoldMaxTTL {
+		return model.Hold{}, fmt.Errorf("%w: ttl must be positive and not longer than %s",
+			model.ErrWrongRequest, s.cfg.HoldMaxTTL)
+	}
+
+	return s.storage.CreateHold(ctx, login, req.Number, req.Partner, amount, time.Now().Add(ttl),
+		s.withdrawalCheck(amount, req.OrderValue))
+}
+
+func (s ServiceStruct) CaptureHold(ctx context.Context, login string, id int64) (model.Hold, error) {
+	return s.storage.CaptureHold(ctx, login, id)
+}
+
+func (s ServiceStruct) VoidHold(ctx context.Context, login string, id int64) (model.Hold, error) {
+	return s.storage.VoidHold(ctx, login, id)
+}
+
+// Снимать просроченные удержания, баллы по ним снова становятся доступными
+func (s ServiceStruct) expireHolds(ctx context.Context) {
+	ticker := time.NewTicker(holdExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := s.storage.ExpireHolds(ctx)
+			if err != nil || n == 0 {
+				continue
+			}
+			s.Log.WithFields(logrus.Fields{"holds": n}).Info("Сняты просроченные удержания")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
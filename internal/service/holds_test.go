@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// хранилище запоминает удержание, которое сервис передал на запись
+type holdStorer struct {
+	Storer
+	amount    int64
+	expiresAt time.Time
+}
+
+func (h *holdStorer) CreateHold(ctx context.Context, login string, number string, partner string, amount int64,
+	expiresAt time.Time, check model.WithdrawalCheck) (model.Hold, error) {
+	h.amount = amount
+	h.expiresAt = expiresAt
+	return model.Hold{Number: number}, nil
+}
+
+func TestCreateHold(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     model.HoldRequest
+		wantErr error
+		amount  int64
+		ttl     time.Duration
+	}{
+		{
+			name:   "default ttl",
+			req:    model.HoldRequest{Number: "12345678903", Sum: 150.55},
+			amount: 15055,
+			ttl:    15 * time.Minute,
+		},
+		{
+			name:   "requested ttl",
+			req:    model.HoldRequest{Number: "12345678903", Sum: 10, TTL: "2h"},
+			amount: 1000,
+			ttl:    2 * time.Hour,
+		},
+		{
+			name:    "ttl above max",
+			req:     model.HoldRequest{Number: "12345678903", Sum: 10, TTL: "48h"},
+			wantErr: model.ErrWrongRequest,
+		},
+		{
+			name:    "invalid ttl",
+			req:     model.HoldRequest{Number: "12345678903", Sum: 10, TTL: "soon"},
+			wantErr: model.ErrWrongRequest,
+		},
+		{
+			name:    "zero sum",
+			req:     model.HoldRequest{Number: "12345678903"},
+			wantErr: model.ErrWrongRequest,
+		},
+		{
+			name:    "invalid order number",
+			req:     model.HoldRequest{Number: "12345678904", Sum: 10},
+			wantErr: model.ErrNotValidOrderNumber,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &holdStorer{}
+			s := ServiceStruct{
+				storage: storage,
+				cfg:     config.Config{HoldTTL: 15 * time.Minute, HoldMaxTTL: 24 * time.Hour},
+				Log:     logger.InitLog(),
+			}
+
+			start := time.Now()
+			_, err := s.CreateHold(context.Background(), "user", tt.req)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.amount, storage.amount)
+			assert.WithinDuration(t, start.Add(tt.ttl), storage.expiresAt, time.Second)
+		})
+	}
+}
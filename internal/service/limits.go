@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+// Лимиты списаний по умолчанию из конфигурации
+func (s ServiceStruct) defaultLimits() model.WithdrawalLimits {
+	return model.WithdrawalLimits{
+		Min:               &s.cfg.WithdrawMin,
+		MaxPerTransaction: &s.cfg.WithdrawMax,
+		MaxDaily:          &s.cfg.WithdrawDailyLimit,
+		Max30Days:         &s.cfg.Withdraw30DaysLimit,
+		MaxOrderFraction:  &s.cfg.WithdrawMaxOrderFraction,
+	}
+}
+
+// Действующие лимиты: переопределенные значения заменяют значения по умолчанию
+func effectiveLimits(defaults model.WithdrawalLimits, overrides model.WithdrawalLimits) model.WithdrawalLimits {
+	pick := func(override *float64, def *float64) *float64 {
+		if override != nil {
+			return override
+		}
+		return def
+	}
+	return model.WithdrawalLimits{
+		Min:               pick(overrides.Min, defaults.Min),
+		MaxPerTransaction: pick(overrides.MaxPerTransaction, defaults.MaxPerTransaction),
+		MaxDaily:          pick(overrides.MaxDaily, defaults.MaxDaily),
+		Max30Days:         pick(overrides.Max30Days, defaults.Max30Days),
+		MaxOrderFraction:  pick(overrides.MaxOrderFraction, defaults.MaxOrderFraction),
+	}
+}
+
+// Проверка списания amount копеек по действующим лимитам пользователя,
+// orderValue - стоимость заказа в рублях, 0 - неизвестна
+func (s ServiceStruct) withdrawalCheck(amount int64, orderValue float64) model.WithdrawalCheck {
+	defaults := s.defaultLimits()
+	return func(usage model.WithdrawalUsage) error {
+		return checkWithdrawalLimits(effectiveLimits(defaults, usage.Overrides), amount, orderValue, usage)
+	}
+}
+
+func checkWithdrawalLimits(limits model.WithdrawalLimits, amount int64, orderValue float64,
+	usage model.WithdrawalUsage) error {
+
+	kopecks := func(rubles *float64) int64 {
+		if rubles == nil {
+			return 0
+		}
+		return int64(math.Round(*rubles * 100))
+	}
+
+	if min := kopecks(limits.Min); min > 0 && amount < min {
+		return fmt.Errorf("%w: minimum withdrawal is %.2f", model.ErrWithdrawalLimit, *limits.Min)
+	}
+	if max := kopecks(limits.MaxPerTransaction); max > 0 && amount > max {
+		return fmt.Errorf("%w: maximum withdrawal is %.2f", model.ErrWithdrawalLimit, *limits.MaxPerTransaction)
+	}
+	if max := kopecks(limits.MaxDaily); max > 0 && usage.Daily+amount > max {
+		return fmt.Errorf("%w: maximum for 24 hours is %.2f, already withdrawn %.2f", model.ErrWithdrawalLimit,
+			*limits.MaxDaily, float64(usage.Daily)/100)
+	}
+	if max := kopecks(limits.Max30Days); max > 0 && usage.Monthly+amount > max {
+		return fmt.Errorf("%w: maximum for 30 days is %.2f, already withdrawn %.2f", model.ErrWithdrawalLimit,
+			*limits.Max30Days, float64(usage.Monthly)/100)
+	}
+	if limits.MaxOrderFraction != nil && *limits.MaxOrderFraction > 0 && orderValue > 0 {
+		if max := int64(math.Floor(orderValue * *limits.MaxOrderFraction * 100)); amount > max {
+			return fmt.Errorf("%w: maximum for this order is %.2f", model.ErrWithdrawalLimit, float64(max)/100)
+		}
+	}
+	return nil
+}
+
+// Лимиты пользователя: переопределенные администратором и действующие
+func (s ServiceStruct) GetWithdrawalLimits(ctx context.Context, login string) (model.UserWithdrawalLimits, error) {
+	overrides, err := s.storage.GetWithdrawalLimits(ctx, login)
+	if err != nil {
+		return model.UserWithdrawalLimits{}, err
+	}
+	return model.UserWithdrawalLimits{
+		Overrides: overrides,
+		Effective: effectiveLimits(s.defaultLimits(), overrides),
+	}, nil
+}
+
+func (s ServiceStruct) SetWithdrawalLimits(ctx context.Context, login string, limits model.WithdrawalLimits,
+	admin string) (model.UserWithdrawalLimits, error) {
+
+	for _, v := range []*float64{limits.Min, limits.MaxPerTransaction, limits.MaxDaily, limits.Max30Days} {
+		if v != nil && *v < 0 {
+			return model.UserWithdrawalLimits{}, fmt.Errorf("%w: limits must not be negative", model.ErrWrongRequest)
+		}
+	}
+	if f := limits.MaxOrderFraction; f != nil && (*f < 0 || *f > 1) {
+		return model.UserWithdrawalLimits{}, fmt.Errorf("%w: max_order_fraction must be between 0 and 1",
+			model.ErrWrongRequest)
+	}
+
+	if err := s.storage.SetWithdrawalLimits(ctx, login, limits, admin); err != nil {
+		return model.UserWithdrawalLimits{}, err
+	}
+	return model.UserWithdrawalLimits{
+		Overrides: limits,
+		Effective: effectiveLimits(s.defaultLimits(), limits),
+	}, nil
+}
+
+func (s ServiceStruct) DeleteWithdrawalLimits(ctx context.Context, login string) error {
+	return s.storage.DeleteWithdrawalLimits(ctx, login)
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckWithdrawalLimits(t *testing.T) {
+	rubles := func(v float64) *float64 { return &v }
+	limits := model.WithdrawalLimits{
+		Min:               rubles(10),
+		MaxPerTransaction: rubles(1000),
+		MaxDaily:          rubles(1500),
+		Max30Days:         rubles(5000),
+		MaxOrderFraction:  rubles(0.5),
+	}
+
+	tests := []struct {
+		name       string
+		limits     model.WithdrawalLimits
+		amount     int64
+		orderValue float64
+		usage      model.WithdrawalUsage
+		wantErr    bool
+	}{
+		{name: "within limits", limits: limits, amount: 50000},
+		{name: "no limits", amount: 100000000},
+		{name: "below min", limits: limits, amount: 999, wantErr: true},
+		{name: "above per transaction", limits: limits, amount: 100001, wantErr: true},
+		{name: "daily exceeded", limits: limits, amount: 60000,
+			usage: model.WithdrawalUsage{Daily: 100000, Monthly: 100000}, wantErr: true},
+		{name: "monthly exceeded", limits: limits, amount: 60000,
+			usage: model.WithdrawalUsage{Monthly: 450000}, wantErr: true},
+		{name: "order fraction exceeded", limits: limits, amount: 50001, orderValue: 1000, wantErr: true},
+		{name: "order fraction", limits: limits, amount: 50000, orderValue: 1000},
+		{name: "override disables default", amount: 100000000,
+			limits: effectiveLimits(limits, model.WithdrawalLimits{
+				MaxPerTransaction: rubles(0), MaxDaily: rubles(0), Max30Days: rubles(0),
+			})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkWithdrawalLimits(tt.limits, tt.amount, tt.orderValue, tt.usage)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, model.ErrWithdrawalLimit)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+// хранилище запоминает сумму списания в копейках
+type withdrawStorer struct {
+	Storer
+	withdraw float64
+	calls    int
+}
+
+func (w *withdrawStorer) WriteWithdraw(ctx context.Context, withdraw model.OrderWithdraw, login string,
+	check model.WithdrawalCheck) error {
+	w.withdraw = withdraw.Withdraw
+	w.calls++
+	return nil
+}
+
+func TestWriteWithdrawAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		sum      float64
+		wantErr  error
+		withdraw float64
+	}{
+		{name: "rubles and kopecks", sum: 100.5, withdraw: -10050},
+		{name: "one kopeck", sum: 0.01, withdraw: -1},
+		{name: "less than a kopeck", sum: 0.001, wantErr: model.ErrWrongRequest},
+		{name: "zero", sum: 0, wantErr: model.ErrWrongRequest},
+		{name: "negative", sum: -10, wantErr: model.ErrWrongRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &withdrawStorer{}
+			s := ServiceStruct{storage: storage, Log: logger.InitLog()}
+
+			err := s.WriteWithdraw(context.Background(), model.OrderWithdraw{Number: "79927398713", Withdraw: tt.sum}, "user")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Equal(t, 0, storage.calls)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.withdraw, storage.withdraw)
+		})
+	}
+}
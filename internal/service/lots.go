@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// как часто сжигать просроченные баллы
+	pointsExpiryInterval = time.Hour
+	// за какой срок баланс предупреждает о сгорании баллов
+	expiryNoticePeriod = 30 * 24 * time.Hour
+)
+
+// Конец дня (UTC) через expiryNoticePeriod: сумма к сгоранию в балансе
+// меняется только со сменой дня, поэтому день входит в ETag баланса
+func expiryNoticeUntil(now time.Time) time.Time {
+	return now.UTC().Truncate(24*time.Hour).AddDate(0, 0, 1).Add(expiryNoticePeriod)
+}
+
+// Баллы, начисленные раньше возвращаемого момента, к моменту at сгорают
+func (s ServiceStruct) expiryCutoff(at time.Time) time.Time {
+	return at.AddDate(0, -s.cfg.PointsExpiryMonths, 0)
+}
+
+// Сжигать остатки начислений старше POINTS_EXPIRY_MONTHS месяцев
+func (s ServiceStruct) expirePoints(ctx context.Context) {
+	if s.cfg.PointsExpiryMonths <= 0 {
+		return
+	}
+	ticker := time.NewTicker(pointsExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			expired, err := s.storage.ExpirePoints(ctx, s.expiryCutoff(time.Now()))
+			if err != nil || expired == 0 {
+				continue
+			}
+			s.Log.WithFields(logrus.Fields{
+				"points": utils.Round(float64(expired)/100, 2),
+			}).Info("Сожжены просроченные баллы")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
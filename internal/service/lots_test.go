@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// хранилище с балансом 100 рублей, из которых 30 сгорят
+type lotsStorer struct {
+	Storer
+	accruedBefore time.Time
+}
+
+func (l *lotsStorer) GetBalance(ctx context.Context, login string) (model.Balance, error) {
+	return model.Balance{Balance: 10000}, nil
+}
+
+func (l *lotsStorer) GetExpiringPoints(ctx context.Context, login string, accruedBefore time.Time) (int64, error) {
+	l.accruedBefore = accruedBefore
+	return 3000, nil
+}
+
+func TestGetBalanceExpiring(t *testing.T) {
+	tests := []struct {
+		name     string
+		months   int
+		expiring float64
+	}{
+		{name: "expiry enabled", months: 12, expiring: 30},
+		{name: "expiry disabled", months: 0, expiring: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &lotsStorer{}
+			s := ServiceStruct{
+				storage: storage,
+				cfg:     config.Config{PointsExpiryMonths: tt.months},
+				Log:     logger.InitLog(),
+			}
+
+			balance, err := s.GetBalance(context.Background(), "user")
+			require.NoError(t, err)
+			assert.Equal(t, 100.0, balance.Balance)
+			assert.Equal(t, tt.expiring, balance.Expiring)
+			if tt.months > 0 {
+				// сгорят партии, начисленные раньше, чем за 12 месяцев до конца дня через 30 дней
+				want := expiryNoticeUntil(time.Now()).AddDate(0, -tt.months, 0)
+				assert.WithinDuration(t, want, storage.accruedBefore, time.Second)
+			}
+		})
+	}
+}
+
+func TestExpiryNoticeUntil(t *testing.T) {
+	tests := []struct {
+		name string
+		now  time.Time
+	}{
+		{name: "start of day", now: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "end of day", now: time.Date(2024, 3, 1, 23, 59, 59, 0, time.UTC)},
+		{name: "other zone", now: time.Date(2024, 3, 2, 2, 0, 0, 0, time.FixedZone("MSK", 3*60*60))},
+	}
+
+	// в течение дня граница не сдвигается
+	want := time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC).Add(expiryNoticePeriod)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, want.Equal(expiryNoticeUntil(tt.now)))
+		})
+	}
+}
+
+// на момент at было начислено 150 рублей и списано 40.5
+func (l *lotsStorer) GetBalanceAt(ctx context.Context, login string, at time.Time) (model.Balance, error) {
+	l.accruedBefore = at
+	return model.Balance{Balance: 10950, Withdrawn: -4050}, nil
+}
+
+func TestGetBalanceAt(t *testing.T) {
+	storage := &lotsStorer{}
+	s := ServiceStruct{
+		storage: storage,
+		cfg:     config.Config{PointsExpiryMonths: 12},
+		Log:     logger.InitLog(),
+	}
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	balance, err := s.GetBalanceAt(context.Background(), "user", at)
+	require.NoError(t, err)
+	assert.Equal(t, at, storage.accruedBefore)
+	// удержаний и сгорающих баллов в прошлом нет, доступен весь баланс
+	assert.Equal(t, model.Balance{Balance: 109.5, Available: 109.5, Withdrawn: 40.5}, balance)
+}
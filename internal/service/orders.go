@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+)
+
+// наибольшее число номеров в одном пакете
+const maxBatchOrders = 1000
+
+// Загрузить пакет номеров заказов. Неверные номера не мешают загрузке
+// остальных, результат возвращается по каждому номеру в порядке запроса
+func (s ServiceStruct) AddUserOrders(ctx context.Context, numbers []string, login string) ([]model.BatchOrderResult, error) {
+	if len(numbers) == 0 || len(numbers) > maxBatchOrders {
+		s.Log.Error(model.ErrWrongRequest.Error())
+		return nil, fmt.Errorf("%w: batch must contain from 1 to %d orders", model.ErrWrongRequest, maxBatchOrders)
+	}
+
+	results := make([]model.BatchOrderResult, len(numbers))
+	var valid []string
+	var validIdx []int
+	for i, number := range numbers {
+		results[i].Number = number
+		if !utils.CheckLuhnAlg(number) {
+			results[i].Result = model.BatchInvalid
+			results[i].Status = http.StatusUnprocessableEntity
+			results[i].Error = model.ErrNotValidOrderNumber.Error()
+			continue
+		}
+		valid = append(valid, number)
+		validIdx = append(validIdx, i)
+	}
+
+	if len(valid) > 0 {
+		added, err := s.storage.AddOrders(ctx, valid, login)
+		if err != nil {
+			return nil, err
+		}
+		for j, err := range added {
+			result := &results[validIdx[j]]
+			switch {
+			case err == nil:
+				result.Result = model.BatchAccepted
+				result.Status = http.StatusAccepted
+			case errors.Is(err, model.ErrOrderExistsSameUser):
+				result.Result = model.BatchDuplicate
+				result.Status = http.StatusOK
+			default:
+				result.Result = model.BatchConflict
+				result.Status = http.StatusConflict
+				result.Error = err.Error()
+			}
+		}
+	}
+	return results, nil
+}
+
+// Заказ пользователя с историей изменения статуса
+func (s ServiceStruct) GetUserOrder(ctx context.Context, number string, login string) (model.OrderDetail, error) {
+	return s.storage.GetOrder(ctx, number, login)
+}
+
+// Дождаться изменения статуса заказа относительно sinceStatus, но не дольше wait.
+// Пустой sinceStatus - текущий статус заказа. Изменения приходят из потока событий,
+// поэтому ожидание не нагружает базу. По истечении wait возвращается заказ без изменений
+func (s ServiceStruct) WaitUserOrder(ctx context.Context, number string, login string,
+	sinceStatus string, wait time.Duration) (model.OrderDetail, error) {
+
+	// подписка до чтения заказа, чтобы не пропустить изменение между ними
+	ch := s.events.subscribe(login)
+	defer s.events.unsubscribe(login, ch)
+
+	order, err := s.storage.GetOrder(ctx, number, login)
+	if err != nil {
+		return model.OrderDetail{}, err
+	}
+	if sinceStatus == "" {
+		sinceStatus = order.Status
+	}
+	if order.Status != sinceStatus {
+		return order, nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case event, ok := <-ch:
+			// подписчик отключен как отстающий, изменение могло быть пропущено
+			if !ok {
+				return s.storage.GetOrder(ctx, number, login)
+			}
+			if event.Type != model.EventOrderStatusChanged {
+				continue
+			}
+			var change model.OrderStatusEvent
+			if err = json.Unmarshal(event.Data, &change); err != nil {
+				s.Log.Error(err.Error())
+				continue
+			}
+			if change.Number != number || change.Status == sinceStatus {
+				continue
+			}
+			return s.storage.GetOrder(ctx, number, login)
+		case <-timer.C:
+			return order, nil
+		case <-ctx.Done():
+			return model.OrderDetail{}, ctx.Err()
+		}
+	}
+}
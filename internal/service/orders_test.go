@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// хранилище с одним заказом, статус меняется тестом
+type orderStorer struct {
+	Storer
+	status chan string
+}
+
+func (o orderStorer) GetOrder(ctx context.Context, number string, login string) (model.OrderDetail, error) {
+	return model.OrderDetail{Number: number, Status: <-o.status}, nil
+}
+
+func TestWaitUserOrder(t *testing.T) {
+	statusEvent := func(number string, status string) model.Event {
+		data, err := json.Marshal(model.OrderStatusEvent{Number: number, Status: status})
+		require.NoError(t, err)
+		return model.Event{Login: "user", Type: model.EventOrderStatusChanged, Data: data}
+	}
+
+	tests := []struct {
+		name        string
+		sinceStatus string
+		statuses    []string
+		events      []model.Event
+		want        string
+	}{
+		{
+			name:        "already changed",
+			sinceStatus: model.OrderNew,
+			statuses:    []string{model.OrderProcessing},
+			want:        model.OrderProcessing,
+		},
+		{
+			name:     "changed while waiting",
+			statuses: []string{model.OrderNew, model.OrderProcessed},
+			events: []model.Event{
+				{Login: "user", Type: model.EventBalanceChanged, Data: []byte(`{}`)},
+				statusEvent("other", model.OrderProcessed),
+				statusEvent("1", model.OrderNew),
+				statusEvent("1", model.OrderProcessed),
+			},
+			want: model.OrderProcessed,
+		},
+		{
+			name:     "timeout",
+			statuses: []string{model.OrderNew},
+			events:   []model.Event{statusEvent("other", model.OrderProcessed)},
+			want:     model.OrderNew,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			storer := orderStorer{status: make(chan string, len(tt.statuses))}
+			for _, status := range tt.statuses {
+				storer.status <- status
+			}
+			s := ServiceStruct{storage: storer, Log: logger.InitLog(), events: newEventHub()}
+
+			go func() {
+				// дождаться подписки перед рассылкой событий
+				for len(storer.status) == len(tt.statuses) {
+					time.Sleep(time.Millisecond)
+				}
+				for _, event := range tt.events {
+					s.events.publish(event)
+				}
+			}()
+
+			order, err := s.WaitUserOrder(context.Background(), "1", "user", tt.sinceStatus, 100*time.Millisecond)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, order.Status)
+		})
+	}
+}
+
+// хранилище заказов в памяти: номер, загруженный ранее, в том числе в этом
+// же пакете, возвращается как дубликат или как чужой заказ
+type batchStorer struct {
+	Storer
+	owners map[string]string
+}
+
+func (b *batchStorer) AddOrders(ctx context.Context, numbers []string, login string) ([]error, error) {
+	results := make([]error, len(numbers))
+	for i, number := range numbers {
+		owner, ok := b.owners[number]
+		switch {
+		case !ok:
+			b.owners[number] = login
+		case owner == login:
+			results[i] = model.ErrOrderExistsSameUser
+		default:
+			results[i] = model.ErrOrderExistsDiffUser
+		}
+	}
+	return results, nil
+}
+
+func TestAddUserOrders(t *testing.T) {
+	storage := &batchStorer{owners: map[string]string{
+		"79927398713":      "user",
+		"4561261212345467": "other",
+	}}
+	s := ServiceStruct{storage: storage, Log: logger.InitLog()}
+
+	numbers := []string{"12345678903", "12345678903", "79927398713", "4561261212345467", "12345678900"}
+	results, err := s.AddUserOrders(context.Background(), numbers, "user")
+	require.NoError(t, err)
+
+	want := []model.BatchOrderResult{
+		{Number: "12345678903", Result: model.BatchAccepted, Status: http.StatusAccepted},
+		{Number: "12345678903", Result: model.BatchDuplicate, Status: http.StatusOK},
+		{Number: "79927398713", Result: model.BatchDuplicate, Status: http.StatusOK},
+		{Number: "4561261212345467", Result: model.BatchConflict, Status: http.StatusConflict,
+			Error: model.ErrOrderExistsDiffUser.Error()},
+		{Number: "12345678900", Result: model.BatchInvalid, Status: http.StatusUnprocessableEntity,
+			Error: model.ErrNotValidOrderNumber.Error()},
+	}
+	assert.Equal(t, want, results)
+}
+
+func TestAddUserOrdersBatchSize(t *testing.T) {
+	s := ServiceStruct{storage: &batchStorer{owners: map[string]string{}}, Log: logger.InitLog()}
+
+	_, err := s.AddUserOrders(context.Background(), nil, "user")
+	assert.ErrorIs(t, err, model.ErrWrongRequest)
+
+	_, err = s.AddUserOrders(context.Background(), make([]string, maxBatchOrders+1), "user")
+	assert.ErrorIs(t, err, model.ErrWrongRequest)
+}
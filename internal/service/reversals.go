@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"math"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// Отменить списание пользователя по номеру заказа. Без суммы возвращается
+// весь остаток списания. Отмены администратором больше порога корректировок
+// ждут утверждения вторым администратором, как и корректировки
+func (s ServiceStruct) ReverseWithdrawal(ctx context.Context, login string, number string,
+	req model.ReversalRequest, by model.ReversalInitiator) (model.Reversal, error) {
+
+	if req.Sum < 0 || math.IsNaN(req.Sum) || math.IsInf(req.Sum, 0) {
+		s.Log.Error(model.ErrWrongRequest.Error())
+		return model.Reversal{}, model.ErrWrongRequest
+	}
+	// переводим в копейки
+	amount := int64(math.Round(req.Sum * 100))
+	if req.Sum > 0 && amount == 0 {
+		return model.Reversal{}, model.ErrWrongRequest
+	}
+
+	if by.Partner == "" {
+		by.ApprovalThreshold = int64(math.Round(s.cfg.AdjustmentThreshold * 100))
+	}
+
+	s.Log.WithFields(logrus.Fields{
+		"user":    login,
+		"number":  number,
+		"sum":     req.Sum,
+		"admin":   by.Admin,
+		"partner": by.Partner,
+	}).Info("Отмена списания")
+	return s.storage.ReverseWithdrawal(ctx, login, number, amount, req.Reason, by)
+}
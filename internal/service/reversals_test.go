@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// хранилище запоминает сумму и инициатора отмены
+type reversalStorer struct {
+	Storer
+	amount int64
+	by     model.ReversalInitiator
+}
+
+func (r *reversalStorer) ReverseWithdrawal(ctx context.Context, login string, number string, amount int64,
+	reason string, by model.ReversalInitiator) (model.Reversal, error) {
+	r.amount = amount
+	r.by = by
+	return model.Reversal{Number: number, Status: model.ReversalApplied}, nil
+}
+
+func TestReverseWithdrawal(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        model.ReversalRequest
+		by         model.ReversalInitiator
+		wantErr    error
+		wantAmount int64
+		wantBy     model.ReversalInitiator
+	}{
+		{
+			name:   "full reversal by partner",
+			by:     model.ReversalInitiator{Partner: "shop"},
+			wantBy: model.ReversalInitiator{Partner: "shop"},
+		},
+		{
+			name:       "partial reversal by partner ignores the approval threshold",
+			req:        model.ReversalRequest{Sum: 2500.5},
+			by:         model.ReversalInitiator{Partner: "shop"},
+			wantAmount: 250050,
+			wantBy:     model.ReversalInitiator{Partner: "shop"},
+		},
+		{
+			name:       "admin reversal gets the adjustment threshold",
+			req:        model.ReversalRequest{Sum: 10},
+			by:         model.ReversalInitiator{Admin: "admin"},
+			wantAmount: 1000,
+			wantBy:     model.ReversalInitiator{Admin: "admin", ApprovalThreshold: 100000},
+		},
+		{name: "negative sum", req: model.ReversalRequest{Sum: -1}, wantErr: model.ErrWrongRequest},
+		{name: "less than a kopeck", req: model.ReversalRequest{Sum: 0.001}, wantErr: model.ErrWrongRequest},
+		{name: "not a number", req: model.ReversalRequest{Sum: math.NaN()}, wantErr: model.ErrWrongRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &reversalStorer{}
+			s := ServiceStruct{
+				storage: storage,
+				cfg:     config.Config{AdjustmentThreshold: 1000},
+				Log:     logger.InitLog(),
+			}
+
+			_, err := s.ReverseWithdrawal(context.Background(), "user", "12345678903", tt.req, tt.by)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantAmount, storage.amount)
+			assert.Equal(t, tt.wantBy, storage.by)
+		})
+	}
+}
@@ -3,51 +3,133 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
-
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/hasher"
 	"github.com/kartalenka7/project_gophermart/internal/model"
 	"github.com/kartalenka7/project_gophermart/internal/utils"
 	"github.com/sirupsen/logrus"
 )
 
 // интерфейс взаимодействия с хранилищем
+//
 //go:generate mockery --name Storer --with-expecter
 type Storer interface {
 	AddUser(ctx context.Context, user model.User) error
-	AuthUser(ctx context.Context, user model.User) (string, error)
+	AuthUser(ctx context.Context, user model.User) (model.User, error)
+	UpdatePassword(ctx context.Context, login string, password string) error
 	AddOrder(ctx context.Context, number string, login string) error
+	AddOrders(ctx context.Context, numbers []string, login string) ([]error, error)
 	GetOrders(ctx context.Context, login string) ([]model.OrdersResponse, error)
-	WriteWithdraw(ctx context.Context, withdraw model.OrderWithdraw, login string) error
+	WriteWithdraw(ctx context.Context, withdraw model.OrderWithdraw, login string, check model.WithdrawalCheck) error
 	GetBalance(ctx context.Context, login string) (model.Balance, error)
+	GetBalanceAt(ctx context.Context, login string, at time.Time) (model.Balance, error)
 	GetWithdrawals(ctx context.Context, login string) ([]model.OrderWithdraw, error)
+	GetDataVersion(ctx context.Context, login string) (int64, error)
+	GetTransactions(ctx context.Context, login string, filter model.ListFilter) ([]model.Transaction, error)
+	GetStatement(ctx context.Context, login string, filter model.ListFilter, w model.StatementWriter) error
+	ReverseWithdrawal(ctx context.Context, login string, number string, amount int64,
+		reason string, by model.ReversalInitiator) (model.Reversal, error)
 	GetOrdersForUpdate(ctx context.Context) ([]string, error)
-	UpdateOrders(ctx context.Context, accrualSysResponse []model.PointsAppResponse)
+	UpdateOrders(ctx context.Context, accrualSysResponse []model.PointsAppResponse, source string) error
+	GetOrder(ctx context.Context, number string, login string) (model.OrderDetail, error)
+	GetEvents(ctx context.Context, login string, afterID int64, limit int) ([]model.Event, error)
+	DeleteEvents(ctx context.Context, before time.Time) (int64, error)
+	ListenEvents(ctx context.Context, handler func(model.Event)) error
+	AddAdjustment(ctx context.Context, adj model.Adjustment, amount int64, apply bool) (model.Adjustment, error)
+	DecideAdjustment(ctx context.Context, id int64, admin string, approve bool) (model.Adjustment, error)
+	GetAdjustments(ctx context.Context, status string) ([]model.Adjustment, error)
+	GetClawbacks(ctx context.Context, openOnly bool) ([]model.Clawback, error)
+	AddAPIKey(ctx context.Context, key model.APIKey, hash string) (model.APIKey, error)
+	GetAPIKey(ctx context.Context, prefix string) (model.APIKey, string, error)
+	GetAPIKeys(ctx context.Context) ([]model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int64) error
+	SetTOTP(ctx context.Context, login string, secret string, recoveryHashes []string) error
+	GetTOTP(ctx context.Context, login string) (string, bool, error)
+	EnableTOTP(ctx context.Context, login string, enabled bool) error
+	UseTOTPStep(ctx context.Context, login string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, login string, hash string) (bool, error)
+	AddLoginChallenge(ctx context.Context, login string, id string, expiresAt time.Time) error
+	CompleteLoginChallenge(ctx context.Context, login string, id string, limits model.OTPAttemptLimits,
+		verify func() error) error
+	VerifySecondFactor(ctx context.Context, login string, limits model.OTPAttemptLimits, verify func() error) error
+	AddSession(ctx context.Context, login string, session model.Session) error
+	TouchSession(ctx context.Context, login string, id string) error
+	GetSessions(ctx context.Context, login string) ([]model.Session, error)
+	TerminateSession(ctx context.Context, login string, id string) error
+	GetUser(ctx context.Context, login string) (model.User, error)
+	SetUserBlocked(ctx context.Context, login string, blocked bool) error
+	SetUserRole(ctx context.Context, login string, role string) error
+	RequeueOrder(ctx context.Context, number string, reverify bool) error
+	CreateHold(ctx context.Context, login string, number string, partner string, amount int64, expiresAt time.Time,
+		check model.WithdrawalCheck) (model.Hold, error)
+	CaptureHold(ctx context.Context, login string, id int64) (model.Hold, error)
+	VoidHold(ctx context.Context, login string, id int64) (model.Hold, error)
+	ExpireHolds(ctx context.Context) (int64, error)
+	ExpirePoints(ctx context.Context, accruedBefore time.Time) (int64, error)
+	GetExpiringPoints(ctx context.Context, login string, accruedBefore time.Time) (int64, error)
+	Transfer(ctx context.Context, sender string, recipient string, amount int64,
+		limits model.TransferLimits) (model.Transfer, error)
+	ReverseTransfer(ctx context.Context, id int64, admin string) (model.Transfer, error)
+	GetWithdrawalLimits(ctx context.Context, login string) (model.WithdrawalLimits, error)
+	SetWithdrawalLimits(ctx context.Context, login string, limits model.WithdrawalLimits, admin string) error
+	DeleteWithdrawalLimits(ctx context.Context, login string) error
 }
 
 type ServiceStruct struct {
 	storage Storer
+	hasher  *hasher.Hasher
+	cfg     config.Config
 	Log     *logrus.Logger
+	// подписчики на события пользователей
+	events *eventHub
 }
 
-func NewService(ctx context.Context, storage Storer, log *logrus.Logger, accrualSys string) *ServiceStruct {
+func NewService(ctx context.Context, storage Storer, log *logrus.Logger, cfg config.Config) (*ServiceStruct, error) {
 	var service *ServiceStruct
 	log.Info("Инициализируем сервис")
+
+	passwordHasher, err := newPasswordHasher(cfg)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+
 	service = &ServiceStruct{
 		storage: storage,
+		hasher:  passwordHasher,
+		cfg:     cfg,
 		Log:     log,
+		events:  newEventHub(),
 	}
 	log.Info("Запускаем горутину для взаимодейтсвия с системой расчета баллов лояльности")
-	go service.GetUpdatesFromAccrualSystem(ctx, accrualSys)
-	return service
+	go service.GetUpdatesFromAccrualSystem(ctx, cfg.AccrualSys)
+	go service.ListenEvents(ctx)
+	go service.cleanupEvents(ctx)
+	go service.expireHolds(ctx)
+	go service.expirePoints(ctx)
+	return service, nil
+}
+
+func newPasswordHasher(cfg config.Config) (*hasher.Hasher, error) {
+	if cfg.Argon2Threads > math.MaxUint8 {
+		return nil, errors.New("argon2 threads out of range")
+	}
+	return hasher.NewHasher(hasher.Params{
+		Scheme:        cfg.PasswordHash,
+		BcryptCost:    cfg.BcryptCost,
+		Argon2Time:    uint32(cfg.Argon2Time),
+		Argon2Memory:  uint32(cfg.Argon2Memory),
+		Argon2Threads: uint8(cfg.Argon2Threads),
+	})
 }
 
 // взаимодействие с системой расчета начислений баллов лояльности
 func (s ServiceStruct) GetUpdatesFromAccrualSystem(ctx context.Context, accrualSys string) {
-	var allResp []model.PointsAppResponse
-
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
@@ -59,9 +141,11 @@ func (s ServiceStruct) GetUpdatesFromAccrualSystem(ctx context.Context, accrualS
 				continue
 			}
 
-			pointsResp := model.PointsAppResponse{}
+			// ответы собираются заново на каждом шаге опроса
+			var allResp []model.PointsAppResponse
 			client := &http.Client{}
 			for _, v := range orderNumbers {
+				pointsResp := model.PointsAppResponse{}
 				url := accrualSys + "/api/orders/" + v
 
 				s.Log.Info("http запрос в систему начислений баллов лояльности")
@@ -77,19 +161,27 @@ func (s ServiceStruct) GetUpdatesFromAccrualSystem(ctx context.Context, accrualS
 					continue
 				}
 				s.Log.WithFields(logrus.Fields{"status-code": resp.StatusCode}).Info("Статус ответа")
+				// 204 - заказ не зарегистрирован в системе начислений, 429 - превышен лимит запросов
+				if resp.StatusCode != http.StatusOK {
+					resp.Body.Close()
+					continue
+				}
 				decoder := json.NewDecoder(resp.Body)
-				if err = decoder.Decode(&pointsResp); err != nil {
+				err = decoder.Decode(&pointsResp)
+				resp.Body.Close()
+				if err != nil {
 					s.Log.Error(err.Error())
 					continue
 				}
 				allResp = append(allResp, pointsResp)
-				resp.Body.Close()
 			}
 
 			if allResp == nil {
 				continue
 			}
-			s.storage.UpdateOrders(ctx, allResp)
+			if err = s.storage.UpdateOrders(ctx, allResp, model.OrderSourcePoll); err != nil {
+				s.Log.Error(err.Error())
+			}
 		case <-ctx.Done():
 			s.Log.Error("Отмена контекста")
 			return
@@ -100,15 +192,14 @@ func (s ServiceStruct) GetUpdatesFromAccrualSystem(ctx context.Context, accrualS
 func (s ServiceStruct) ParseUserCredentials(r *http.Request) (model.User, error) {
 	var user model.User
 	// проверить у запроса content-type = application/json
-	if r.Header.Get("Content-Type") != "application/json" {
+	if !utils.HasContentType(r, "application/json") {
 		contType := r.Header.Get("Content-Type")
 		s.Log.WithFields(logrus.Fields{"Content-Type": contType}).Error("Неверный Content-Type")
-		return model.User{}, model.ErrWrongRequest
+		return model.User{}, model.ErrWrongContentType
 	}
 
 	// парсим из json логин и пароль
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&user); err != nil {
+	if err := utils.DecodeJSON(r.Body, &user); err != nil {
 		s.Log.Error(err.Error())
 		return model.User{}, err
 	}
@@ -125,12 +216,12 @@ func (s ServiceStruct) RgstrUser(ctx context.Context, user model.User) error {
 	s.Log.WithFields(logrus.Fields{"user": user.Login}).Info("Регистрация пользователя")
 
 	// пароль преобразовать в хэш
-	bytes, err := bcrypt.GenerateFromPassword([]byte(user.Password), 14)
+	hash, err := s.hasher.Hash(user.Password)
 	if err != nil {
 		s.Log.Error(err.Error())
 		return err
 	}
-	user.Password = string(bytes)
+	user.Password = hash
 
 	if err = s.storage.AddUser(ctx, user); err != nil {
 		return err
@@ -139,21 +230,49 @@ func (s ServiceStruct) RgstrUser(ctx context.Context, user model.User) error {
 	return nil
 }
 
-func (s ServiceStruct) AuthUser(ctx context.Context, user model.User) error {
+func (s ServiceStruct) AuthUser(ctx context.Context, user model.User) (model.User, error) {
 
-	checkPassword, err := s.storage.AuthUser(ctx, user)
+	checkUser, err := s.storage.AuthUser(ctx, user)
 	if err != nil {
-		return model.ErrAuthFailed
+		return model.User{}, model.ErrAuthFailed
 	}
 
 	// проверить хэш пароля
-	err = bcrypt.CompareHashAndPassword([]byte(checkPassword), []byte(user.Password))
+	ok, err := s.hasher.Verify(user.Password, checkUser.Password)
 	if err != nil {
 		s.Log.Error(err.Error())
-		return model.ErrAuthFailed
+		return model.User{}, model.ErrAuthFailed
+	}
+	if !ok {
+		s.Log.Error(model.ErrAuthFailed.Error())
+		return model.User{}, model.ErrAuthFailed
 	}
 
-	return nil
+	if checkUser.Blocked {
+		s.Log.WithFields(logrus.Fields{"user": user.Login}).Error(model.ErrUserBlocked.Error())
+		return model.User{}, model.ErrUserBlocked
+	}
+
+	// пароль верный - если хэш устарел, перехэшируем с текущими параметрами
+	if s.hasher.NeedsRehash(checkUser.Password) {
+		s.rehashPassword(ctx, user)
+	}
+
+	checkUser.Password = ""
+	return checkUser, nil
+}
+
+// ошибки перехэширования не мешают входу пользователя
+func (s ServiceStruct) rehashPassword(ctx context.Context, user model.User) {
+	hash, err := s.hasher.Hash(user.Password)
+	if err != nil {
+		s.Log.Error(err.Error())
+		return
+	}
+	if err = s.storage.UpdatePassword(ctx, user.Login, hash); err != nil {
+		return
+	}
+	s.Log.WithFields(logrus.Fields{"user": user.Login}).Info("Хэш пароля обновлен")
 }
 
 func (s ServiceStruct) AddUserOrder(ctx context.Context, number string, login string) error {
@@ -173,30 +292,28 @@ func (s ServiceStruct) GetUserOrders(ctx context.Context, login string) ([]model
 }
 
 func (s ServiceStruct) WriteWithdraw(ctx context.Context, withdraw model.OrderWithdraw, login string) error {
-	var balanceFloat float64
-
 	//проверить формат номера заказа
 	if !utils.CheckLuhnAlg(withdraw.Number) {
 		s.Log.Error(model.ErrNotValidOrderNumber.Error())
 		return model.ErrNotValidOrderNumber
 	}
 
-	balance, err := s.storage.GetBalance(ctx, login)
-	if err != nil {
-		return err
+	// сумма проверяется в копейках, иначе 0.001 прошла бы проверку и списала ноль
+	withdraw.Withdraw = -math.Round(withdraw.Withdraw * 100)
+	if withdraw.Withdraw >= 0 {
+		s.Log.Error(model.ErrWrongRequest.Error())
+		return model.ErrWrongRequest
 	}
 
-	// переводим обратно в рубли
-	balanceFloat = balance.Balance / 100
+	// достаточность баллов и лимиты проверяются в хранилище в одной транзакции со списанием
+	check := s.withdrawalCheck(int64(-withdraw.Withdraw), withdraw.OrderValue)
 
-	// проверяем, что у пользователя достаточно баллов для списания
-	if balanceFloat < float64(withdraw.Withdraw) {
-		s.Log.Error(model.ErrInsufficientBalance.Error())
-		return model.ErrInsufficientBalance
-	}
-	withdraw.Withdraw = -withdraw.Withdraw * 100
+	return s.storage.WriteWithdraw(ctx, withdraw, login, check)
+}
 
-	return s.storage.WriteWithdraw(ctx, withdraw, login)
+// Версия данных пользователя, меняется при каждом изменении заказов, баланса или списаний
+func (s ServiceStruct) GetDataVersion(ctx context.Context, login string) (int64, error) {
+	return s.storage.GetDataVersion(ctx, login)
 }
 
 func (s ServiceStruct) GetBalance(ctx context.Context, login string) (model.Balance, error) {
@@ -205,17 +322,58 @@ func (s ServiceStruct) GetBalance(ctx context.Context, login string) (model.Bala
 		return model.Balance{}, err
 	}
 
-	balance.Balance = balance.Balance / 100
-	balance.Withdrawn = balance.Withdrawn / 100
-
-	balance.Balance = utils.Round(balance.Balance, 2)
-	balance.Withdrawn = utils.Round(-balance.Withdrawn, 2)
-
+	balance = roundBalance(balance)
+	if s.cfg.PointsExpiryMonths > 0 {
+		expiring, err := s.storage.GetExpiringPoints(ctx, login,
+			s.expiryCutoff(expiryNoticeUntil(time.Now())))
+		if err != nil {
+			return model.Balance{}, err
+		}
+		balance.Expiring = utils.Round(float64(expiring)/100, 2)
+	}
 	s.Log.WithFields(logrus.Fields{"balance": balance}).Info("Баланс с округлением")
 
 	return balance, err
 }
 
+// Баланс и сумма списаний на момент at по записям истории до него включительно
+func (s ServiceStruct) GetBalanceAt(ctx context.Context, login string, at time.Time) (model.Balance, error) {
+	balance, err := s.storage.GetBalanceAt(ctx, login, at)
+	if err != nil {
+		return model.Balance{}, err
+	}
+
+	balance = roundBalance(balance)
+	s.Log.WithFields(logrus.Fields{
+		"balance": balance,
+		"at":      at,
+	}).Info("Баланс на момент времени")
+
+	return balance, nil
+}
+
+// перевести баланс из копеек в рубли, списания - положительным числом,
+// доступный баланс - за вычетом удержаний
+func roundBalance(balance model.Balance) model.Balance {
+	balance.Available = utils.Round((balance.Balance-balance.Held)/100, 2)
+	balance.Held = utils.Round(balance.Held/100, 2)
+	balance.Balance = utils.Round(balance.Balance/100, 2)
+	balance.Withdrawn = utils.Round(-balance.Withdrawn/100, 2)
+	return balance
+}
+
 func (s ServiceStruct) GetWithdrawals(ctx context.Context, login string) ([]model.OrderWithdraw, error) {
 	return s.storage.GetWithdrawals(ctx, login)
 }
+
+// Операции по счету с балансом после каждой из них
+func (s ServiceStruct) GetTransactions(ctx context.Context, login string,
+	filter model.ListFilter) ([]model.Transaction, error) {
+	return s.storage.GetTransactions(ctx, login, filter)
+}
+
+// Выписка за период с балансом на начало и конец, операции передаются в w по одной
+func (s ServiceStruct) GetStatement(ctx context.Context, login string, filter model.ListFilter,
+	w model.StatementWriter) error {
+	return s.storage.GetStatement(ctx, login, filter, w)
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+const sessionIDLen = 16
+
+// Создать сессию при входе пользователя, вернуть ее идентификатор для токена
+func (s ServiceStruct) CreateSession(ctx context.Context, login string, userAgent string, ip string) (string, error) {
+	id := make([]byte, sessionIDLen)
+	if _, err := rand.Read(id); err != nil {
+		s.Log.Error(err.Error())
+		return "", err
+	}
+
+	session := model.Session{
+		ID:        hex.EncodeToString(id),
+		UserAgent: userAgent,
+		IP:        ip,
+	}
+	if err := s.storage.AddSession(ctx, login, session); err != nil {
+		return "", err
+	}
+	return session.ID, nil
+}
+
+// Проверить, что сессия токена не завершена. Токены без сессии, выданные
+// до появления сессий, принимаются до LEGACY_TOKENS_UNTIL
+func (s ServiceStruct) CheckSession(ctx context.Context, login string, id string) error {
+	if id == "" {
+		if time.Now().Before(s.cfg.LegacyTokensUntil) {
+			return nil
+		}
+		return model.ErrSessionNotFound
+	}
+	return s.storage.TouchSession(ctx, login, id)
+}
+
+// Активные сессии пользователя, текущая отмечена
+func (s ServiceStruct) GetSessions(ctx context.Context, login string, current string) ([]model.Session, error) {
+	sessions, err := s.storage.GetSessions(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+	return sessions, nil
+}
+
+func (s ServiceStruct) TerminateSession(ctx context.Context, login string, id string) error {
+	return s.storage.TerminateSession(ctx, login, id)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// хранилище с набором сессий, завершенные из списка удаляются
+type sessionStorer struct {
+	Storer
+	sessions map[string]model.Session
+	touched  int
+}
+
+func (s *sessionStorer) TouchSession(ctx context.Context, login string, id string) error {
+	if _, ok := s.sessions[id]; !ok {
+		return model.ErrSessionNotFound
+	}
+	s.touched++
+	return nil
+}
+
+func (s *sessionStorer) GetSessions(ctx context.Context, login string) ([]model.Session, error) {
+	var sessions []model.Session
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+func (s *sessionStorer) TerminateSession(ctx context.Context, login string, id string) error {
+	if _, ok := s.sessions[id]; !ok {
+		return model.ErrSessionNotFound
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func newSessionService(storage *sessionStorer, legacyUntil time.Time) ServiceStruct {
+	return ServiceStruct{
+		storage: storage,
+		cfg:     config.Config{LegacyTokensUntil: legacyUntil},
+		Log:     logger.InitLog(),
+	}
+}
+
+func TestCheckSession(t *testing.T) {
+	tests := []struct {
+		name        string
+		id          string
+		legacyUntil time.Time
+		wantErr     error
+		wantTouched int
+	}{
+		{name: "active session", id: "a", wantTouched: 1},
+		{name: "unknown session", id: "b", wantErr: model.ErrSessionNotFound},
+		{name: "legacy token without cutoff", wantErr: model.ErrSessionNotFound},
+		{name: "legacy token before cutoff", legacyUntil: time.Now().Add(time.Hour)},
+		{name: "legacy token after cutoff", legacyUntil: time.Now().Add(-time.Hour),
+			wantErr: model.ErrSessionNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &sessionStorer{sessions: map[string]model.Session{"a": {ID: "a"}}}
+			s := newSessionService(storage, tt.legacyUntil)
+
+			err := s.CheckSession(context.Background(), "user", tt.id)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantTouched, storage.touched)
+		})
+	}
+}
+
+func TestTerminateSession(t *testing.T) {
+	ctx := context.Background()
+	storage := &sessionStorer{sessions: map[string]model.Session{
+		"a": {ID: "a"},
+		"b": {ID: "b"},
+	}}
+	s := newSessionService(storage, time.Time{})
+
+	sessions, err := s.GetSessions(ctx, "user", "a")
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	for _, session := range sessions {
+		assert.Equal(t, session.ID == "a", session.Current)
+	}
+
+	require.NoError(t, s.TerminateSession(ctx, "user", "b"))
+	assert.ErrorIs(t, s.TerminateSession(ctx, "user", "b"), model.ErrSessionNotFound)
+
+	// токен завершенной сессии больше не принимается
+	assert.ErrorIs(t, s.CheckSession(ctx, "user", "b"), model.ErrSessionNotFound)
+	assert.NoError(t, s.CheckSession(ctx, "user", "a"))
+
+	sessions, err = s.GetSessions(ctx, "user", "a")
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "a", sessions[0].ID)
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/totp"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	totpIssuer         = "Gophermart"
+	recoveryCodesCount = 10
+	recoveryCodeLen    = 10
+	challengeIDLen     = 16
+)
+
+// Начать подключение 2FA: сгенерировать секрет и коды восстановления.
+// 2FA включается только после подтверждения кодом из приложения
+func (s ServiceStruct) EnrollTOTP(ctx context.Context, login string) (model.TOTPEnrollment, error) {
+	_, enabled, err := s.storage.GetTOTP(ctx, login)
+	if err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+	if enabled {
+		return model.TOTPEnrollment{}, model.ErrTOTPEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		s.Log.Error(err.Error())
+		return model.TOTPEnrollment{}, err
+	}
+
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			s.Log.Error(err.Error())
+			return model.TOTPEnrollment{}, err
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	if err = s.storage.SetTOTP(ctx, login, secret, hashes); err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+
+	s.Log.WithFields(logrus.Fields{"user": login}).Info("Начато подключение 2FA")
+	return model.TOTPEnrollment{
+		Secret:        secret,
+		URI:           totp.URI(totpIssuer, login, secret),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// Подтвердить подключение 2FA кодом из приложения
+func (s ServiceStruct) ConfirmTOTP(ctx context.Context, login string, code string) error {
+	secret, enabled, err := s.storage.GetTOTP(ctx, login)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		return model.ErrTOTPEnabled
+	}
+	if secret == "" {
+		return model.ErrTOTPNotEnrolled
+	}
+	err = s.storage.VerifySecondFactor(ctx, login, s.otpLimits(), func() error {
+		return s.checkTOTPCode(ctx, login, secret, code)
+	})
+	if err != nil {
+		return err
+	}
+	return s.storage.EnableTOTP(ctx, login, true)
+}
+
+// Отключить 2FA, нужен действующий код или код восстановления
+func (s ServiceStruct) DisableTOTP(ctx context.Context, login string, code string) error {
+	if err := s.verifySecondFactor(ctx, login, code); err != nil {
+		return err
+	}
+	return s.storage.EnableTOTP(ctx, login, false)
+}
+
+// Токен первого шага входа для пользователя с 2FA
+func (s ServiceStruct) NewLoginChallenge(ctx context.Context, login string) (model.LoginChallenge, error) {
+	raw := make([]byte, challengeIDLen)
+	if _, err := rand.Read(raw); err != nil {
+		s.Log.Error(err.Error())
+		return model.LoginChallenge{}, err
+	}
+	id := hex.EncodeToString(raw)
+
+	token, expiresAt, err := utils.NewChallengeToken(login, id, s.cfg.TOTPChallengeTTL)
+	if err != nil {
+		s.Log.Error(err.Error())
+		return model.LoginChallenge{}, err
+	}
+	if err = s.storage.AddLoginChallenge(ctx, login, id, expiresAt); err != nil {
+		return model.LoginChallenge{}, err
+	}
+	return model.LoginChallenge{ChallengeToken: token, ExpiresAt: expiresAt}, nil
+}
+
+// Второй шаг входа: обменять токен первого шага и код на пользователя
+func (s ServiceStruct) CompleteLogin(ctx context.Context, req model.OTPRequest) (model.User, error) {
+	tk, err := utils.ParseToken(req.ChallengeToken)
+	if err != nil || tk.Purpose != model.TokenPurpose2FA || tk.Id == "" {
+		s.Log.Error("Неверный токен первого шага входа")
+		return model.User{}, model.ErrAuthFailed
+	}
+
+	// токен одноразовый, неверные коды считаются в базе, чтобы код нельзя было подобрать
+	err = s.storage.CompleteLoginChallenge(ctx, tk.Login, tk.Id, s.otpLimits(), func() error {
+		return s.checkSecondFactor(ctx, tk.Login, req.Code)
+	})
+	if err != nil {
+		return model.User{}, err
+	}
+
+	user, err := s.storage.GetUser(ctx, tk.Login)
+	if err != nil {
+		return model.User{}, err
+	}
+	if user.Blocked {
+		return model.User{}, model.ErrUserBlocked
+	}
+	return user, nil
+}
+
+// Списания больше порога у пользователей с 2FA подтверждаются одноразовым кодом
+func (s ServiceStruct) CheckStepUp(ctx context.Context, login string, amount float64, code string) error {
+	if amount <= s.cfg.StepUpThreshold {
+		return nil
+	}
+	_, enabled, err := s.storage.GetTOTP(ctx, login)
+	if err != nil || !enabled {
+		return err
+	}
+	if code == "" {
+		s.Log.WithFields(logrus.Fields{"user": login}).Error(model.ErrStepUpRequired.Error())
+		return model.ErrStepUpRequired
+	}
+	return s.verifySecondFactor(ctx, login, code)
+}
+
+// Проверить код из приложения или код восстановления. Неверные коды считаются
+// в базе вместе с кодами при входе, поэтому код нельзя подобрать и с действующим токеном
+func (s ServiceStruct) verifySecondFactor(ctx context.Context, login string, code string) error {
+	return s.storage.VerifySecondFactor(ctx, login, s.otpLimits(), func() error {
+		return s.checkSecondFactor(ctx, login, code)
+	})
+}
+
+func (s ServiceStruct) otpLimits() model.OTPAttemptLimits {
+	return model.OTPAttemptLimits{MaxFailures: s.cfg.TOTPMaxAttempts, Lockout: s.cfg.TOTPLockout}
+}
+
+// Проверить код без учета неверных попыток, вызывается из хранилища под блокировкой счетчика
+func (s ServiceStruct) checkSecondFactor(ctx context.Context, login string, code string) error {
+	secret, enabled, err := s.storage.GetTOTP(ctx, login)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return model.ErrTOTPNotEnrolled
+	}
+
+	if len(code) == totp.Digits {
+		return s.checkTOTPCode(ctx, login, secret, code)
+	}
+
+	ok, err := s.storage.UseRecoveryCode(ctx, login, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.Log.WithFields(logrus.Fields{"user": login}).Error(model.ErrInvalidOTP.Error())
+		return model.ErrInvalidOTP
+	}
+	s.Log.WithFields(logrus.Fields{"user": login}).Info("Использован код восстановления")
+	return nil
+}
+
+func (s ServiceStruct) checkTOTPCode(ctx context.Context, login string, secret string, code string) error {
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		s.Log.WithFields(logrus.Fields{"user": login}).Error(model.ErrInvalidOTP.Error())
+		return model.ErrInvalidOTP
+	}
+	// один и тот же код нельзя использовать дважды
+	ok, err := s.storage.UseTOTPStep(ctx, login, step)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.Log.WithFields(logrus.Fields{"user": login}).Error("Повторное использование кода")
+		return model.ErrInvalidOTP
+	}
+	return nil
+}
+
+// Код восстановления вида xxxxx-xxxxx
+func newRecoveryCode() (string, error) {
+	raw := make([]byte, recoveryCodeLen)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))[:recoveryCodeLen]
+	return code[:recoveryCodeLen/2] + "-" + code[recoveryCodeLen/2:], nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// хранилище запоминает токен первого шага и попытку входа по нему
+type challengeStorer struct {
+	Storer
+	id          string
+	completedID string
+	limits      model.OTPAttemptLimits
+	err         error
+}
+
+func (c *challengeStorer) AddLoginChallenge(ctx context.Context, login string, id string, expiresAt time.Time) error {
+	c.id = id
+	return nil
+}
+
+func (c *challengeStorer) CompleteLoginChallenge(ctx context.Context, login string, id string,
+	limits model.OTPAttemptLimits, verify func() error) error {
+	c.completedID = id
+	c.limits = limits
+	return c.err
+}
+
+func (c *challengeStorer) GetUser(ctx context.Context, login string) (model.User, error) {
+	return model.User{Login: login}, nil
+}
+
+func TestCompleteLogin(t *testing.T) {
+	legacy, _, err := utils.NewChallengeToken("user", "", time.Minute)
+	require.NoError(t, err)
+	full, err := utils.NewToken(model.User{Login: "user"}, "session")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		token      string
+		storageErr error
+		wantErr    error
+	}{
+		{name: "challenge token"},
+		{name: "locked", storageErr: model.ErrTOTPLocked, wantErr: model.ErrTOTPLocked},
+		{name: "invalid code", storageErr: model.ErrInvalidOTP, wantErr: model.ErrInvalidOTP},
+		{name: "token without challenge id", token: legacy, wantErr: model.ErrAuthFailed},
+		{name: "access token", token: full, wantErr: model.ErrAuthFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &challengeStorer{err: tt.storageErr}
+			s := ServiceStruct{
+				storage: storage,
+				cfg: config.Config{
+					TOTPChallengeTTL: 5 * time.Minute,
+					TOTPMaxAttempts:  5,
+					TOTPLockout:      15 * time.Minute,
+				},
+				Log: logger.InitLog(),
+			}
+			challenge, err := s.NewLoginChallenge(context.Background(), "user")
+			require.NoError(t, err)
+			token := tt.token
+			if token == "" {
+				token = challenge.ChallengeToken
+			}
+
+			user, err := s.CompleteLogin(context.Background(), model.OTPRequest{ChallengeToken: token, Code: "123456"})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "user", user.Login)
+			// попытка считается по тому же токену, что был выдан
+			assert.Equal(t, storage.id, storage.completedID)
+			assert.Equal(t, model.OTPAttemptLimits{MaxFailures: 5, Lockout: 15 * time.Minute}, storage.limits)
+		})
+	}
+}
+
+// хранилище с включенной 2FA, проверка кода идет через счетчик неверных попыток
+type stepUpStorer struct {
+	Storer
+	locked   bool
+	verified int
+	limits   model.OTPAttemptLimits
+	disabled bool
+}
+
+func (s *stepUpStorer) GetTOTP(ctx context.Context, login string) (string, bool, error) {
+	return "JBSWY3DPEHPK3PXP", true, nil
+}
+
+func (s *stepUpStorer) VerifySecondFactor(ctx context.Context, login string, limits model.OTPAttemptLimits,
+	verify func() error) error {
+	s.verified++
+	s.limits = limits
+	if s.locked {
+		return model.ErrTOTPLocked
+	}
+	return verify()
+}
+
+func (s *stepUpStorer) UseRecoveryCode(ctx context.Context, login string, hash string) (bool, error) {
+	return false, nil
+}
+
+func (s *stepUpStorer) EnableTOTP(ctx context.Context, login string, enabled bool) error {
+	s.disabled = !enabled
+	return nil
+}
+
+func TestSecondFactorAttempts(t *testing.T) {
+	tests := []struct {
+		name    string
+		locked  bool
+		check   func(s ServiceStruct) error
+		wantErr error
+	}{
+		{name: "step-up invalid code", check: func(s ServiceStruct) error {
+			return s.CheckStepUp(context.Background(), "user", 5000, "aaaaa-bbbbb")
+		}, wantErr: model.ErrInvalidOTP},
+		{name: "step-up locked", locked: true, check: func(s ServiceStruct) error {
+			return s.CheckStepUp(context.Background(), "user", 5000, "aaaaa-bbbbb")
+		}, wantErr: model.ErrTOTPLocked},
+		{name: "disable invalid code", check: func(s ServiceStruct) error {
+			return s.DisableTOTP(context.Background(), "user", "aaaaa-bbbbb")
+		}, wantErr: model.ErrInvalidOTP},
+		{name: "disable locked", locked: true, check: func(s ServiceStruct) error {
+			return s.DisableTOTP(context.Background(), "user", "aaaaa-bbbbb")
+		}, wantErr: model.ErrTOTPLocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &stepUpStorer{locked: tt.locked}
+			s := ServiceStruct{
+				storage: storage,
+				cfg: config.Config{
+					StepUpThreshold: 1000,
+					TOTPMaxAttempts: 5,
+					TOTPLockout:     15 * time.Minute,
+				},
+				Log: logger.InitLog(),
+			}
+
+			assert.ErrorIs(t, tt.check(s), tt.wantErr)
+			// каждый код проверяется через общий счетчик неверных попыток
+			assert.Equal(t, 1, storage.verified)
+			assert.Equal(t, model.OTPAttemptLimits{MaxFailures: 5, Lockout: 15 * time.Minute}, storage.limits)
+			assert.False(t, storage.disabled)
+		})
+	}
+}
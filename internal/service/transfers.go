@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+// Перевести баллы другому пользователю в пределах суточных лимитов
+func (s ServiceStruct) Transfer(ctx context.Context, sender string, req model.TransferRequest) (model.Transfer, error) {
+	if req.Recipient == "" {
+		return model.Transfer{}, fmt.Errorf("%w: recipient is required", model.ErrWrongRequest)
+	}
+	if req.Recipient == sender {
+		return model.Transfer{}, fmt.Errorf("%w: cannot transfer to yourself", model.ErrWrongRequest)
+	}
+	amount := int64(math.Round(req.Sum * 100))
+	if amount <= 0 {
+		return model.Transfer{}, fmt.Errorf("%w: sum must be positive", model.ErrWrongRequest)
+	}
+
+	limits := model.TransferLimits{
+		DailyAmount: int64(math.Round(s.cfg.TransferDailyLimit * 100)),
+		DailyCount:  s.cfg.TransferDailyCount,
+	}
+	return s.storage.Transfer(ctx, sender, req.Recipient, amount, limits)
+}
+
+func (s ServiceStruct) ReverseTransfer(ctx context.Context, id int64, admin string) (model.Transfer, error) {
+	return s.storage.ReverseTransfer(ctx, id, admin)
+}
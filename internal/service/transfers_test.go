@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// хранилище запоминает перевод, который сервис передал на запись
+type transferStorer struct {
+	Storer
+	amount int64
+	limits model.TransferLimits
+}
+
+func (t *transferStorer) Transfer(ctx context.Context, sender string, recipient string, amount int64,
+	limits model.TransferLimits) (model.Transfer, error) {
+	t.amount = amount
+	t.limits = limits
+	return model.Transfer{Sender: sender, Recipient: recipient}, nil
+}
+
+func TestTransfer(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     model.TransferRequest
+		wantErr error
+		amount  int64
+	}{
+		{name: "ok", req: model.TransferRequest{Recipient: "mom", Sum: 12.34}, amount: 1234},
+		{name: "no recipient", req: model.TransferRequest{Sum: 10}, wantErr: model.ErrWrongRequest},
+		{name: "to yourself", req: model.TransferRequest{Recipient: "user", Sum: 10}, wantErr: model.ErrWrongRequest},
+		{name: "negative sum", req: model.TransferRequest{Recipient: "mom", Sum: -1}, wantErr: model.ErrWrongRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &transferStorer{}
+			s := ServiceStruct{
+				storage: storage,
+				cfg:     config.Config{TransferDailyLimit: 5000, TransferDailyCount: 10},
+				Log:     logger.InitLog(),
+			}
+
+			_, err := s.Transfer(context.Background(), "user", tt.req)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.amount, storage.amount)
+			assert.Equal(t, model.TransferLimits{DailyAmount: 500000, DailyCount: 10}, storage.limits)
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+var (
+	createAdjustmentsTable = `CREATE TABLE IF NOT EXISTS
+							  adjustments(
+								id          BIGSERIAL PRIMARY KEY,
+								login       TEXT NOT NULL,
+								amount      INT NOT NULL,
+								reason      TEXT NOT NULL,
+								comment     TEXT NOT NULL,
+								status      TEXT NOT NULL,
+								created_by  TEXT NOT NULL,
+								approved_by TEXT,
+								created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+								decided_at  TIMESTAMPTZ
+							  )`
+
+	insertAdjustment = `INSERT INTO adjustments(login, amount, reason, comment, status, created_by, number)
+						VALUES($1, $2, $3, $4, $5, $6, $7)
+						RETURNING id, created_at`
+	selectAdjustmentForUpdate = `SELECT login, amount, reason, comment, status, created_by, created_at,
+									COALESCE(number, '')
+								 FROM adjustments WHERE id = $1 FOR UPDATE`
+	updateAdjustmentStatus = `UPDATE adjustments SET status = $1, approved_by = $2, decided_at = $3
+							  WHERE id = $4`
+	selectAdjustments = `SELECT id, login, amount, reason, comment, status, created_by,
+							 COALESCE(approved_by, ''), created_at, decided_at, COALESCE(number, '')
+						 FROM adjustments
+						 WHERE $1 = '' OR status = $1
+						 ORDER BY id`
+)
+
+// Сохранить корректировку; если apply - сразу провести ее по счету пользователя.
+// Сумма корректировки в копейках со знаком
+func (db *DBStruct) AddAdjustment(ctx context.Context, adj model.Adjustment, amount int64,
+	apply bool) (model.Adjustment, error) {
+
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Adjustment{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	balance, held, err := db.lockAvailable(ctx, tx, adj.Login)
+	if err != nil {
+		return model.Adjustment{}, err
+	}
+
+	adj.Status = model.AdjustmentPending
+	if apply {
+		// удержанные баллы уже обещаны партнеру, списать можно только доступные
+		if amount < 0 && balance-held+amount < 0 {
+			db.log.Error(model.ErrInsufficientBalance.Error())
+			return model.Adjustment{}, model.ErrInsufficientBalance
+		}
+		adj.Status = model.AdjustmentApplied
+	}
+
+	err = tx.QueryRow(ctx, insertAdjustment, adj.Login, amount, adj.Reason, adj.Comment,
+		adj.Status, adj.CreatedBy, nil).Scan(&adj.ID, &adj.CreatedAt)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Adjustment{}, err
+	}
+
+	if apply {
+		if err = db.applyAdjustment(ctx, tx, adj.Login, amount); err != nil {
+			return model.Adjustment{}, err
+		}
+	}
+
+	db.log.WithFields(logrus.Fields{
+		"id":     adj.ID,
+		"login":  adj.Login,
+		"amount": amount,
+		"status": adj.Status,
+	}).Info("Создана корректировка баланса")
+	return adj, tx.Commit(ctx)
+}
+
+// Утвердить или отклонить корректировку, ожидающую второго администратора
+func (db *DBStruct) DecideAdjustment(ctx context.Context, id int64, admin string,
+	approve bool) (model.Adjustment, error) {
+
+	var amount int64
+	adj := model.Adjustment{ID: id}
+
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Adjustment{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, selectAdjustmentForUpdate, id).Scan(&adj.Login, &amount, &adj.Reason,
+		&adj.Comment, &adj.Status, &adj.CreatedBy, &adj.CreatedAt, &adj.Number)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.Adjustment{}, model.ErrAdjustmentNotFound
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Adjustment{}, err
+	}
+	fillAdjustmentAmount(&adj, amount)
+
+	if adj.Status != model.AdjustmentPending {
+		return model.Adjustment{}, model.ErrAdjustmentDecided
+	}
+	// утвердить корректировку может только другой администратор
+	if approve && adj.CreatedBy == admin {
+		return model.Adjustment{}, model.ErrSameApprover
+	}
+
+	adj.Status = model.AdjustmentRejected
+	if approve {
+		balance, held, err := db.lockAvailable(ctx, tx, adj.Login)
+		if err != nil {
+			return model.Adjustment{}, err
+		}
+		if amount < 0 && balance-held+amount < 0 {
+			db.log.Error(model.ErrInsufficientBalance.Error())
+			return model.Adjustment{}, model.ErrInsufficientBalance
+		}
+		if adj.Number != "" {
+			err = db.approveReversal(ctx, tx, adj, amount)
+		} else {
+			err = db.applyAdjustment(ctx, tx, adj.Login, amount)
+		}
+		if err != nil {
+			return model.Adjustment{}, err
+		}
+		adj.Status = model.AdjustmentApplied
+	}
+
+	decidedAt := time.Now()
+	adj.ApprovedBy = admin
+	adj.DecidedAt = &decidedAt
+	if _, err = tx.Exec(ctx, updateAdjustmentStatus, adj.Status, admin, decidedAt, id); err != nil {
+		db.log.Error(err.Error())
+		return model.Adjustment{}, err
+	}
+
+	db.log.WithFields(logrus.Fields{
+		"id":     id,
+		"admin":  admin,
+		"status": adj.Status,
+	}).Info("Решение по корректировке баланса")
+	return adj, tx.Commit(ctx)
+}
+
+func (db *DBStruct) GetAdjustments(ctx context.Context, status string) ([]model.Adjustment, error) {
+	var adjustments []model.Adjustment
+	var amount int64
+
+	rows, err := db.pgxPool.Query(ctx, selectAdjustments, status)
+	if err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var adj model.Adjustment
+		err = rows.Scan(&adj.ID, &adj.Login, &amount, &adj.Reason, &adj.Comment, &adj.Status,
+			&adj.CreatedBy, &adj.ApprovedBy, &adj.CreatedAt, &adj.DecidedAt, &adj.Number)
+		if err != nil {
+			db.log.Error(err.Error())
+			return nil, err
+		}
+		fillAdjustmentAmount(&adj, amount)
+		adjustments = append(adjustments, adj)
+	}
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	return adjustments, nil
+}
+
+func (db *DBStruct) applyAdjustment(ctx context.Context, tx pgx.Tx, login string, amount int64) error {
+	if err := db.addHistory(ctx, tx, login, "", amount, model.TxAdjustment, time.Now()); err != nil {
+		return err
+	}
+	return db.addBalanceEvent(ctx, tx, login)
+}
+
+// Провести утвержденную отмену списания. Пока она ждала утверждения,
+// по списанию могли вернуть часть суммы, поэтому остаток проверяется заново
+func (db *DBStruct) approveReversal(ctx context.Context, tx pgx.Tx, adj model.Adjustment, amount int64) error {
+	remaining, err := db.withdrawalRemaining(ctx, tx, adj.Login, adj.Number, "")
+	if err != nil {
+		return err
+	}
+	if err = db.checkReversal(adj.Number, amount, remaining); err != nil {
+		return err
+	}
+	_, err = db.applyReversal(ctx, tx, adj.Login, adj.Number, amount, remaining, adj.Comment, adj.CreatedBy)
+	return err
+}
+
+// сумма хранится в копейках со знаком, в ответе - в рублях с направлением
+func fillAdjustmentAmount(adj *model.Adjustment, amount int64) {
+	adj.Type = model.AdjustmentCredit
+	if amount < 0 {
+		adj.Type = model.AdjustmentDebit
+		amount = -amount
+	}
+	adj.Amount = float64(amount) / 100
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+var (
+	selectUserInfo   = `SELECT role, blocked FROM users WHERE login = $1`
+	updateUserBlock  = `UPDATE users SET blocked = $1 WHERE login = $2`
+	updateUserRole   = `UPDATE users SET role = $1 WHERE login = $2`
+	selectOrderState = `SELECT status, accrual, login FROM orders WHERE number = $1 AND time IS NOT NULL
+						FOR UPDATE`
+	// зачтенная сумма фиксируется до смены статуса, повторная проверка
+	// проведет по балансу только разницу
+	requeueOrder = `UPDATE orders
+					SET credited = COALESCE(credited, CASE WHEN status = 'PROCESSED' THEN accrual ELSE 0 END),
+						status = 'NEW'
+					WHERE number = $1`
+)
+
+func (db *DBStruct) GetUser(ctx context.Context, login string) (model.User, error) {
+	user := model.User{Login: login}
+	row := db.pgxPool.QueryRow(ctx, selectUserInfo, login)
+	err := row.Scan(&user.Role, &user.Blocked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		db.log.WithFields(logrus.Fields{"login": login}).Error(model.ErrUserNotFound.Error())
+		return model.User{}, model.ErrUserNotFound
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.User{}, err
+	}
+	return user, nil
+}
+
+func (db *DBStruct) SetUserBlocked(ctx context.Context, login string, blocked bool) error {
+	tag, err := db.pgxPool.Exec(ctx, updateUserBlock, blocked, login)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrUserNotFound
+	}
+	return nil
+}
+
+func (db *DBStruct) SetUserRole(ctx context.Context, login string, role string) error {
+	tag, err := db.pgxPool.Exec(ctx, updateUserRole, role, login)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrUserNotFound
+	}
+	return nil
+}
+
+// Вернуть заказ в очередь опроса системы начислений. Обработанный заказ
+// возвращается только для повторной проверки начисления (reverify)
+func (db *DBStruct) RequeueOrder(ctx context.Context, number string, reverify bool) error {
+	var status, login string
+	var accrual int64
+
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// статус проверяется под блокировкой заказа, иначе параллельное обновление
+	// может обработать заказ между проверкой и возвратом в очередь
+	err = tx.QueryRow(ctx, selectOrderState, number).Scan(&status, &accrual, &login)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.ErrOrderNotFound
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+
+	// баллы по обработанному заказу уже начислены
+	if status == model.OrderProcessed && !reverify {
+		return model.ErrOrderProcessed
+	}
+
+	db.log.WithFields(logrus.Fields{
+		"number": number,
+		"status": status,
+	}).Info("Заказ возвращен в очередь на обработку")
+
+	if _, err = tx.Exec(ctx, requeueOrder, number); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	_, err = tx.Exec(ctx, insertOrderStatusChange, number, model.OrderNew, accrual, model.OrderSourceAdmin)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	err = db.addEvent(ctx, tx, login, model.EventOrderStatusChanged, model.OrderStatusEvent{
+		Number:  number,
+		Status:  model.OrderNew,
+		Accrual: float64(accrual) / 100,
+		Source:  model.OrderSourceAdmin,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+var (
+	createAPIKeysTable = `CREATE TABLE IF NOT EXISTS
+						  api_keys(
+							id          BIGSERIAL PRIMARY KEY,
+							prefix      TEXT UNIQUE NOT NULL,
+							hash        TEXT NOT NULL,
+							partner     TEXT NOT NULL,
+							permissions TEXT[] NOT NULL,
+							created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+							revoked_at  TIMESTAMPTZ
+						  )`
+
+	insertAPIKey = `INSERT INTO api_keys(prefix, hash, partner, permissions)
+					VALUES($1, $2, $3, $4)
+					RETURNING id, created_at`
+	selectAPIKey = `SELECT id, hash, partner, permissions, created_at
+					FROM api_keys
+					WHERE prefix = $1 AND revoked_at IS NULL`
+	selectAPIKeys = `SELECT id, prefix, partner, permissions, created_at, revoked_at
+					 FROM api_keys
+					 ORDER BY id`
+	revokeAPIKey = `UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`
+)
+
+func (db *DBStruct) AddAPIKey(ctx context.Context, key model.APIKey, hash string) (model.APIKey, error) {
+	err := db.pgxPool.QueryRow(ctx, insertAPIKey, key.Prefix, hash, key.Partner, key.Permissions).
+		Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.APIKey{}, err
+	}
+	db.log.WithFields(logrus.Fields{
+		"id":      key.ID,
+		"partner": key.Partner,
+	}).Info("Создан API ключ")
+	return key, nil
+}
+
+// Найти действующий ключ по префиксу, вернуть ключ и его хэш
+func (db *DBStruct) GetAPIKey(ctx context.Context, prefix string) (model.APIKey, string, error) {
+	var hash string
+	key := model.APIKey{Prefix: prefix}
+
+	err := db.pgxPool.QueryRow(ctx, selectAPIKey, prefix).
+		Scan(&key.ID, &hash, &key.Partner, &key.Permissions, &key.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.APIKey{}, "", model.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.APIKey{}, "", err
+	}
+	return key, hash, nil
+}
+
+func (db *DBStruct) GetAPIKeys(ctx context.Context) ([]model.APIKey, error) {
+	var keys []model.APIKey
+
+	rows, err := db.pgxPool.Query(ctx, selectAPIKeys)
+	if err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key model.APIKey
+		err = rows.Scan(&key.ID, &key.Prefix, &key.Partner, &key.Permissions, &key.CreatedAt, &key.RevokedAt)
+		if err != nil {
+			db.log.Error(err.Error())
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (db *DBStruct) RevokeAPIKey(ctx context.Context, id int64) error {
+	tag, err := db.pgxPool.Exec(ctx, revokeAPIKey, id)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrAPIKeyNotFound
+	}
+	db.log.WithFields(logrus.Fields{"id": id}).Info("API ключ отозван")
+	return nil
+}
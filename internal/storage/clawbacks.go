@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+// Сумма, зачтенная пользователю по заказу, хранится отдельно от accrual, чтобы
+// пересмотр начисления системой расчета проводился только на разницу.
+// Для заказов, обработанных до появления колонки, зачтено начисление PROCESSED заказа
+var (
+	alterOrdersCredited = `ALTER TABLE orders ADD COLUMN IF NOT EXISTS credited BIGINT`
+
+	createClawbacksTable = `CREATE TABLE IF NOT EXISTS
+							clawbacks(
+							  id         BIGSERIAL PRIMARY KEY,
+							  login      TEXT NOT NULL,
+							  number     TEXT NOT NULL,
+							  status     TEXT NOT NULL,
+							  credited   BIGINT NOT NULL,
+							  accrual    BIGINT NOT NULL,
+							  amount     BIGINT NOT NULL,
+							  debt       BIGINT NOT NULL,
+							  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+							)`
+
+	insertClawback = `INSERT INTO clawbacks(login, number, status, credited, accrual, amount, debt)
+					  VALUES($1, $2, $3, $4, $5, $6, $7)`
+	selectUserDebts = `SELECT id, number, debt FROM clawbacks
+					   WHERE login = $1 AND debt > 0
+					   ORDER BY id
+					   FOR UPDATE`
+	updateClawbackDebt = `UPDATE clawbacks SET debt = debt - $1 WHERE id = $2`
+	selectClawbacks    = `SELECT id, login, number, status, credited, accrual, amount, debt, created_at
+						  FROM clawbacks
+						  WHERE NOT $1 OR debt > 0
+						  ORDER BY id`
+)
+
+// Провести изменение зачтенной по заказу суммы на delta копеек: увеличение
+// сначала погашает долг пользователя, уменьшение списывается с доступного баланса,
+// а то, что списать не хватило, записывается в долг
+func (db *DBStruct) settleAccrual(ctx context.Context, tx pgx.Tx, login string, number string,
+	status string, credited int64, accrual int64) error {
+
+	delta := accrual - credited
+	if delta == 0 {
+		return nil
+	}
+	if delta > 0 {
+		if err := db.creditAccrual(ctx, tx, login, number, delta); err != nil {
+			return err
+		}
+		return db.addBalanceEvent(ctx, tx, login)
+	}
+
+	// удержанные баллы не списываются, иначе удержание нельзя будет провести
+	balance, held, err := db.lockAvailable(ctx, tx, login)
+	if err != nil {
+		return err
+	}
+	amount := -delta
+	taken := amount
+	if balance-held < taken {
+		taken = balance - held
+	}
+	if taken < 0 {
+		taken = 0
+	}
+	if taken > 0 {
+		if err = db.addClawback(ctx, tx, login, number, number, taken); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(ctx, insertClawback, login, number, status, credited, accrual, amount, amount-taken)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+
+	db.log.WithFields(logrus.Fields{
+		"login":    login,
+		"number":   number,
+		"status":   status,
+		"credited": credited,
+		"accrual":  accrual,
+		"debt":     amount - taken,
+	}).Warn("Начисление по заказу пересмотрено в меньшую сторону, требуется проверка администратором")
+	return db.addBalanceEvent(ctx, tx, login)
+}
+
+// Зачислить баллы по заказу, из них в первую очередь погашается долг
+// по прошлым пересмотрам начислений, от старых долгов к новым
+func (db *DBStruct) creditAccrual(ctx context.Context, tx pgx.Tx, login string, number string, amount int64) error {
+	if err := db.addHistory(ctx, tx, login, number, amount, model.TxAccrual, time.Now()); err != nil {
+		return err
+	}
+
+	type debt struct {
+		id     int64
+		number string
+		debt   int64
+	}
+	var debts []debt
+	rows, err := tx.Query(ctx, selectUserDebts, login)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	for rows.Next() {
+		var d debt
+		if err = rows.Scan(&d.id, &d.number, &d.debt); err != nil {
+			rows.Close()
+			db.log.Error(err.Error())
+			return err
+		}
+		debts = append(debts, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+
+	for _, d := range debts {
+		if amount == 0 {
+			break
+		}
+		paid := d.debt
+		if paid > amount {
+			paid = amount
+		}
+		// погашение относится к заказу, по которому возник долг, и расходует партию нового начисления
+		if err = db.addClawback(ctx, tx, login, d.number, number, paid); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(ctx, updateClawbackDebt, paid, d.id); err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+		amount -= paid
+		db.log.WithFields(logrus.Fields{
+			"login":  login,
+			"number": d.number,
+			"paid":   paid,
+		}).Info("Погашение долга по пересмотренному начислению")
+	}
+	return nil
+}
+
+// Списать amount копеек по пересмотру начисления заказа number. Сначала уменьшается
+// партия заказа lotNumber, чтобы списанные баллы не пережили ее, а уже израсходованная
+// часть берется из остальных партий по порядку
+func (db *DBStruct) addClawback(ctx context.Context, tx pgx.Tx, login string, number string,
+	lotNumber string, amount int64) error {
+
+	_, err := tx.Exec(ctx, addOrderHistory, number, -amount, time.Now().Format(time.RFC3339),
+		login, model.TxClawback, nil)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	_, err = db.consumeLotsOf(ctx, tx, login, lotNumber, 0, amount)
+	return err
+}
+
+// Пересмотренные в меньшую сторону начисления, openOnly - только с непогашенным долгом
+func (db *DBStruct) GetClawbacks(ctx context.Context, openOnly bool) ([]model.Clawback, error) {
+	var clawbacks []model.Clawback
+
+	rows, err := db.pgxPool.Query(ctx, selectClawbacks, openOnly)
+	if err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c model.Clawback
+		var credited, accrual, amount, debt int64
+		err = rows.Scan(&c.ID, &c.Login, &c.Number, &c.Status, &credited, &accrual, &amount, &debt, &c.CreatedAt)
+		if err != nil {
+			db.log.Error(err.Error())
+			return nil, err
+		}
+		c.Credited = float64(credited) / 100
+		c.Accrual = float64(accrual) / 100
+		c.Amount = float64(amount) / 100
+		c.Debt = float64(debt) / 100
+		clawbacks = append(clawbacks, c)
+	}
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	return clawbacks, nil
+}
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/config"
+	"github.com/kartalenka7/project_gophermart/internal/logger"
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClawbackStorage(t *testing.T) *DBStruct {
+	log := logger.InitLog()
+	cfg, err := config.GetConfig(log)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	storage, err := NewStorage(ctx, cfg.Database, log)
+	require.NoError(t, err)
+	t.Cleanup(storage.Close)
+	return storage
+}
+
+// пользователь с заказами, номера уникальны между запусками
+func newClawbackUser(t *testing.T, storage *DBStruct, orders int) (string, []string) {
+	ctx := context.Background()
+	login := fmt.Sprintf("clawback-%d", time.Now().UnixNano())
+	require.NoError(t, storage.AddUser(ctx, model.User{Login: login, Password: "hash"}))
+
+	var numbers []string
+	for i := 0; i < orders; i++ {
+		numbers = append(numbers, fmt.Sprintf("%d%d", time.Now().UnixNano(), i))
+	}
+	_, err := storage.AddOrders(ctx, numbers, login)
+	require.NoError(t, err)
+	return login, numbers
+}
+
+func updateOrder(t *testing.T, storage *DBStruct, number string, status string, accrual float64) {
+	err := storage.UpdateOrders(context.Background(), []model.PointsAppResponse{
+		{Number: number, Status: status, Accrual: accrual},
+	}, model.OrderSourcePoll)
+	require.NoError(t, err)
+}
+
+func userClawbacks(t *testing.T, storage *DBStruct, login string, openOnly bool) []model.Clawback {
+	clawbacks, err := storage.GetClawbacks(context.Background(), openOnly)
+	require.NoError(t, err)
+	var result []model.Clawback
+	for _, c := range clawbacks {
+		if c.Login == login {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func requireBalance(t *testing.T, storage *DBStruct, login string, want float64) {
+	balance, err := storage.GetBalance(context.Background(), login)
+	require.NoError(t, err)
+	assert.Equal(t, want, balance.Balance)
+}
+
+func TestSettleAccrualDebt(t *testing.T) {
+	ctx := context.Background()
+	storage := newClawbackStorage(t)
+	login, numbers := newClawbackUser(t, storage, 2)
+
+	updateOrder(t, storage, numbers[0], model.OrderProcessed, 100)
+	requireBalance(t, storage, login, 10000)
+	err := storage.WriteWithdraw(ctx, model.OrderWithdraw{
+		Number:   fmt.Sprintf("%d", time.Now().UnixNano()),
+		Withdraw: -6000,
+	}, login, nil)
+	require.NoError(t, err)
+
+	// снижение на 80 рублей при балансе 40: списывается 40, остальное в долг
+	updateOrder(t, storage, numbers[0], model.OrderProcessed, 20)
+	requireBalance(t, storage, login, 0)
+	clawbacks := userClawbacks(t, storage, login, true)
+	require.Len(t, clawbacks, 1)
+	assert.Equal(t, numbers[0], clawbacks[0].Number)
+	assert.Equal(t, float64(100), clawbacks[0].Credited)
+	assert.Equal(t, float64(20), clawbacks[0].Accrual)
+	assert.Equal(t, float64(80), clawbacks[0].Amount)
+	assert.Equal(t, float64(40), clawbacks[0].Debt)
+
+	// повторный ответ с тем же начислением ничего не меняет
+	updateOrder(t, storage, numbers[0], model.OrderProcessed, 20)
+	assert.Len(t, userClawbacks(t, storage, login, false), 1)
+
+	// следующее начисление сначала гасит долг
+	updateOrder(t, storage, numbers[1], model.OrderProcessed, 50)
+	requireBalance(t, storage, login, 1000)
+	assert.Empty(t, userClawbacks(t, storage, login, true))
+	clawbacks = userClawbacks(t, storage, login, false)
+	require.Len(t, clawbacks, 1)
+	assert.Equal(t, float64(0), clawbacks[0].Debt)
+
+	// погашение проводится по заказу, по которому возник долг
+	transactions, err := storage.GetTransactions(ctx, login, model.ListFilter{Limit: 100})
+	require.NoError(t, err)
+	var repaid int
+	for _, tx := range transactions {
+		if tx.Type == model.TxClawback && tx.Order == numbers[0] {
+			repaid++
+		}
+	}
+	assert.Equal(t, 2, repaid)
+}
+
+func TestSettleAccrualKeepsHeldPoints(t *testing.T) {
+	ctx := context.Background()
+	storage := newClawbackStorage(t)
+	login, numbers := newClawbackUser(t, storage, 1)
+
+	updateOrder(t, storage, numbers[0], model.OrderProcessed, 100)
+	hold, err := storage.CreateHold(ctx, login, fmt.Sprintf("%d", time.Now().UnixNano()), "", 6000,
+		time.Now().Add(time.Hour), nil)
+	require.NoError(t, err)
+
+	// снижение на 80 рублей при доступных 40: удержанные баллы не списываются, остальное в долг
+	updateOrder(t, storage, numbers[0], model.OrderProcessed, 20)
+	requireBalance(t, storage, login, 6000)
+	clawbacks := userClawbacks(t, storage, login, true)
+	require.Len(t, clawbacks, 1)
+	assert.Equal(t, float64(40), clawbacks[0].Debt)
+
+	_, err = storage.CaptureHold(ctx, login, hold.ID)
+	require.NoError(t, err)
+	requireBalance(t, storage, login, 0)
+}
+
+func TestSettleAccrualPartialDebtRepayment(t *testing.T) {
+	storage := newClawbackStorage(t)
+	login, numbers := newClawbackUser(t, storage, 3)
+
+	// два долга по 30 и 20 рублей при нулевом балансе
+	updateOrder(t, storage, numbers[0], model.OrderProcessed, 30)
+	updateOrder(t, storage, numbers[1], model.OrderProcessed, 20)
+	err := storage.WriteWithdraw(context.Background(), model.OrderWithdraw{
+		Number:   fmt.Sprintf("%d", time.Now().UnixNano()),
+		Withdraw: -5000,
+	}, login, nil)
+	require.NoError(t, err)
+	updateOrder(t, storage, numbers[0], model.OrderInvalid, 0)
+	updateOrder(t, storage, numbers[1], model.OrderInvalid, 0)
+	require.Len(t, userClawbacks(t, storage, login, true), 2)
+
+	// начисления не хватает на оба долга: старый гасится полностью, новый частично
+	updateOrder(t, storage, numbers[2], model.OrderProcessed, 40)
+	requireBalance(t, storage, login, 0)
+	clawbacks := userClawbacks(t, storage, login, false)
+	require.Len(t, clawbacks, 2)
+	assert.Equal(t, float64(0), clawbacks[0].Debt)
+	assert.Equal(t, float64(10), clawbacks[1].Debt)
+}
+
+func TestSettleAccrualLegacyCredited(t *testing.T) {
+	ctx := context.Background()
+	storage := newClawbackStorage(t)
+	login, numbers := newClawbackUser(t, storage, 2)
+
+	updateOrder(t, storage, numbers[0], model.OrderProcessed, 100)
+	// заказы, обработанные до появления колонки credited
+	_, err := storage.pgxPool.Exec(ctx, `UPDATE orders SET credited = NULL WHERE number = ANY($1)`, numbers)
+	require.NoError(t, err)
+
+	// для PROCESSED заказа зачтенным считается его начисление
+	updateOrder(t, storage, numbers[0], model.OrderProcessed, 60)
+	requireBalance(t, storage, login, 6000)
+	clawbacks := userClawbacks(t, storage, login, false)
+	require.Len(t, clawbacks, 1)
+	assert.Equal(t, float64(100), clawbacks[0].Credited)
+	assert.Equal(t, float64(40), clawbacks[0].Amount)
+	assert.Equal(t, float64(0), clawbacks[0].Debt)
+
+	// для необработанного заказа - ноль
+	updateOrder(t, storage, numbers[1], model.OrderProcessed, 30)
+	requireBalance(t, storage, login, 9000)
+	assert.Len(t, userClawbacks(t, storage, login, false), 1)
+}
+
+func TestRequeueProcessedOrder(t *testing.T) {
+	ctx := context.Background()
+	storage := newClawbackStorage(t)
+	login, numbers := newClawbackUser(t, storage, 1)
+
+	updateOrder(t, storage, numbers[0], model.OrderProcessed, 100)
+	assert.ErrorIs(t, storage.RequeueOrder(ctx, numbers[0], false), model.ErrOrderProcessed)
+
+	// при повторной проверке по балансу проводится только разница
+	require.NoError(t, storage.RequeueOrder(ctx, numbers[0], true))
+	updateOrder(t, storage, numbers[0], model.OrderProcessing, 0)
+	requireBalance(t, storage, login, 10000)
+	updateOrder(t, storage, numbers[0], model.OrderProcessed, 120)
+	requireBalance(t, storage, login, 12000)
+	assert.Empty(t, userClawbacks(t, storage, login, false))
+}
+
+func TestRequeueConcurrentUpdate(t *testing.T) {
+	ctx := context.Background()
+	storage := newClawbackStorage(t)
+	_, numbers := newClawbackUser(t, storage, 5)
+
+	// возврат в очередь без reverify не должен сбросить заказ, обработанный параллельно
+	for _, number := range numbers {
+		updateOrder(t, storage, number, model.OrderProcessing, 0)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func(number string) {
+			defer wg.Done()
+			updateOrder(t, storage, number, model.OrderProcessed, 100)
+		}(number)
+		var requeueErr error
+		go func(number string) {
+			defer wg.Done()
+			requeueErr = storage.RequeueOrder(ctx, number, false)
+		}(number)
+		wg.Wait()
+
+		if requeueErr != nil {
+			assert.ErrorIs(t, requeueErr, model.ErrOrderProcessed)
+		}
+		var status string
+		require.NoError(t, storage.pgxPool.QueryRow(ctx, `SELECT status FROM orders WHERE number = $1`, number).
+			Scan(&status))
+		assert.Equal(t, model.OrderProcessed, status)
+	}
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+// События хранятся в таблице events и рассылаются всем экземплярам сервиса
+// через NOTIFY. Уведомление уходит только после фиксации транзакции,
+// в которой произошло изменение, поэтому подписчики не видят отмененных событий
+var (
+	createEventsTable = `CREATE TABLE IF NOT EXISTS
+						 events(
+						   id         BIGSERIAL PRIMARY KEY,
+						   login      TEXT NOT NULL,
+						   type       TEXT NOT NULL,
+						   payload    JSONB NOT NULL,
+						   created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+						 )`
+	createEventsIndex = `CREATE INDEX IF NOT EXISTS events_login ON events(login, id)`
+
+	insertEvent = `WITH e AS (
+					 INSERT INTO events(login, type, payload) VALUES($1, $2, $3)
+					 RETURNING id, login, type, payload, created_at
+				   )
+				   SELECT pg_notify('gophermart_events', row_to_json(e)::text) FROM e`
+	// последние $3 событий после $2 в порядке возрастания
+	selectEventsAfter = `SELECT id, login, type, payload, created_at FROM (
+						   SELECT id, login, type, payload, created_at
+						   FROM events
+						   WHERE login = $1 AND id > $2
+						   ORDER BY id DESC
+						   LIMIT $3
+						 ) AS e
+						 ORDER BY id`
+	deleteEventsBefore   = `DELETE FROM events WHERE created_at < $1`
+	selectBalanceSummary = `SELECT COALESCE(SUM(withdraw), 0),
+								   COALESCE(SUM(withdraw) FILTER (WHERE type IN ('withdrawal', 'reversal')), 0)
+							FROM ordersHistory
+							WHERE login = $1`
+	listenEvents   = `LISTEN gophermart_events`
+	unlistenEvents = `UNLISTEN gophermart_events`
+)
+
+// Записать событие в транзакции, которая его вызвала.
+// Событие означает изменение данных, поэтому увеличивает и версию данных пользователя.
+// Идентификатор события выдается при вставке, а клиент запрашивает пропущенные события
+// по последнему полученному идентификатору. Поэтому вставка идет под блокировкой
+// пользователя: события одного пользователя фиксируются в порядке возрастания id,
+// и событие с меньшим id не может появиться после того, как клиент получил большее
+func (db *DBStruct) addEvent(ctx context.Context, tx pgx.Tx, login string, eventType string, data interface{}) error {
+	if err := db.lockUser(ctx, tx, login); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if _, err = tx.Exec(ctx, insertEvent, login, eventType, string(payload)); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return db.bumpVersion(ctx, tx, login)
+}
+
+// Записать событие с новым балансом пользователя
+func (db *DBStruct) addBalanceEvent(ctx context.Context, tx pgx.Tx, login string) error {
+	var current, withdrawn, held int64
+	if err := tx.QueryRow(ctx, selectBalanceSummary, login).Scan(&current, &withdrawn); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if err := tx.QueryRow(ctx, selectHeldAmount, login).Scan(&held); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return db.addEvent(ctx, tx, login, model.EventBalanceChanged, model.Balance{
+		Balance:   float64(current) / 100,
+		Available: float64(current-held) / 100,
+		Held:      float64(held) / 100,
+		Withdrawn: -float64(withdrawn) / 100,
+	})
+}
+
+// События пользователя после afterID, не больше limit последних
+func (db *DBStruct) GetEvents(ctx context.Context, login string, afterID int64, limit int) ([]model.Event, error) {
+	var events []model.Event
+
+	rows, err := db.pgxPool.Query(ctx, selectEventsAfter, login, afterID, limit)
+	if err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event model.Event
+		var payload []byte
+		if err = rows.Scan(&event.ID, &event.Login, &event.Type, &payload, &event.CreatedAt); err != nil {
+			db.log.Error(err.Error())
+			return nil, err
+		}
+		event.Data = payload
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	return events, nil
+}
+
+// Удалить события старше before, возвращает число удаленных
+func (db *DBStruct) DeleteEvents(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := db.pgxPool.Exec(ctx, deleteEventsBefore, before)
+	if err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Слушать события всех экземпляров сервиса, пока не отменен контекст
+// или не оборвалось соединение
+func (db *DBStruct) ListenEvents(ctx context.Context, handler func(model.Event)) error {
+	conn, err := db.pgxPool.Acquire(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	defer conn.Release()
+
+	if _, err = conn.Exec(ctx, listenEvents); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	// соединение возвращается в пул, подписка на нем больше не нужна
+	defer conn.Exec(context.Background(), unlistenEvents)
+
+	db.log.Info("Подписка на события в Postgres")
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var event model.Event
+		if err = json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			db.log.WithFields(logrus.Fields{"payload": notification.Payload}).Error(err.Error())
+			continue
+		}
+		handler(event)
+	}
+}
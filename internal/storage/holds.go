@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+var (
+	createHoldsTable = `CREATE TABLE IF NOT EXISTS
+						holds(
+						  id         BIGSERIAL PRIMARY KEY,
+						  login      TEXT NOT NULL,
+						  number     TEXT NOT NULL,
+						  amount     BIGINT NOT NULL,
+						  status     TEXT NOT NULL,
+						  expires_at TIMESTAMPTZ NOT NULL,
+						  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+						)`
+	createHoldsIndex = `CREATE INDEX IF NOT EXISTS holds_active_idx ON holds(login) WHERE status = 'ACTIVE'`
+
+	// просроченное, но еще не снятое воркером удержание баланс уже не уменьшает
+	selectHeldAmount = `SELECT COALESCE(SUM(amount), 0) FROM holds
+						WHERE login = $1 AND status = 'ACTIVE' AND expires_at > now()`
+	insertHold = `INSERT INTO holds(login, number, amount, status, expires_at, partner)
+				  VALUES($1, $2, $3, 'ACTIVE', $4, $5)
+				  RETURNING id, created_at`
+	selectHoldForUpdate = `SELECT number, amount, status, expires_at, created_at, COALESCE(partner, '') FROM holds
+						   WHERE id = $1 AND login = $2
+						   FOR UPDATE`
+	alterHoldsPartner = `ALTER TABLE holds ADD COLUMN IF NOT EXISTS partner TEXT`
+	updateHoldStatus  = `UPDATE holds SET status = $1 WHERE id = $2`
+	expireHolds       = `UPDATE holds SET status = 'EXPIRED'
+						WHERE status = 'ACTIVE' AND expires_at <= now()
+						RETURNING login`
+)
+
+// Заблокировать пользователя до конца транзакции и вернуть его баланс
+// и сумму действующих удержаний в копейках
+func (db *DBStruct) lockAvailable(ctx context.Context, tx pgx.Tx, login string) (int64, int64, error) {
+	balance, err := db.lockBalance(ctx, tx, login)
+	if err != nil {
+		return 0, 0, err
+	}
+	var held int64
+	if err = tx.QueryRow(ctx, selectHeldAmount, login).Scan(&held); err != nil {
+		db.log.Error(err.Error())
+		return 0, 0, err
+	}
+	return balance, held, nil
+}
+
+// Удержать amount копеек под заказ до expiresAt, удержание не может
+// превысить доступный баланс и лимиты списаний. partner - партнер, создающий удержание
+func (db *DBStruct) CreateHold(ctx context.Context, login string, number string, partner string, amount int64,
+	expiresAt time.Time, check model.WithdrawalCheck) (model.Hold, error) {
+
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Hold{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	balance, held, err := db.lockAvailable(ctx, tx, login)
+	if err != nil {
+		return model.Hold{}, err
+	}
+	if balance-held < amount {
+		db.log.Error(model.ErrInsufficientBalance.Error())
+		return model.Hold{}, model.ErrInsufficientBalance
+	}
+	// удержание - будущее списание, лимиты проверяются при удержании, а не при capture
+	if err = db.checkWithdrawal(ctx, tx, login, check); err != nil {
+		return model.Hold{}, err
+	}
+
+	hold := model.Hold{
+		Number:    number,
+		Sum:       float64(amount) / 100,
+		Status:    model.HoldActive,
+		ExpiresAt: expiresAt,
+		Partner:   partner,
+	}
+	err = tx.QueryRow(ctx, insertHold, login, number, amount, expiresAt, nullString(partner)).
+		Scan(&hold.ID, &hold.CreatedAt)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Hold{}, err
+	}
+	if err = db.addBalanceEvent(ctx, tx, login); err != nil {
+		return model.Hold{}, err
+	}
+
+	db.log.WithFields(logrus.Fields{
+		"login":     login,
+		"number":    number,
+		"amount":    amount,
+		"expiresAt": expiresAt,
+	}).Info("Баллы удержаны")
+	return hold, tx.Commit(ctx)
+}
+
+// Заблокировать действующее удержание пользователя. Просроченное удержание
+// не может быть списано или отменено, даже если воркер еще не пометил его истекшим
+func (db *DBStruct) lockHold(ctx context.Context, tx pgx.Tx, login string, id int64) (model.Hold, int64, error) {
+	var amount int64
+	hold := model.Hold{ID: id}
+
+	err := tx.QueryRow(ctx, selectHoldForUpdate, id, login).
+		Scan(&hold.Number, &amount, &hold.Status, &hold.ExpiresAt, &hold.CreatedAt, &hold.Partner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.Hold{}, 0, model.ErrHoldNotFound
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Hold{}, 0, err
+	}
+	hold.Sum = float64(amount) / 100
+
+	if hold.Status != model.HoldActive || !hold.ExpiresAt.After(time.Now()) {
+		return model.Hold{}, 0, model.ErrHoldNotActive
+	}
+	return hold, amount, nil
+}
+
+// Списать удержанные баллы: удержание превращается в обычное списание по заказу
+func (db *DBStruct) CaptureHold(ctx context.Context, login string, id int64) (model.Hold, error) {
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Hold{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// пользователь блокируется раньше удержания, как и при остальных операциях с балансом
+	balance, err := db.lockBalance(ctx, tx, login)
+	if err != nil {
+		return model.Hold{}, err
+	}
+	hold, amount, err := db.lockHold(ctx, tx, login, id)
+	if err != nil {
+		return model.Hold{}, err
+	}
+	// баланс мог уменьшиться после удержания, например при пересмотре начисления
+	if balance < amount {
+		db.log.Error(model.ErrInsufficientBalance.Error())
+		return model.Hold{}, model.ErrInsufficientBalance
+	}
+
+	processedAt := time.Now()
+	// списание записывается на партнера, создавшего удержание
+	if err = db.addWithdrawal(ctx, tx, login, hold.Number, -amount, hold.Partner, processedAt); err != nil {
+		return model.Hold{}, err
+	}
+	if _, err = tx.Exec(ctx, insertWithdrawOrder, hold.Number, login); err != nil {
+		db.log.Error(err.Error())
+		return model.Hold{}, err
+	}
+	if _, err = tx.Exec(ctx, updateHoldStatus, model.HoldCaptured, id); err != nil {
+		db.log.Error(err.Error())
+		return model.Hold{}, err
+	}
+	err = db.addEvent(ctx, tx, login, model.EventWithdrawalCreated, model.OrderWithdraw{
+		Number:   hold.Number,
+		Withdraw: hold.Sum,
+		Time:     processedAt,
+	})
+	if err != nil {
+		return model.Hold{}, err
+	}
+	if err = db.addBalanceEvent(ctx, tx, login); err != nil {
+		return model.Hold{}, err
+	}
+
+	hold.Status = model.HoldCaptured
+	db.log.WithFields(logrus.Fields{
+		"login":  login,
+		"id":     id,
+		"number": hold.Number,
+	}).Info("Удержанные баллы списаны")
+	return hold, tx.Commit(ctx)
+}
+
+// Отменить удержание, баллы снова становятся доступными
+func (db *DBStruct) VoidHold(ctx context.Context, login string, id int64) (model.Hold, error) {
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Hold{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = db.lockBalance(ctx, tx, login); err != nil {
+		return model.Hold{}, err
+	}
+	hold, _, err := db.lockHold(ctx, tx, login, id)
+	if err != nil {
+		return model.Hold{}, err
+	}
+	if _, err = tx.Exec(ctx, updateHoldStatus, model.HoldVoided, id); err != nil {
+		db.log.Error(err.Error())
+		return model.Hold{}, err
+	}
+	if err = db.addBalanceEvent(ctx, tx, login); err != nil {
+		return model.Hold{}, err
+	}
+
+	hold.Status = model.HoldVoided
+	db.log.WithFields(logrus.Fields{
+		"login": login,
+		"id":    id,
+	}).Info("Удержание отменено")
+	return hold, tx.Commit(ctx)
+}
+
+// Пометить истекшими просроченные удержания, возвращает их количество
+func (db *DBStruct) ExpireHolds(ctx context.Context) (int64, error) {
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, expireHolds)
+	if err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+	var count int64
+	logins := make(map[string]bool)
+	for rows.Next() {
+		var login string
+		if err = rows.Scan(&login); err != nil {
+			rows.Close()
+			db.log.Error(err.Error())
+			return 0, err
+		}
+		logins[login] = true
+		count++
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+
+	for login := range logins {
+		if err = db.addBalanceEvent(ctx, tx, login); err != nil {
+			return 0, err
+		}
+	}
+	return count, tx.Commit(ctx)
+}
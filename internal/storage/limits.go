@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+var (
+	// лимиты пользователя, заданные администратором, суммы в копейках,
+	// NULL - значение по умолчанию
+	createWithdrawalLimitsTable = `CREATE TABLE IF NOT EXISTS
+								   withdrawal_limits(
+									 login               TEXT PRIMARY KEY,
+									 min_amount          BIGINT,
+									 max_per_transaction BIGINT,
+									 max_daily           BIGINT,
+									 max_30d             BIGINT,
+									 max_order_fraction  DOUBLE PRECISION,
+									 updated_by          TEXT NOT NULL,
+									 updated_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+								   )`
+
+	selectWithdrawalLimits = `SELECT min_amount, max_per_transaction, max_daily, max_30d, max_order_fraction
+							  FROM withdrawal_limits WHERE login = $1`
+	upsertWithdrawalLimits = `INSERT INTO withdrawal_limits(login, min_amount, max_per_transaction, max_daily,
+													  max_30d, max_order_fraction, updated_by)
+							  VALUES($1, $2, $3, $4, $5, $6, $7)
+							  ON CONFLICT (login) DO UPDATE
+							  SET min_amount = $2, max_per_transaction = $3, max_daily = $4, max_30d = $5,
+								  max_order_fraction = $6, updated_by = $7, updated_at = now()`
+	deleteWithdrawalLimits = `DELETE FROM withdrawal_limits WHERE login = $1`
+
+	// списания за 30 дней и за сутки внутри них
+	selectWithdrawnSince = `SELECT COALESCE(-SUM(withdraw) FILTER (WHERE time::timestamptz > $2), 0),
+								   COALESCE(-SUM(withdraw), 0)
+							FROM ordersHistory
+							WHERE login = $1 AND type = 'withdrawal' AND time::timestamptz > $3`
+	selectHeldSince = `SELECT COALESCE(SUM(amount) FILTER (WHERE created_at > $2), 0),
+							  COALESCE(SUM(amount), 0)
+					   FROM holds
+					   WHERE login = $1 AND status = 'ACTIVE' AND expires_at > now() AND created_at > $3`
+)
+
+// Списания пользователя за сутки и 30 дней вместе с удержаниями и его лимиты.
+// Вызывается под блокировкой пользователя
+func (db *DBStruct) withdrawalUsage(ctx context.Context, tx pgx.Tx, login string) (model.WithdrawalUsage, error) {
+	var usage model.WithdrawalUsage
+	now := time.Now()
+	dayAgo, monthAgo := now.Add(-24*time.Hour), now.AddDate(0, 0, -30)
+
+	err := tx.QueryRow(ctx, selectWithdrawnSince, login, dayAgo, monthAgo).Scan(&usage.Daily, &usage.Monthly)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.WithdrawalUsage{}, err
+	}
+	var heldDaily, heldMonthly int64
+	err = tx.QueryRow(ctx, selectHeldSince, login, dayAgo, monthAgo).Scan(&heldDaily, &heldMonthly)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.WithdrawalUsage{}, err
+	}
+	usage.Daily += heldDaily
+	usage.Monthly += heldMonthly
+
+	if usage.Overrides, err = db.getWithdrawalLimits(ctx, tx, login); err != nil {
+		return model.WithdrawalUsage{}, err
+	}
+	return usage, nil
+}
+
+// Проверить списание по лимитам пользователя в транзакции списания
+func (db *DBStruct) checkWithdrawal(ctx context.Context, tx pgx.Tx, login string, check model.WithdrawalCheck) error {
+	if check == nil {
+		return nil
+	}
+	usage, err := db.withdrawalUsage(ctx, tx, login)
+	if err != nil {
+		return err
+	}
+	if err = check(usage); err != nil {
+		db.log.WithFields(logrus.Fields{
+			"login":   login,
+			"daily":   usage.Daily,
+			"monthly": usage.Monthly,
+		}).Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// пул или транзакция
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+func (db *DBStruct) getWithdrawalLimits(ctx context.Context, q queryRower, login string) (model.WithdrawalLimits, error) {
+	var min, maxTx, maxDaily, max30 *int64
+	var limits model.WithdrawalLimits
+
+	err := q.QueryRow(ctx, selectWithdrawalLimits, login).
+		Scan(&min, &maxTx, &maxDaily, &max30, &limits.MaxOrderFraction)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.WithdrawalLimits{}, nil
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.WithdrawalLimits{}, err
+	}
+	limits.Min = toRubles(min)
+	limits.MaxPerTransaction = toRubles(maxTx)
+	limits.MaxDaily = toRubles(maxDaily)
+	limits.Max30Days = toRubles(max30)
+	return limits, nil
+}
+
+// Лимиты, переопределенные администратором для пользователя
+func (db *DBStruct) GetWithdrawalLimits(ctx context.Context, login string) (model.WithdrawalLimits, error) {
+	var role string
+	var blocked bool
+	err := db.pgxPool.QueryRow(ctx, selectUserInfo, login).Scan(&role, &blocked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.WithdrawalLimits{}, model.ErrUserNotFound
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.WithdrawalLimits{}, err
+	}
+	return db.getWithdrawalLimits(ctx, db.pgxPool, login)
+}
+
+// Переопределить лимиты пользователя, пустые поля - значения по умолчанию
+func (db *DBStruct) SetWithdrawalLimits(ctx context.Context, login string, limits model.WithdrawalLimits,
+	admin string) error {
+
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// блокировка пользователя упорядочивает изменение лимитов со списаниями
+	if _, err = db.lockBalance(ctx, tx, login); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, upsertWithdrawalLimits, login, toKopecks(limits.Min), toKopecks(limits.MaxPerTransaction),
+		toKopecks(limits.MaxDaily), toKopecks(limits.Max30Days), limits.MaxOrderFraction, admin)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+
+	db.log.WithFields(logrus.Fields{
+		"login": login,
+		"admin": admin,
+	}).Info("Лимиты списаний пользователя изменены")
+	return tx.Commit(ctx)
+}
+
+// Вернуть пользователю лимиты по умолчанию
+func (db *DBStruct) DeleteWithdrawalLimits(ctx context.Context, login string) error {
+	if _, err := db.pgxPool.Exec(ctx, deleteWithdrawalLimits, login); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+func toRubles(kopecks *int64) *float64 {
+	if kopecks == nil {
+		return nil
+	}
+	rubles := float64(*kopecks) / 100
+	return &rubles
+}
+
+func toKopecks(rubles *float64) *int64 {
+	if rubles == nil {
+		return nil
+	}
+	kopecks := int64(math.Round(*rubles * 100))
+	return &kopecks
+}
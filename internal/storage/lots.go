@@ -0,0 +1,433 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+// Каждое зачисление на баланс - партия баллов, списания расходуют партии
+// от старых к новым. Сгорают только партии начислений (expiring), срок
+// считается от accrued_at по текущей политике сервиса
+var (
+	createLotsTable = `CREATE TABLE IF NOT EXISTS
+					   point_lots(
+						 id         BIGSERIAL PRIMARY KEY,
+						 login      TEXT NOT NULL,
+						 number     TEXT,
+						 amount     BIGINT NOT NULL,
+						 remaining  BIGINT NOT NULL,
+						 expiring   BOOLEAN NOT NULL,
+						 accrued_at TIMESTAMPTZ NOT NULL DEFAULT now()
+					   )`
+	createLotsIndex = `CREATE INDEX IF NOT EXISTS point_lots_open_idx ON point_lots(login, id) WHERE remaining > 0`
+	// баланс, накопленный до появления партий, переносится одной несгораемой партией;
+	// выполняется, только пока партий еще нет
+	fillLots = `INSERT INTO point_lots(login, amount, remaining, expiring)
+				SELECT login, SUM(withdraw), SUM(withdraw), false
+				FROM ordersHistory
+				WHERE login IS NOT NULL
+				GROUP BY login
+				HAVING SUM(withdraw) > 0 AND NOT EXISTS (SELECT 1 FROM point_lots)`
+
+	// партии получателя перевода помечаются переводом, отмена забирает их первыми
+	alterLotsTransfer = `ALTER TABLE point_lots ADD COLUMN IF NOT EXISTS transfer_id BIGINT`
+
+	insertLot = `INSERT INTO point_lots(login, number, amount, remaining, expiring, accrued_at, transfer_id)
+				 VALUES($1, $2, $3, $3, $4, $5, $6)`
+	// сначала партии заказа $2 или перевода $3, затем остальные по порядку
+	selectOpenLots = `SELECT id, number, remaining, expiring, accrued_at FROM point_lots
+					  WHERE login = $1 AND remaining > 0
+					  ORDER BY (number = $2 OR transfer_id = $3) IS TRUE DESC, id
+					  FOR UPDATE`
+	updateLotRemaining = `UPDATE point_lots SET remaining = $1 WHERE id = $2`
+
+	selectExpiredLogins = `SELECT DISTINCT login FROM point_lots
+						   WHERE expiring AND remaining > 0 AND accrued_at < $1`
+	selectExpiredLots = `SELECT id, number, remaining FROM point_lots
+						 WHERE login = $1 AND expiring AND remaining > 0 AND accrued_at < $2
+						 ORDER BY id
+						 FOR UPDATE`
+	selectExpiringAmount = `SELECT COALESCE(SUM(remaining), 0) FROM point_lots
+							WHERE login = $1 AND expiring AND remaining > 0 AND accrued_at < $2`
+
+	// части партий, израсходованные списанием по заказу или переводом: отмена
+	// возвращает их с исходной датой начисления, restored - сколько уже возвращено
+	createLotConsumptionsTable = `CREATE TABLE IF NOT EXISTS
+								  lot_consumptions(
+									id          BIGSERIAL PRIMARY KEY,
+									login       TEXT NOT NULL,
+									number      TEXT,
+									transfer_id BIGINT,
+									lot_number  TEXT,
+									amount      BIGINT NOT NULL,
+									restored    BIGINT NOT NULL DEFAULT 0,
+									expiring    BOOLEAN NOT NULL,
+									accrued_at  TIMESTAMPTZ NOT NULL
+								  )`
+	createLotConsumptionsIndex = `CREATE INDEX IF NOT EXISTS lot_consumptions_login_idx ON lot_consumptions(login)`
+
+	insertLotConsumption = `INSERT INTO lot_consumptions(login, number, transfer_id, lot_number, amount,
+															 expiring, accrued_at)
+							VALUES($1, $2, $3, $4, $5, $6, $7)`
+	selectLotConsumptions = `SELECT id, lot_number, amount - restored, expiring, accrued_at FROM lot_consumptions
+							 WHERE login = $1 AND (number = $2 OR transfer_id = $3) AND restored < amount
+							 ORDER BY id DESC
+							 FOR UPDATE`
+	updateLotRestored = `UPDATE lot_consumptions SET restored = restored + $1 WHERE id = $2`
+)
+
+// Записать операцию в историю и обновить партии баллов: зачисление открывает
+// новую партию, списание расходует открытые партии по порядку
+func (db *DBStruct) addHistory(ctx context.Context, tx pgx.Tx, login string, number string,
+	amount int64, txType string, processedAt time.Time) error {
+
+	// у корректировок нет номера заказа
+	orderNumber := nullString(number)
+	_, err := tx.Exec(ctx, addOrderHistory, orderNumber, amount, processedAt.Format(time.RFC3339),
+		login, txType, nil)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+
+	if amount > 0 {
+		return db.addLot(ctx, tx, login, pointLot{
+			number:    orderNumber,
+			amount:    amount,
+			expiring:  txType == model.TxAccrual,
+			accruedAt: processedAt,
+		})
+	}
+	_, err = db.consumeLots(ctx, tx, login, -amount)
+	return err
+}
+
+// Пустая строка записывается в базу как NULL
+func nullString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// Нулевой идентификатор записывается в базу как NULL
+func nullInt64(value int64) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
+
+// Записать списание по заказу, amount - отрицательная сумма в копейках.
+// partner - партнер, проводящий списание по API ключу, пусто - сам пользователь
+func (db *DBStruct) addWithdrawal(ctx context.Context, tx pgx.Tx, login string, number string,
+	amount int64, partner string, processedAt time.Time) error {
+
+	_, err := tx.Exec(ctx, insertWithdrawalHistory, number, amount, processedAt.Format(time.RFC3339),
+		login, nullString(partner))
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	lots, err := db.consumeLots(ctx, tx, login, -amount)
+	if err != nil {
+		return err
+	}
+	return db.addConsumptions(ctx, tx, login, number, 0, lots)
+}
+
+// Записать возврат amount копеек по списанию: баллы возвращаются в партии,
+// израсходованные списанием
+func (db *DBStruct) addReversal(ctx context.Context, tx pgx.Tx, login string, number string,
+	amount int64, processedAt time.Time) error {
+
+	_, err := tx.Exec(ctx, addOrderHistory, number, amount, processedAt.Format(time.RFC3339),
+		login, model.TxReversal, nil)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return db.restoreLots(ctx, tx, login, number, 0, amount, processedAt)
+}
+
+// Партия баллов или ее израсходованная часть, transferID - перевод,
+// которым партия получена
+type pointLot struct {
+	id         int64
+	number     interface{}
+	amount     int64
+	expiring   bool
+	accruedAt  time.Time
+	transferID int64
+}
+
+func (db *DBStruct) addLot(ctx context.Context, tx pgx.Tx, login string, lot pointLot) error {
+	_, err := tx.Exec(ctx, insertLot, login, lot.number, lot.amount, lot.expiring, lot.accruedAt,
+		nullInt64(lot.transferID))
+	if err != nil {
+		db.log.Error(err.Error())
+	}
+	return err
+}
+
+// Израсходовать amount копеек из открытых партий по порядку, возвращает
+// израсходованные части партий
+func (db *DBStruct) consumeLots(ctx context.Context, tx pgx.Tx, login string, amount int64) ([]pointLot, error) {
+	return db.consumeLotsOf(ctx, tx, login, "", 0, amount)
+}
+
+// Израсходовать amount копеек сначала из партий заказа number или перевода transferID,
+// а то, что в них уже израсходовано, - из остальных партий по порядку
+func (db *DBStruct) consumeLotsOf(ctx context.Context, tx pgx.Tx, login string, number string,
+	transferID int64, amount int64) ([]pointLot, error) {
+
+	var lots []pointLot
+	rows, err := tx.Query(ctx, selectOpenLots, login, nullString(number), nullInt64(transferID))
+	if err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	for rows.Next() {
+		var l pointLot
+		var number *string
+		if err = rows.Scan(&l.id, &number, &l.amount, &l.expiring, &l.accruedAt); err != nil {
+			rows.Close()
+			db.log.Error(err.Error())
+			return nil, err
+		}
+		if number != nil {
+			l.number = *number
+		}
+		lots = append(lots, l)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+
+	var used []pointLot
+	for _, l := range lots {
+		if amount == 0 {
+			break
+		}
+		part := l
+		if part.amount > amount {
+			part.amount = amount
+		}
+		if _, err = tx.Exec(ctx, updateLotRemaining, l.amount-part.amount, l.id); err != nil {
+			db.log.Error(err.Error())
+			return nil, err
+		}
+		amount -= part.amount
+		used = append(used, part)
+	}
+	return used, nil
+}
+
+// Запомнить части партий, израсходованные списанием по заказу number
+// или переводом transferID
+func (db *DBStruct) addConsumptions(ctx context.Context, tx pgx.Tx, login string, number string,
+	transferID int64, lots []pointLot) error {
+
+	for _, lot := range lots {
+		_, err := tx.Exec(ctx, insertLotConsumption, login, nullString(number), nullInt64(transferID), lot.number,
+			lot.amount, lot.expiring, lot.accruedAt)
+		if err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// Вернуть пользователю amount копеек в партии, израсходованные списанием по заказу
+// number или переводом transferID, начиная с израсходованных последними. Сумма сверх
+// записанных частей, например по списаниям до их учета, возвращается несгораемой партией
+func (db *DBStruct) restoreLots(ctx context.Context, tx pgx.Tx, login string, number string,
+	transferID int64, amount int64, processedAt time.Time) error {
+
+	type consumption struct {
+		id  int64
+		lot pointLot
+	}
+	var consumptions []consumption
+	rows, err := tx.Query(ctx, selectLotConsumptions, login, number, transferID)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	for rows.Next() {
+		var c consumption
+		var lotNumber *string
+		err = rows.Scan(&c.id, &lotNumber, &c.lot.amount, &c.lot.expiring, &c.lot.accruedAt)
+		if err != nil {
+			rows.Close()
+			db.log.Error(err.Error())
+			return err
+		}
+		if lotNumber != nil {
+			c.lot.number = *lotNumber
+		}
+		consumptions = append(consumptions, c)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+
+	for _, c := range consumptions {
+		if amount == 0 {
+			break
+		}
+		part := c.lot
+		if part.amount > amount {
+			part.amount = amount
+		}
+		if err = db.addLot(ctx, tx, login, part); err != nil {
+			return err
+		}
+		if _, err = tx.Exec(ctx, updateLotRestored, part.amount, c.id); err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+		amount -= part.amount
+	}
+	if amount > 0 {
+		return db.addLot(ctx, tx, login, pointLot{amount: amount, accruedAt: processedAt})
+	}
+	return nil
+}
+
+// Сжечь остатки партий начислений, начисленных раньше accruedBefore.
+// Каждый пользователь обрабатывается в своей транзакции, возвращает
+// сумму сгоревших баллов в копейках
+func (db *DBStruct) ExpirePoints(ctx context.Context, accruedBefore time.Time) (int64, error) {
+	var logins []string
+	rows, err := db.pgxPool.Query(ctx, selectExpiredLogins, accruedBefore)
+	if err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+	for rows.Next() {
+		var login string
+		if err = rows.Scan(&login); err != nil {
+			rows.Close()
+			db.log.Error(err.Error())
+			return 0, err
+		}
+		logins = append(logins, login)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+
+	var total int64
+	for _, login := range logins {
+		expired, err := db.expireUserPoints(ctx, login, accruedBefore)
+		if err != nil {
+			return total, err
+		}
+		total += expired
+	}
+	return total, nil
+}
+
+func (db *DBStruct) expireUserPoints(ctx context.Context, login string, accruedBefore time.Time) (int64, error) {
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// удержанные баллы не сгорают, пока удержание действует: сгореть может только
+	// доступный баланс, остаток просроченных партий сгорит после capture или void
+	balance, held, err := db.lockAvailable(ctx, tx, login)
+	if err != nil {
+		return 0, err
+	}
+	available := balance - held
+
+	type lot struct {
+		id        int64
+		number    *string
+		remaining int64
+	}
+	var lots []lot
+	rows, err := tx.Query(ctx, selectExpiredLots, login, accruedBefore)
+	if err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+	for rows.Next() {
+		var l lot
+		if err = rows.Scan(&l.id, &l.number, &l.remaining); err != nil {
+			rows.Close()
+			db.log.Error(err.Error())
+			return 0, err
+		}
+		lots = append(lots, l)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+
+	var expired int64
+	processedAt := time.Now()
+	for _, l := range lots {
+		burned := l.remaining
+		if burned > available-expired {
+			burned = available - expired
+		}
+		if burned <= 0 {
+			break
+		}
+		// сгорание записывается в историю по заказу партии напрямую,
+		// чтобы не расходовать другие партии
+		_, err = tx.Exec(ctx, addOrderHistory, l.number, -burned, processedAt.Format(time.RFC3339),
+			login, model.TxExpiry, nil)
+		if err != nil {
+			db.log.Error(err.Error())
+			return 0, err
+		}
+		if _, err = tx.Exec(ctx, updateLotRemaining, l.remaining-burned, l.id); err != nil {
+			db.log.Error(err.Error())
+			return 0, err
+		}
+		expired += burned
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	if err = db.addBalanceEvent(ctx, tx, login); err != nil {
+		return 0, err
+	}
+
+	db.log.WithFields(logrus.Fields{
+		"login":   login,
+		"expired": expired,
+	}).Info("Баллы сгорели")
+	return expired, tx.Commit(ctx)
+}
+
+// Сколько баллов пользователя в копейках сгорит из партий, начисленных раньше accruedBefore
+func (db *DBStruct) GetExpiringPoints(ctx context.Context, login string, accruedBefore time.Time) (int64, error) {
+	var amount int64
+	err := db.pgxPool.QueryRow(ctx, selectExpiringAmount, login, accruedBefore).Scan(&amount)
+	if err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+	return amount, nil
+}
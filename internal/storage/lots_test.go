@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lotRemaining(t *testing.T, storage *DBStruct, number string) int64 {
+	var remaining int64
+	require.NoError(t, storage.pgxPool.QueryRow(context.Background(),
+		`SELECT remaining FROM point_lots WHERE number = $1`, number).Scan(&remaining))
+	return remaining
+}
+
+func TestExpireKeepsHeldPoints(t *testing.T) {
+	ctx := context.Background()
+	storage := newClawbackStorage(t)
+	login, numbers := newClawbackUser(t, storage, 1)
+
+	updateOrder(t, storage, numbers[0], model.OrderProcessed, 100)
+	hold, err := storage.CreateHold(ctx, login, fmt.Sprintf("%d", time.Now().UnixNano()), "", 6000,
+		time.Now().Add(time.Hour), nil)
+	require.NoError(t, err)
+
+	// сгорает только доступная часть партии, удержанные баллы остаются
+	expired, err := storage.expireUserPoints(ctx, login, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(4000), expired)
+	requireBalance(t, storage, login, 6000)
+	assert.Equal(t, int64(6000), lotRemaining(t, storage, numbers[0]))
+
+	// пока удержание действует, повторный запуск ничего не сжигает
+	expired, err = storage.expireUserPoints(ctx, login, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), expired)
+
+	_, err = storage.CaptureHold(ctx, login, hold.ID)
+	require.NoError(t, err)
+	requireBalance(t, storage, login, 0)
+	assert.Equal(t, int64(0), lotRemaining(t, storage, numbers[0]))
+}
+
+func TestClawbackSpendsOrderLot(t *testing.T) {
+	storage := newClawbackStorage(t)
+	login, numbers := newClawbackUser(t, storage, 2)
+
+	updateOrder(t, storage, numbers[0], model.OrderProcessed, 100)
+	updateOrder(t, storage, numbers[1], model.OrderProcessed, 50)
+
+	// снижение начисления забирает баллы из партии этого заказа, а не из самой старой
+	updateOrder(t, storage, numbers[1], model.OrderProcessed, 20)
+	requireBalance(t, storage, login, 12000)
+	assert.Equal(t, int64(10000), lotRemaining(t, storage, numbers[0]))
+	assert.Equal(t, int64(2000), lotRemaining(t, storage, numbers[1]))
+
+	// если партии заказа не хватает, остаток списывается по FIFO
+	require.NoError(t, storage.WriteWithdraw(context.Background(), model.OrderWithdraw{
+		Number:   fmt.Sprintf("%d", time.Now().UnixNano()),
+		Withdraw: -1500,
+	}, login, nil))
+	updateOrder(t, storage, numbers[1], model.OrderInvalid, 0)
+	requireBalance(t, storage, login, 8500)
+	assert.Equal(t, int64(8500), lotRemaining(t, storage, numbers[0]))
+	assert.Equal(t, int64(0), lotRemaining(t, storage, numbers[1]))
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+var (
+	createOrderStatusHistoryTable = `CREATE TABLE IF NOT EXISTS
+									 order_status_history(
+									   id         BIGSERIAL PRIMARY KEY,
+									   number     TEXT NOT NULL,
+									   status     TEXT NOT NULL,
+									   accrual    BIGINT NOT NULL DEFAULT 0,
+									   source     TEXT NOT NULL,
+									   changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+									 )`
+	createOrderStatusHistoryIndex = `CREATE INDEX IF NOT EXISTS order_status_history_number
+									 ON order_status_history(number, id)`
+
+	insertOrderStatusChange = `INSERT INTO order_status_history(number, status, accrual, source)
+							   VALUES($1, $2, $3, $4)`
+	selectUserOrder          = `SELECT status, accrual, time FROM orders WHERE number = $1 AND login = $2`
+	selectOrderStatusHistory = `SELECT status, accrual, source, changed_at
+								FROM order_status_history
+								WHERE number = $1
+								ORDER BY id`
+	selectOrderWithdrawal = `SELECT withdraw, time
+							 FROM ordersHistory
+							 WHERE number = $1 AND login = $2 AND type = 'withdrawal'`
+
+	insertOrderIfAbsent = `INSERT INTO orders(number, login, time, status, accrual) VALUES($1, $2, $3, 'NEW', 0)
+						   ON CONFLICT (number) DO NOTHING`
+)
+
+// Загрузить номера заказов в одной транзакции. Для каждого номера возвращается
+// nil, если заказ добавлен, ErrOrderExistsSameUser или ErrOrderExistsDiffUser
+func (db *DBStruct) AddOrders(ctx context.Context, numbers []string, login string) ([]error, error) {
+	results := make([]error, len(numbers))
+	added := false
+	t := time.Now().Format(time.RFC3339)
+
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	for i, number := range numbers {
+		tag, err := tx.Exec(ctx, insertOrderIfAbsent, number, login, t)
+		if err != nil {
+			db.log.Error(err.Error())
+			return nil, err
+		}
+		if tag.RowsAffected() == 1 {
+			_, err = tx.Exec(ctx, insertOrderStatusChange, number, model.OrderNew, 0, model.OrderSourceUpload)
+			if err != nil {
+				db.log.Error(err.Error())
+				return nil, err
+			}
+			added = true
+			continue
+		}
+
+		// номер уже загружен, в том числе ранее в этом же пакете
+		var user string
+		if err = tx.QueryRow(ctx, selectOrder, number).Scan(&user); err != nil {
+			db.log.Error(err.Error())
+			return nil, err
+		}
+		if user == login {
+			results[i] = model.ErrOrderExistsSameUser
+		} else {
+			results[i] = model.ErrOrderExistsDiffUser
+		}
+	}
+
+	if added {
+		if err = db.bumpVersion(ctx, tx, login); err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	db.log.WithFields(logrus.Fields{
+		"login":  login,
+		"orders": len(numbers),
+	}).Info("Загружен пакет заказов")
+	return results, nil
+}
+
+// Заказ пользователя с историей статусов и списанием по нему
+func (db *DBStruct) GetOrder(ctx context.Context, number string, login string) (model.OrderDetail, error) {
+	var accrual int64
+	var uploaded *string
+
+	order := model.OrderDetail{Number: number, History: []model.OrderStatusChange{}}
+	err := db.pgxPool.QueryRow(ctx, selectUserOrder, number, login).Scan(&order.Status, &accrual, &uploaded)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.OrderDetail{}, model.ErrOrderNotFound
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.OrderDetail{}, err
+	}
+	order.Accrual = float64(accrual) / 100
+	if uploaded != nil {
+		t, err := time.Parse(time.RFC3339, *uploaded)
+		if err != nil {
+			db.log.Error(err.Error())
+		}
+		order.UploadedAt = &t
+	}
+
+	rows, err := db.pgxPool.Query(ctx, selectOrderStatusHistory, number)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.OrderDetail{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var change model.OrderStatusChange
+		if err = rows.Scan(&change.Status, &accrual, &change.Source, &change.ChangedAt); err != nil {
+			db.log.Error(err.Error())
+			return model.OrderDetail{}, err
+		}
+		change.Accrual = float64(accrual) / 100
+		order.History = append(order.History, change)
+	}
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return model.OrderDetail{}, err
+	}
+
+	var withdraw int64
+	var withdrawn string
+	err = db.pgxPool.QueryRow(ctx, selectOrderWithdrawal, number, login).Scan(&withdraw, &withdrawn)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		db.log.Error(err.Error())
+		return model.OrderDetail{}, err
+	}
+	if err == nil {
+		order.Withdrawal = &model.OrderWithdraw{
+			Number:   number,
+			Withdraw: -float64(withdraw) / 100,
+		}
+		if order.Withdrawal.Time, err = time.Parse(time.RFC3339, withdrawn); err != nil {
+			db.log.Error(err.Error())
+		}
+	}
+	return order, nil
+}
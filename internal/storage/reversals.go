@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+var (
+	createReversalsTable = `CREATE TABLE IF NOT EXISTS
+							withdrawal_reversals(
+							  id           BIGSERIAL PRIMARY KEY,
+							  login        TEXT NOT NULL,
+							  number       TEXT NOT NULL,
+							  amount       BIGINT NOT NULL,
+							  reason       TEXT NOT NULL,
+							  initiated_by TEXT NOT NULL,
+							  created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+							)`
+	// партнер, проводивший списание по API ключу
+	alterHistoryPartner = `ALTER TABLE ordersHistory ADD COLUMN IF NOT EXISTS partner TEXT`
+	// отменяемое списание у корректировки, созданной отменой списания
+	alterAdjustmentsNumber = `ALTER TABLE adjustments ADD COLUMN IF NOT EXISTS number TEXT`
+
+	insertWithdrawalHistory = `INSERT INTO ordersHistory(number, withdraw, time, login, type, partner)
+							   VALUES($1, $2, $3, $4, 'withdrawal', $5)`
+	// списано (отрицательная сумма) и возвращено по номеру заказа,
+	// сколько списаний по номеру провел не партнер $3
+	selectWithdrawalState = `SELECT COUNT(*) FILTER (WHERE type = 'withdrawal'),
+									COALESCE(SUM(withdraw) FILTER (WHERE type = 'withdrawal'), 0),
+									COALESCE(SUM(withdraw) FILTER (WHERE type = 'reversal'), 0),
+									COUNT(*) FILTER (WHERE type = 'withdrawal' AND partner IS DISTINCT FROM $3)
+							 FROM ordersHistory
+							 WHERE login = $1 AND number = $2`
+	insertReversal = `INSERT INTO withdrawal_reversals(login, number, amount, reason, initiated_by)
+					  VALUES($1, $2, $3, $4, $5)
+					  RETURNING id`
+)
+
+// Отменить списание полностью (amount = 0) или частично, сумма в копейках.
+// Возврат проводится отдельной записью истории, вместе с прошлыми возвратами
+// он не может превысить списанную сумму. Крупная отмена администратором
+// сохраняется корректировкой, ожидающей утверждения вторым администратором
+func (db *DBStruct) ReverseWithdrawal(ctx context.Context, login string, number string, amount int64,
+	reason string, by model.ReversalInitiator) (model.Reversal, error) {
+
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Reversal{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// блокировка пользователя не дает параллельным возвратам превысить списание
+	if _, err = db.lockBalance(ctx, tx, login); err != nil {
+		return model.Reversal{}, err
+	}
+	remaining, err := db.withdrawalRemaining(ctx, tx, login, number, by.Partner)
+	if err != nil {
+		return model.Reversal{}, err
+	}
+	if amount == 0 {
+		amount = remaining
+	}
+	if err = db.checkReversal(number, amount, remaining); err != nil {
+		return model.Reversal{}, err
+	}
+
+	initiatedBy := by.Admin
+	if by.Partner != "" {
+		initiatedBy = "partner:" + by.Partner
+	}
+	var reversal model.Reversal
+	if by.Partner == "" && amount > by.ApprovalThreshold {
+		reversal, err = db.addPendingReversal(ctx, tx, login, number, amount, remaining, reason, initiatedBy)
+	} else {
+		reversal, err = db.applyReversal(ctx, tx, login, number, amount, remaining, reason, initiatedBy)
+	}
+	if err != nil {
+		return model.Reversal{}, err
+	}
+
+	db.log.WithFields(logrus.Fields{
+		"login":       login,
+		"number":      number,
+		"amount":      amount,
+		"initiatedBy": initiatedBy,
+		"status":      reversal.Status,
+	}).Info("Отмена списания")
+	return reversal, tx.Commit(ctx)
+}
+
+// Сколько еще можно вернуть по списанию, в копейках. Партнер может отменить
+// только списания, которые провел сам, для администратора partner пустой
+func (db *DBStruct) withdrawalRemaining(ctx context.Context, tx pgx.Tx, login string, number string,
+	partner string) (int64, error) {
+
+	var count, withdrawn, reversed, foreign int64
+	err := tx.QueryRow(ctx, selectWithdrawalState, login, number, partner).
+		Scan(&count, &withdrawn, &reversed, &foreign)
+	if err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+	if count == 0 {
+		return 0, model.ErrWithdrawalNotFound
+	}
+	if partner != "" && foreign > 0 {
+		db.log.WithFields(logrus.Fields{
+			"number":  number,
+			"partner": partner,
+		}).Error("Партнер отменяет чужое списание")
+		return 0, model.ErrForbidden
+	}
+	return -withdrawn - reversed, nil
+}
+
+func (db *DBStruct) checkReversal(number string, amount int64, remaining int64) error {
+	if amount <= 0 || amount > remaining {
+		db.log.WithFields(logrus.Fields{
+			"number":    number,
+			"amount":    amount,
+			"remaining": remaining,
+		}).Error(model.ErrReversalExceeded.Error())
+		return model.ErrReversalExceeded
+	}
+	return nil
+}
+
+// Вернуть amount копеек по списанию: запись истории, запись об отмене и события
+func (db *DBStruct) applyReversal(ctx context.Context, tx pgx.Tx, login string, number string,
+	amount int64, remaining int64, reason string, initiatedBy string) (model.Reversal, error) {
+
+	processedAt := time.Now()
+	if err := db.addReversal(ctx, tx, login, number, amount, processedAt); err != nil {
+		return model.Reversal{}, err
+	}
+
+	reversal := model.Reversal{
+		Number:      number,
+		Sum:         float64(amount) / 100,
+		Remaining:   float64(remaining-amount) / 100,
+		Reason:      reason,
+		InitiatedBy: initiatedBy,
+		Time:        processedAt,
+		Status:      model.ReversalApplied,
+	}
+	err := tx.QueryRow(ctx, insertReversal, login, number, amount, reason, initiatedBy).Scan(&reversal.ID)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Reversal{}, err
+	}
+	if err = db.addEvent(ctx, tx, login, model.EventWithdrawalReversed, reversal); err != nil {
+		return model.Reversal{}, err
+	}
+	if err = db.addBalanceEvent(ctx, tx, login); err != nil {
+		return model.Reversal{}, err
+	}
+	return reversal, nil
+}
+
+// Сохранить отмену списания корректировкой, ожидающей второго администратора.
+// Баланс не меняется до утверждения, остаток списания проверяется еще раз при утверждении
+func (db *DBStruct) addPendingReversal(ctx context.Context, tx pgx.Tx, login string, number string,
+	amount int64, remaining int64, reason string, admin string) (model.Reversal, error) {
+
+	var id int64
+	var createdAt time.Time
+	err := tx.QueryRow(ctx, insertAdjustment, login, amount, model.AdjustmentReasonReversal, reason,
+		model.AdjustmentPending, admin, number).Scan(&id, &createdAt)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Reversal{}, err
+	}
+	return model.Reversal{
+		Number:       number,
+		Sum:          float64(amount) / 100,
+		Remaining:    float64(remaining) / 100,
+		Reason:       reason,
+		InitiatedBy:  admin,
+		Time:         createdAt,
+		Status:       model.ReversalPending,
+		AdjustmentID: id,
+	}, nil
+}
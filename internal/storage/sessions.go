@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+var (
+	createSessionsTable = `CREATE TABLE IF NOT EXISTS
+						   sessions(
+							 id            TEXT PRIMARY KEY,
+							 login         TEXT NOT NULL,
+							 user_agent    TEXT NOT NULL,
+							 ip            TEXT NOT NULL,
+							 created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+							 last_seen     TIMESTAMPTZ NOT NULL DEFAULT now(),
+							 terminated_at TIMESTAMPTZ
+						   )`
+
+	insertSession = `INSERT INTO sessions(id, login, user_agent, ip) VALUES($1, $2, $3, $4)`
+	// сессия активна и время последней активности устарело
+	selectSessionStale = `SELECT last_seen < now() - make_interval(secs => $3)
+						  FROM sessions
+						  WHERE id = $1 AND login = $2 AND terminated_at IS NULL`
+	touchSession = `UPDATE sessions SET last_seen = now()
+					WHERE id = $1 AND login = $2 AND terminated_at IS NULL`
+	selectSessions = `SELECT id, user_agent, ip, created_at, last_seen
+					  FROM sessions
+					  WHERE login = $1 AND terminated_at IS NULL
+					  ORDER BY last_seen DESC`
+	terminateSession = `UPDATE sessions SET terminated_at = now()
+						WHERE id = $1 AND login = $2 AND terminated_at IS NULL`
+)
+
+func (db *DBStruct) AddSession(ctx context.Context, login string, session model.Session) error {
+	_, err := db.pgxPool.Exec(ctx, insertSession, session.ID, login, session.UserAgent, session.IP)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	db.log.WithFields(logrus.Fields{
+		"user": login,
+		"ip":   session.IP,
+	}).Info("Создана сессия")
+	return nil
+}
+
+// last_seen обновляется не чаще раза в sessionTouchInterval,
+// чтобы не писать в базу на каждый запрос
+const sessionTouchInterval = time.Minute
+
+// Отметить активность в сессии; ErrSessionNotFound - сессия завершена или не существует
+func (db *DBStruct) TouchSession(ctx context.Context, login string, id string) error {
+	var stale bool
+	err := db.pgxPool.QueryRow(ctx, selectSessionStale, id, login, sessionTouchInterval.Seconds()).Scan(&stale)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.ErrSessionNotFound
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if !stale {
+		return nil
+	}
+
+	tag, err := db.pgxPool.Exec(ctx, touchSession, id, login)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (db *DBStruct) GetSessions(ctx context.Context, login string) ([]model.Session, error) {
+	var sessions []model.Session
+
+	rows, err := db.pgxPool.Query(ctx, selectSessions, login)
+	if err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var session model.Session
+		err = rows.Scan(&session.ID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeen)
+		if err != nil {
+			db.log.Error(err.Error())
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (db *DBStruct) TerminateSession(ctx context.Context, login string, id string) error {
+	tag, err := db.pgxPool.Exec(ctx, terminateSession, id, login)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrSessionNotFound
+	}
+	db.log.WithFields(logrus.Fields{
+		"user":    login,
+		"session": id,
+	}).Info("Сессия завершена")
+	return nil
+}
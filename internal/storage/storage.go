@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"math"
 	"sort"
 	"time"
 
@@ -36,27 +37,100 @@ var (
 								time        TEXT
 							)`
 
-	insertUser = `INSERT INTO users(login, password) VALUES($1, $2)`
-	selectUser = `SELECT password FROM users WHERE login = $1`
+	alterUserRole    = `ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user'`
+	alterUserBlocked = `ALTER TABLE users ADD COLUMN IF NOT EXISTS blocked BOOLEAN NOT NULL DEFAULT false`
+
+	// записи истории привязываются к пользователю напрямую, а не через заказ,
+	// тип записи отличает начисления, списания и корректировки
+	alterHistoryID    = `ALTER TABLE ordersHistory ADD COLUMN IF NOT EXISTS id BIGSERIAL`
+	alterHistoryLogin = `ALTER TABLE ordersHistory ADD COLUMN IF NOT EXISTS login TEXT`
+	alterHistoryType  = `ALTER TABLE ordersHistory ADD COLUMN IF NOT EXISTS type TEXT`
+	fillHistoryLogin  = `UPDATE ordersHistory AS h SET login = o.login
+						 FROM orders AS o
+						 WHERE h.number = o.number AND h.login IS NULL`
+	fillHistoryType = `UPDATE ordersHistory
+					   SET type = CASE WHEN withdraw < 0 THEN 'withdrawal' ELSE 'accrual' END
+					   WHERE type IS NULL`
+
+	insertUser         = `INSERT INTO users(login, password) VALUES($1, $2)`
+	selectUser         = `SELECT password, role, blocked, totp_enabled FROM users WHERE login = $1`
+	updateUserPassword = `UPDATE users SET password = $1 WHERE login = $2`
 
 	selectOrder      = `SELECT login FROM orders WHERE number = $1`
 	selectUserOrders = `SELECT number, login, time, status, accrual FROM orders WHERE login = $1 AND time IS NOT NULL`
 	insertOrder      = `INSERT INTO orders(number, login, time, status, accrual) VALUES($1, $2, $3, 'NEW', 0)`
 
+	insertWithdrawOrder = `INSERT INTO orders(number, login, time, status, accrual) VALUES($1, $2, NULL, 'NEW', 0)
+						   ON CONFLICT (number) DO NOTHING`
+	selectUserForUpdate = `SELECT login FROM users WHERE login = $1 FOR UPDATE`
+
 	selectProcessingOrders = `SELECT number FROM orders WHERE status != $1 AND status != $2 AND time IS NOT NULL`
-	updateOrdersStatus     = `UPDATE orders SET status = $1, accrual = $2 WHERE number = $3`
-
-	addOrderHistory   = `INSERT INTO ordersHistory(number, withdraw, time) VALUES($1, $2, $3)`
-	selectUserHistory = `SELECT h.withdraw 
-						 FROM ordersHistory as h 
-						 JOIN orders AS o ON o.number = h.number 
-						 WHERE o.login = $1`
-	selectWithdrawHistory = `SELECT h.number, h.withdraw, h.time
-						 FROM ordersHistory as h 
-						 JOIN orders AS o ON h.number = o.number
-						 WHERE o.login = $1`
+	updateOrdersStatus     = `UPDATE orders SET status = $1, accrual = $2, credited = $3 WHERE number = $4`
+	selectOrderForUpdate   = `SELECT status, accrual, login,
+									 COALESCE(credited, CASE WHEN status = 'PROCESSED' THEN accrual ELSE 0 END)
+							  FROM orders WHERE number = $1 FOR UPDATE`
+
+	addOrderHistory = `INSERT INTO ordersHistory(number, withdraw, time, login, type, transfer_id)
+					   VALUES($1, $2, $3, $4, $5, $6)`
+	selectUserHistory = `SELECT withdraw, type
+						 FROM ordersHistory
+						 WHERE login = $1`
+	selectUserBalance     = `SELECT COALESCE(SUM(withdraw), 0) FROM ordersHistory WHERE login = $1`
+	selectWithdrawHistory = `SELECT h.number, h.withdraw, h.time, COALESCE(r.reversed, 0)
+						 FROM ordersHistory AS h
+						 LEFT JOIN (
+							SELECT number, SUM(withdraw) AS reversed
+							FROM ordersHistory
+							WHERE login = $1 AND type = 'reversal'
+							GROUP BY number
+						 ) AS r ON r.number = h.number
+						 WHERE h.login = $1 AND h.type = 'withdrawal'`
 )
 
+// выполняются по порядку при каждом запуске, поэтому должны быть идемпотентны
+var migrations = []string{
+	alterUserRole,
+	alterUserBlocked,
+	alterHistoryID,
+	alterHistoryLogin,
+	alterHistoryType,
+	fillHistoryLogin,
+	fillHistoryType,
+	createAdjustmentsTable,
+	createAPIKeysTable,
+	alterUserTOTPSecret,
+	alterUserTOTPEnabled,
+	alterUserTOTPStep,
+	createRecoveryCodesTable,
+	createSessionsTable,
+	createOrderStatusHistoryTable,
+	createOrderStatusHistoryIndex,
+	createEventsTable,
+	createEventsIndex,
+	alterUserDataVersion,
+	createHistoryLoginIndex,
+	createReversalsTable,
+	alterOrdersCredited,
+	createClawbacksTable,
+	createHoldsTable,
+	createHoldsIndex,
+	createLotsTable,
+	createLotsIndex,
+	fillLots,
+	createTransfersTable,
+	createTransfersSenderIndex,
+	alterHistoryTransfer,
+	createWithdrawalLimitsTable,
+	createLoginChallengesTable,
+	createOTPAttemptsTable,
+	alterHistoryPartner,
+	alterHoldsPartner,
+	alterAdjustmentsNumber,
+	createLotConsumptionsTable,
+	createLotConsumptionsIndex,
+	alterLotsTransfer,
+}
+
 type DBStruct struct {
 	pgxPool *pgxpool.Pool
 	log     *logrus.Logger
@@ -99,6 +173,14 @@ func InitConnection(ctx context.Context, connString string, log *logrus.Logger)
 		return nil, err
 	}
 
+	// изменения схемы существующих таблиц
+	for _, migration := range migrations {
+		if _, err = pgxPool.Exec(ctx, migration); err != nil {
+			log.Error(err.Error())
+			return nil, err
+		}
+	}
+
 	return pgxPool, nil
 }
 
@@ -123,16 +205,24 @@ func (db *DBStruct) AddUser(ctx context.Context, user model.User) error {
 	return err
 }
 
-func (db *DBStruct) AuthUser(ctx context.Context, user model.User) (string, error) {
-	var checkUser model.User
+func (db *DBStruct) AuthUser(ctx context.Context, user model.User) (model.User, error) {
+	checkUser := model.User{Login: user.Login}
 	row := db.pgxPool.QueryRow(ctx, selectUser, user.Login)
-	err := row.Scan(&checkUser.Password)
+	err := row.Scan(&checkUser.Password, &checkUser.Role, &checkUser.Blocked, &checkUser.TOTPEnabled)
 	if err != nil {
 		db.log.Error(err.Error())
-		return "", err
+		return model.User{}, err
 	}
 
-	return checkUser.Password, err
+	return checkUser, err
+}
+
+func (db *DBStruct) UpdatePassword(ctx context.Context, login string, password string) error {
+	_, err := db.pgxPool.Exec(ctx, updateUserPassword, password, login)
+	if err != nil {
+		db.log.Error(err.Error())
+	}
+	return err
 }
 
 func (db *DBStruct) AddOrder(ctx context.Context, number string, login string) error {
@@ -160,11 +250,32 @@ func (db *DBStruct) AddOrder(ctx context.Context, number string, login string) e
 		"login":  login,
 		"t":      t}).Info("Запись заказа в таблицу orderTable")
 
-	_, err = db.pgxPool.Exec(ctx, insertOrder, number, login, t)
+	tx, err := db.pgxPool.Begin(ctx)
 	if err != nil {
 		db.log.Error(err.Error())
+		return err
 	}
-	return err
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, insertOrder, number, login, t)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	// история статусов начинается с загрузки заказа
+	_, err = tx.Exec(ctx, insertOrderStatusChange, number, model.OrderNew, 0, model.OrderSourceUpload)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if err = db.bumpVersion(ctx, tx, login); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return nil
 }
 
 func (db *DBStruct) GetOrders(ctx context.Context, login string) ([]model.OrdersResponse, error) {
@@ -214,7 +325,7 @@ func (db *DBStruct) GetOrders(ctx context.Context, login string) ([]model.Orders
 
 	if orders == nil {
 		db.log.Info("в orders пусто")
-		return nil, errors.New("в orders пусто")
+		return nil, model.ErrNoOrders
 	}
 	// сортируем ответ по времени
 	sort.SliceStable(orders, func(i, j int) bool {
@@ -223,28 +334,89 @@ func (db *DBStruct) GetOrders(ctx context.Context, login string) ([]model.Orders
 	return orders, nil
 }
 
-func (db *DBStruct) WriteWithdraw(ctx context.Context, withdraw model.OrderWithdraw, login string) error {
+func (db *DBStruct) WriteWithdraw(ctx context.Context, withdraw model.OrderWithdraw, login string,
+	check model.WithdrawalCheck) error {
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// проверяем баланс под блокировкой, чтобы параллельные списания не увели его в минус,
+	// удержанные баллы списать нельзя
+	balance, held, err := db.lockAvailable(ctx, tx, login)
+	if err != nil {
+		return err
+	}
+	if balance-held+int64(withdraw.Withdraw) < 0 {
+		db.log.Error(model.ErrInsufficientBalance.Error())
+		return model.ErrInsufficientBalance
+	}
+	if err = db.checkWithdrawal(ctx, tx, login, check); err != nil {
+		return err
+	}
+
 	db.log.WithFields(logrus.Fields{
 		"number":   withdraw.Number,
 		"withdraw": withdraw.Withdraw,
 	}).Info("Запись в таблицу OrdersHistory")
 	// Добавляем запись списания в OrdersHistory
-	_, err := db.pgxPool.Exec(ctx, addOrderHistory, withdraw.Number, withdraw.Withdraw, time.Now().Format(time.RFC3339))
+	processedAt := time.Now()
+	err = db.addWithdrawal(ctx, tx, login, withdraw.Number, int64(withdraw.Withdraw), withdraw.Partner, processedAt)
 	if err != nil {
-		db.log.Error(err.Error())
+		return err
+	}
+	err = db.addEvent(ctx, tx, login, model.EventWithdrawalCreated, model.OrderWithdraw{
+		Number:   withdraw.Number,
+		Withdraw: -withdraw.Withdraw / 100,
+		Time:     processedAt,
+	})
+	if err != nil {
+		return err
+	}
+	if err = db.addBalanceEvent(ctx, tx, login); err != nil {
 		return err
 	}
 	db.log.WithFields(logrus.Fields{
 		"number": withdraw.Number,
 		"login":  login,
 	}).Info("Запись в таблицу orders")
-	_, err = db.pgxPool.Exec(ctx, insertOrder, withdraw.Number, login, nil)
+	_, err = tx.Exec(ctx, insertWithdrawOrder, withdraw.Number, login)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Заблокировать пользователя до конца транзакции
+func (db *DBStruct) lockUser(ctx context.Context, tx pgx.Tx, login string) error {
+	err := tx.QueryRow(ctx, selectUserForUpdate, login).Scan(&login)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.ErrUserNotFound
+	}
 	if err != nil {
 		db.log.Error(err.Error())
+		return err
 	}
 	return nil
 }
 
+// Заблокировать пользователя до конца транзакции и вернуть его баланс в копейках
+func (db *DBStruct) lockBalance(ctx context.Context, tx pgx.Tx, login string) (int64, error) {
+	var balance int64
+
+	if err := db.lockUser(ctx, tx, login); err != nil {
+		return 0, err
+	}
+	if err := tx.QueryRow(ctx, selectUserBalance, login).Scan(&balance); err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+	return balance, nil
+}
+
 func (db *DBStruct) GetOrdersForUpdate(ctx context.Context) ([]string, error) {
 	var orderNumber string
 	var orderNumbers []string
@@ -273,33 +445,93 @@ func (db *DBStruct) GetOrdersForUpdate(ctx context.Context) ([]string, error) {
 	return orderNumbers, nil
 }
 
-func (db *DBStruct) UpdateOrders(ctx context.Context, accrualSysResponse []model.PointsAppResponse) {
-	var accrual int32
-	// обновить статусы и баллы полученных в ответе заказов
-	batch := &pgx.Batch{}
-	for _, response := range accrualSysResponse {
+// Обновить заказы по ответам системы начислений в одной транзакции.
+// Изменения статуса записываются в историю, баланс приводится в соответствие
+// с итоговым начислением: PROCESSED зачитывает accrual, INVALID - ноль,
+// промежуточные статусы зачтенную сумму не меняют
+func (db *DBStruct) UpdateOrders(ctx context.Context, accrualSysResponse []model.PointsAppResponse, source string) error {
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// заказы блокируются в порядке номеров, чтобы параллельные обновления
+	// одних и тех же заказов не блокировали друг друга
+	responses := make([]model.PointsAppResponse, len(accrualSysResponse))
+	copy(responses, accrualSysResponse)
+	sort.Slice(responses, func(i, j int) bool { return responses[i].Number < responses[j].Number })
+
+	for _, response := range responses {
+		var status, login string
+		var accrual, credited int64
+
+		err = tx.QueryRow(ctx, selectOrderForUpdate, response.Number).Scan(&status, &accrual, &login, &credited)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+
 		// переводим в копейки
-		accrual = int32(response.Accrual * 100)
-		batch.Queue(updateOrdersStatus, response.Status, accrual, response.Number)
-		batch.Queue(addOrderHistory, response.Number, accrual, time.Now().Format(time.RFC3339))
+		newAccrual := int64(math.Round(response.Accrual * 100))
+		if status == response.Status && accrual == newAccrual {
+			continue
+		}
+		// блокировка пользователя до записи события, иначе событие может получить
+		// меньший id, чем уже зафиксированное событие этого пользователя
+		if err = db.lockUser(ctx, tx, login); err != nil {
+			return err
+		}
 		db.log.WithFields(logrus.Fields{
 			"number":  response.Number,
 			"status":  response.Status,
-			"accrual": accrual,
+			"accrual": newAccrual,
 		}).Info("Обновление заказа")
+
+		newCredited := credited
+		switch response.Status {
+		case model.OrderProcessed:
+			newCredited = newAccrual
+		case model.OrderInvalid:
+			newCredited = 0
+		}
+
+		_, err = tx.Exec(ctx, updateOrdersStatus, response.Status, newAccrual, newCredited, response.Number)
+		if err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+		_, err = tx.Exec(ctx, insertOrderStatusChange, response.Number, response.Status, newAccrual, source)
+		if err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+		err = db.addEvent(ctx, tx, login, model.EventOrderStatusChanged, model.OrderStatusEvent{
+			Number:  response.Number,
+			Status:  response.Status,
+			Accrual: float64(newAccrual) / 100,
+			Source:  source,
+		})
+		if err != nil {
+			return err
+		}
+		err = db.settleAccrual(ctx, tx, login, response.Number, response.Status, credited, newCredited)
+		if err != nil {
+			return err
+		}
 	}
-	batchReq := db.pgxPool.SendBatch(ctx, batch)
-	_, err := batchReq.Exec()
-	if err != nil {
-		db.log.Error(err.Error())
-	}
-	batchReq.Close()
+	return tx.Commit(ctx)
 }
 
 func (db *DBStruct) GetBalance(ctx context.Context, login string) (model.Balance, error) {
 	var balance model.Balance
 	var withdrawFloat float64
 	var withdraw int32
+	var historyType string
 
 	rows, err := db.pgxPool.Query(ctx, selectUserHistory, login)
 	if err != nil {
@@ -308,7 +540,7 @@ func (db *DBStruct) GetBalance(ctx context.Context, login string) (model.Balance
 	}
 	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(&withdraw)
+		err = rows.Scan(&withdraw, &historyType)
 		if err != nil {
 			db.log.Error(err.Error())
 			return model.Balance{}, err
@@ -316,7 +548,9 @@ func (db *DBStruct) GetBalance(ctx context.Context, login string) (model.Balance
 		withdrawFloat = float64(withdraw)
 		db.log.WithFields(logrus.Fields{"withdraw": withdraw}).Info("Баланс")
 		balance.Balance += withdrawFloat
-		if withdrawFloat < 0 {
+		// корректировки меняют баланс, но не считаются списаниями,
+		// возвраты уменьшают сумму списаний
+		if historyType == model.TxWithdrawal || historyType == model.TxReversal {
 			balance.Withdrawn += withdrawFloat
 		}
 	}
@@ -326,6 +560,13 @@ func (db *DBStruct) GetBalance(ctx context.Context, login string) (model.Balance
 		return model.Balance{}, rows.Err()
 	}
 
+	var held int64
+	if err = db.pgxPool.QueryRow(ctx, selectHeldAmount, login).Scan(&held); err != nil {
+		db.log.Error(err.Error())
+		return model.Balance{}, err
+	}
+	balance.Held = float64(held)
+
 	return balance, nil
 }
 
@@ -333,6 +574,7 @@ func (db *DBStruct) GetWithdrawals(ctx context.Context, login string) ([]model.O
 	var userWithdraw model.OrderWithdraw
 	var allWithdrawals []model.OrderWithdraw
 	var withdraw int32
+	var reversed int64
 	var timeUpl string
 
 	rows, err := db.pgxPool.Query(ctx, selectWithdrawHistory, login)
@@ -342,7 +584,7 @@ func (db *DBStruct) GetWithdrawals(ctx context.Context, login string) ([]model.O
 	}
 	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(&userWithdraw.Number, &withdraw, &timeUpl)
+		err = rows.Scan(&userWithdraw.Number, &withdraw, &timeUpl, &reversed)
 		if err != nil {
 			db.log.Error(err.Error())
 			return nil, err
@@ -357,6 +599,16 @@ func (db *DBStruct) GetWithdrawals(ctx context.Context, login string) ([]model.O
 		userWithdraw.Withdraw = float64(withdraw)
 		userWithdraw.Withdraw = -userWithdraw.Withdraw / 100
 
+		// отмененные полностью или частично списания
+		userWithdraw.Reversed = float64(reversed) / 100
+		userWithdraw.Status = ""
+		if reversed > 0 {
+			userWithdraw.Status = model.WithdrawalPartiallyReversed
+			if reversed >= -int64(withdraw) {
+				userWithdraw.Status = model.WithdrawalReversed
+			}
+		}
+
 		db.log.WithFields(logrus.Fields{
 			"number":   userWithdraw.Number,
 			"withdraw": userWithdraw.Withdraw,
@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+var (
+	alterUserTOTPSecret  = `ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT`
+	alterUserTOTPEnabled = `ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT false`
+	// последний использованный шаг TOTP, чтобы код нельзя было использовать повторно
+	alterUserTOTPStep = `ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0`
+
+	createRecoveryCodesTable = `CREATE TABLE IF NOT EXISTS
+								recovery_codes(
+									login   TEXT NOT NULL,
+									hash    TEXT NOT NULL,
+									used_at TIMESTAMPTZ,
+									PRIMARY KEY (login, hash)
+								)`
+
+	selectUserTOTP  = `SELECT COALESCE(totp_secret, ''), totp_enabled FROM users WHERE login = $1`
+	updateUserTOTP  = `UPDATE users SET totp_secret = $1, totp_enabled = false, totp_last_step = 0 WHERE login = $2`
+	enableUserTOTP  = `UPDATE users SET totp_enabled = true WHERE login = $1`
+	disableUserTOTP = `UPDATE users SET totp_secret = NULL, totp_enabled = false WHERE login = $1`
+	updateTOTPStep  = `UPDATE users SET totp_last_step = $1 WHERE login = $2 AND totp_last_step < $1`
+	deleteRecovery  = `DELETE FROM recovery_codes WHERE login = $1`
+	insertRecovery  = `INSERT INTO recovery_codes(login, hash) VALUES($1, $2)`
+	useRecoveryCode = `UPDATE recovery_codes SET used_at = now() WHERE login = $1 AND hash = $2 AND used_at IS NULL`
+
+	// токены первого шага входа одноразовые, неверные коды по токену считаются
+	createLoginChallengesTable = `CREATE TABLE IF NOT EXISTS
+								  login_challenges(
+									id         TEXT PRIMARY KEY,
+									login      TEXT NOT NULL,
+									failures   INT NOT NULL DEFAULT 0,
+									expires_at TIMESTAMPTZ NOT NULL,
+									used_at    TIMESTAMPTZ
+								  )`
+	// неверные коды пользователя подряд по всем токенам первого шага
+	createOTPAttemptsTable = `CREATE TABLE IF NOT EXISTS
+							  otp_attempts(
+								login        TEXT PRIMARY KEY,
+								failures     INT NOT NULL DEFAULT 0,
+								locked_until TIMESTAMPTZ
+							  )`
+
+	insertLoginChallenge = `INSERT INTO login_challenges(id, login, expires_at) VALUES($1, $2, $3)`
+	lockLoginChallenge   = `SELECT failures, used_at IS NULL AND expires_at > now()
+							FROM login_challenges WHERE id = $1 AND login = $2 FOR UPDATE`
+	failLoginChallenge         = `UPDATE login_challenges SET failures = failures + 1 WHERE id = $1`
+	closeLoginChallenge        = `UPDATE login_challenges SET used_at = now() WHERE id = $1`
+	closeLoginChallenges       = `UPDATE login_challenges SET used_at = now() WHERE login = $1 AND used_at IS NULL`
+	insertOTPAttempts          = `INSERT INTO otp_attempts(login) VALUES($1) ON CONFLICT (login) DO NOTHING`
+	selectOTPAttemptsForUpdate = `SELECT failures, COALESCE(locked_until > now(), false)
+								  FROM otp_attempts WHERE login = $1 FOR UPDATE`
+	updateOTPAttempts = `UPDATE otp_attempts SET failures = $1, locked_until = $2 WHERE login = $3`
+)
+
+// Сохранить новый секрет и хэши кодов восстановления, 2FA остается выключенной до подтверждения
+func (db *DBStruct) SetTOTP(ctx context.Context, login string, secret string, recoveryHashes []string) error {
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, updateUserTOTP, secret, login); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if _, err = tx.Exec(ctx, deleteRecovery, login); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		if _, err = tx.Exec(ctx, insertRecovery, login, hash); err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (db *DBStruct) GetTOTP(ctx context.Context, login string) (string, bool, error) {
+	var secret string
+	var enabled bool
+
+	err := db.pgxPool.QueryRow(ctx, selectUserTOTP, login).Scan(&secret, &enabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, model.ErrUserNotFound
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return "", false, err
+	}
+	return secret, enabled, nil
+}
+
+func (db *DBStruct) EnableTOTP(ctx context.Context, login string, enabled bool) error {
+	query := disableUserTOTP
+	if enabled {
+		query = enableUserTOTP
+	}
+	if _, err := db.pgxPool.Exec(ctx, query, login); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if !enabled {
+		if _, err := db.pgxPool.Exec(ctx, deleteRecovery, login); err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+	}
+	db.log.WithFields(logrus.Fields{
+		"user":    login,
+		"enabled": enabled,
+	}).Info("Изменена двухфакторная аутентификация")
+	return nil
+}
+
+// Отметить шаг TOTP использованным; false - код этого или более позднего шага уже был принят
+func (db *DBStruct) UseTOTPStep(ctx context.Context, login string, step int64) (bool, error) {
+	tag, err := db.pgxPool.Exec(ctx, updateTOTPStep, step, login)
+	if err != nil {
+		db.log.Error(err.Error())
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// Погасить код восстановления; false - кода нет или он уже использован
+func (db *DBStruct) UseRecoveryCode(ctx context.Context, login string, hash string) (bool, error) {
+	tag, err := db.pgxPool.Exec(ctx, useRecoveryCode, login, hash)
+	if err != nil {
+		db.log.Error(err.Error())
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (db *DBStruct) AddLoginChallenge(ctx context.Context, login string, id string, expiresAt time.Time) error {
+	if _, err := db.pgxPool.Exec(ctx, insertLoginChallenge, id, login, expiresAt); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// Проверить код по токену первого шага входа. Токен принимается один раз: после
+// верного кода или MaxFailures неверных он закрывается. После MaxFailures неверных
+// кодов подряд по любым токенам вход с 2FA блокируется на Lockout
+func (db *DBStruct) CompleteLoginChallenge(ctx context.Context, login string, id string,
+	limits model.OTPAttemptLimits, verify func() error) error {
+
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	userFailures, locked, err := db.lockOTPAttempts(ctx, tx, login)
+	if err != nil {
+		return err
+	}
+
+	var challengeFailures int
+	var active bool
+	err = tx.QueryRow(ctx, lockLoginChallenge, id, login).Scan(&challengeFailures, &active)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !active) {
+		db.log.WithFields(logrus.Fields{"user": login}).Error("Токен первого шага входа использован или истек")
+		return model.ErrAuthFailed
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if locked {
+		db.log.WithFields(logrus.Fields{"user": login}).Error(model.ErrTOTPLocked.Error())
+		return model.ErrTOTPLocked
+	}
+
+	verifyErr := verify()
+	if verifyErr == nil {
+		if _, err = tx.Exec(ctx, closeLoginChallenge, id); err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+		if _, err = tx.Exec(ctx, updateOTPAttempts, 0, nil, login); err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+	if !errors.Is(verifyErr, model.ErrInvalidOTP) {
+		return verifyErr
+	}
+
+	if _, err = tx.Exec(ctx, failLoginChallenge, id); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if limits.MaxFailures > 0 && challengeFailures+1 >= limits.MaxFailures {
+		if _, err = tx.Exec(ctx, closeLoginChallenge, id); err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+	}
+
+	if err = db.addOTPFailure(ctx, tx, login, userFailures, limits); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return verifyErr
+}
+
+// Проверить код второго фактора вне входа: подтверждение операции или отключение 2FA.
+// Неверные коды считаются вместе с кодами при входе, во время блокировки - ErrTOTPLocked
+func (db *DBStruct) VerifySecondFactor(ctx context.Context, login string, limits model.OTPAttemptLimits,
+	verify func() error) error {
+
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	failures, locked, err := db.lockOTPAttempts(ctx, tx, login)
+	if err != nil {
+		return err
+	}
+	if locked {
+		db.log.WithFields(logrus.Fields{"user": login}).Error(model.ErrTOTPLocked.Error())
+		return model.ErrTOTPLocked
+	}
+
+	verifyErr := verify()
+	if verifyErr == nil {
+		if _, err = tx.Exec(ctx, updateOTPAttempts, 0, nil, login); err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+		return tx.Commit(ctx)
+	}
+	if !errors.Is(verifyErr, model.ErrInvalidOTP) {
+		return verifyErr
+	}
+
+	if err = db.addOTPFailure(ctx, tx, login, failures, limits); err != nil {
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return verifyErr
+}
+
+// Заблокировать счетчик неверных кодов пользователя до конца транзакции, блокировка
+// упорядочивает параллельные проверки кодов. Возвращает число неверных кодов подряд
+// и действует ли блокировка
+func (db *DBStruct) lockOTPAttempts(ctx context.Context, tx pgx.Tx, login string) (int, bool, error) {
+	if _, err := tx.Exec(ctx, insertOTPAttempts, login); err != nil {
+		db.log.Error(err.Error())
+		return 0, false, err
+	}
+	var failures int
+	var locked bool
+	if err := tx.QueryRow(ctx, selectOTPAttemptsForUpdate, login).Scan(&failures, &locked); err != nil {
+		db.log.Error(err.Error())
+		return 0, false, err
+	}
+	return failures, locked, nil
+}
+
+// Учесть неверный код: после MaxFailures неверных кодов подряд проверка кодов
+// блокируется на Lockout
+func (db *DBStruct) addOTPFailure(ctx context.Context, tx pgx.Tx, login string, failures int,
+	limits model.OTPAttemptLimits) error {
+
+	failures++
+	var lockedUntil *time.Time
+	if limits.MaxFailures > 0 && failures >= limits.MaxFailures {
+		until := time.Now().Add(limits.Lockout)
+		lockedUntil = &until
+		failures = 0
+		// после блокировки нужно заново войти по паролю
+		if _, err := tx.Exec(ctx, closeLoginChallenges, login); err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+		db.log.WithFields(logrus.Fields{
+			"user":  login,
+			"until": until,
+		}).Warn("Проверка кодов 2FA заблокирована после неверных кодов")
+	}
+	if _, err := tx.Exec(ctx, updateOTPAttempts, failures, lockedUntil, login); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+var (
+	createHistoryLoginIndex = `CREATE INDEX IF NOT EXISTS ordershistory_login ON ordersHistory(login, id)`
+
+	// баланс после операции считается по всей истории пользователя,
+	// затем применяются фильтр по периоду и страница
+	selectTransactions = `SELECT id, type, withdraw, balance, COALESCE(number, ''), time,
+								 COALESCE(transfer_id, 0), COALESCE(counterparty, '') FROM (
+							SELECT h.id, h.type, h.withdraw, h.number, h.time, h.transfer_id,
+								   CASE WHEN tr.sender = h.login THEN tr.recipient ELSE tr.sender END AS counterparty,
+								   SUM(h.withdraw) OVER (ORDER BY h.id) AS balance
+							FROM ordersHistory AS h
+							LEFT JOIN transfers AS tr ON tr.id = h.transfer_id
+							WHERE h.login = $1
+						  ) AS t
+						  WHERE ($2::timestamptz IS NULL OR time::timestamptz >= $2)
+							AND ($3::timestamptz IS NULL OR time::timestamptz < $3)
+						  ORDER BY id
+						  LIMIT $4 OFFSET $5`
+	selectBalanceAt = `SELECT COALESCE(SUM(withdraw), 0),
+							   COALESCE(SUM(withdraw) FILTER (WHERE type IN ('withdrawal', 'reversal')), 0)
+						FROM ordersHistory
+						WHERE login = $1 AND time::timestamptz <= $2`
+	selectBalanceBefore = `SELECT COALESCE(SUM(withdraw), 0)
+						   FROM ordersHistory
+						   WHERE login = $1 AND time::timestamptz < $2`
+)
+
+// Операции по счету пользователя в порядке проведения
+func (db *DBStruct) GetTransactions(ctx context.Context, login string,
+	filter model.ListFilter) ([]model.Transaction, error) {
+
+	var transactions []model.Transaction
+
+	rows, err := db.pgxPool.Query(ctx, selectTransactions, login, filter.From, filter.To,
+		filter.Limit, filter.Offset)
+	if err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tx model.Transaction
+		var amount, balance int64
+		var processedAt string
+		err = rows.Scan(&tx.ID, &tx.Type, &amount, &balance, &tx.Order, &processedAt,
+			&tx.Transfer, &tx.Counterparty)
+		if err != nil {
+			db.log.Error(err.Error())
+			return nil, err
+		}
+		if tx.Time, err = time.Parse(time.RFC3339, processedAt); err != nil {
+			db.log.Error(err.Error())
+		}
+		// переводим из копеек
+		tx.Amount = float64(amount) / 100
+		tx.Balance = float64(balance) / 100
+		transactions = append(transactions, tx)
+	}
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// Баланс и сумма списаний в копейках на момент at, как в GetBalance
+func (db *DBStruct) GetBalanceAt(ctx context.Context, login string, at time.Time) (model.Balance, error) {
+	var current, withdrawn int64
+	if err := db.pgxPool.QueryRow(ctx, selectBalanceAt, login, at).Scan(&current, &withdrawn); err != nil {
+		db.log.Error(err.Error())
+		return model.Balance{}, err
+	}
+	return model.Balance{
+		Balance:   float64(current),
+		Withdrawn: float64(withdrawn),
+	}, nil
+}
+
+// Выписка за период: операции читаются построчно в одной транзакции
+// со снимком данных, поэтому баланс на начало и операции согласованы
+func (db *DBStruct) GetStatement(ctx context.Context, login string, filter model.ListFilter,
+	w model.StatementWriter) error {
+
+	tx, err := db.pgxPool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var balance int64
+	if filter.From != nil {
+		if err = tx.QueryRow(ctx, selectBalanceBefore, login, filter.From).Scan(&balance); err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+	}
+	if err = w.Opening(float64(balance) / 100); err != nil {
+		return err
+	}
+
+	// без ограничения страницы
+	rows, err := tx.Query(ctx, selectTransactions, login, filter.From, filter.To, nil, 0)
+	if err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t model.Transaction
+		var amount, after int64
+		var processedAt string
+		err = rows.Scan(&t.ID, &t.Type, &amount, &after, &t.Order, &processedAt, &t.Transfer, &t.Counterparty)
+		if err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+		if t.Time, err = time.Parse(time.RFC3339, processedAt); err != nil {
+			db.log.Error(err.Error())
+		}
+		balance += amount
+		t.Amount = float64(amount) / 100
+		t.Balance = float64(after) / 100
+		if err = w.Transaction(t); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return w.Closing(float64(balance) / 100)
+}
@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+var (
+	createTransfersTable = `CREATE TABLE IF NOT EXISTS
+							transfers(
+							  id          BIGSERIAL PRIMARY KEY,
+							  sender      TEXT NOT NULL,
+							  recipient   TEXT NOT NULL,
+							  amount      BIGINT NOT NULL,
+							  status      TEXT NOT NULL,
+							  created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+							  reversed_by TEXT,
+							  reversed_at TIMESTAMPTZ
+							)`
+	createTransfersSenderIndex = `CREATE INDEX IF NOT EXISTS transfers_sender_idx ON transfers(sender, created_at)`
+	// записи истории по переводу ссылаются на него, чтобы показать второго участника
+	alterHistoryTransfer = `ALTER TABLE ordersHistory ADD COLUMN IF NOT EXISTS transfer_id BIGINT`
+
+	lockUserBlocked  = `SELECT blocked FROM users WHERE login = $1 FOR UPDATE`
+	selectSentAmount = `SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM transfers
+						WHERE sender = $1 AND created_at > $2`
+	insertTransfer = `INSERT INTO transfers(sender, recipient, amount, status)
+					  VALUES($1, $2, $3, 'COMPLETED')
+					  RETURNING id, created_at`
+	selectTransfer = `SELECT sender, recipient, amount, status, created_at FROM transfers
+					  WHERE id = $1`
+	selectTransferForUpdate = selectTransfer + ` FOR UPDATE`
+	updateTransferReversed  = `UPDATE transfers SET status = 'REVERSED', reversed_by = $1, reversed_at = $2
+							   WHERE id = $3`
+)
+
+// Перевести amount копеек от sender к recipient. Оба пользователя блокируются
+// до конца транзакции, лимиты отправителя проверяются под блокировкой
+func (db *DBStruct) Transfer(ctx context.Context, sender string, recipient string, amount int64,
+	limits model.TransferLimits) (model.Transfer, error) {
+
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Transfer{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err = db.lockTransferUsers(ctx, tx, sender, recipient); err != nil {
+		return model.Transfer{}, err
+	}
+	balance, held, err := db.lockAvailable(ctx, tx, sender)
+	if err != nil {
+		return model.Transfer{}, err
+	}
+	if balance-held < amount {
+		db.log.Error(model.ErrInsufficientBalance.Error())
+		return model.Transfer{}, model.ErrInsufficientBalance
+	}
+
+	var count int
+	var sent int64
+	err = tx.QueryRow(ctx, selectSentAmount, sender, time.Now().Add(-24*time.Hour)).Scan(&count, &sent)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Transfer{}, err
+	}
+	if (limits.DailyCount > 0 && count+1 > limits.DailyCount) ||
+		(limits.DailyAmount > 0 && sent+amount > limits.DailyAmount) {
+		db.log.WithFields(logrus.Fields{
+			"sender": sender,
+			"count":  count,
+			"sent":   sent,
+		}).Error(model.ErrTransferLimit.Error())
+		return model.Transfer{}, model.ErrTransferLimit
+	}
+
+	transfer := model.Transfer{
+		Sender:    sender,
+		Recipient: recipient,
+		Sum:       float64(amount) / 100,
+		Status:    model.TransferCompleted,
+	}
+	err = tx.QueryRow(ctx, insertTransfer, sender, recipient, amount).Scan(&transfer.ID, &transfer.Time)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Transfer{}, err
+	}
+	err = db.movePoints(ctx, tx, transfer.ID, sender, recipient, amount,
+		model.TxTransferOut, model.TxTransferIn, transfer.Time)
+	if err != nil {
+		return model.Transfer{}, err
+	}
+
+	db.log.WithFields(logrus.Fields{
+		"id":        transfer.ID,
+		"sender":    sender,
+		"recipient": recipient,
+		"amount":    amount,
+	}).Info("Перевод баллов")
+	return transfer, tx.Commit(ctx)
+}
+
+// Отменить перевод: баллы возвращаются отправителю, если они еще есть
+// у получателя
+func (db *DBStruct) ReverseTransfer(ctx context.Context, id int64, admin string) (model.Transfer, error) {
+	tx, err := db.pgxPool.Begin(ctx)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Transfer{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// участники нужны, чтобы заблокировать их раньше перевода, как при его проведении
+	transfer := model.Transfer{ID: id}
+	var amount int64
+	err = tx.QueryRow(ctx, selectTransfer, id).
+		Scan(&transfer.Sender, &transfer.Recipient, &amount, &transfer.Status, &transfer.Time)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.Transfer{}, model.ErrTransferNotFound
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Transfer{}, err
+	}
+	for _, login := range sortedLogins(transfer.Sender, transfer.Recipient) {
+		if _, err = db.lockBalance(ctx, tx, login); err != nil {
+			return model.Transfer{}, err
+		}
+	}
+	err = tx.QueryRow(ctx, selectTransferForUpdate, id).
+		Scan(&transfer.Sender, &transfer.Recipient, &amount, &transfer.Status, &transfer.Time)
+	if err != nil {
+		db.log.Error(err.Error())
+		return model.Transfer{}, err
+	}
+	if transfer.Status == model.TransferReversed {
+		return model.Transfer{}, model.ErrTransferReversed
+	}
+
+	balance, held, err := db.lockAvailable(ctx, tx, transfer.Recipient)
+	if err != nil {
+		return model.Transfer{}, err
+	}
+	if balance-held < amount {
+		db.log.Error(model.ErrInsufficientBalance.Error())
+		return model.Transfer{}, model.ErrInsufficientBalance
+	}
+
+	reversedAt := time.Now()
+	if err = db.returnPoints(ctx, tx, id, transfer.Recipient, transfer.Sender, amount, reversedAt); err != nil {
+		return model.Transfer{}, err
+	}
+	if _, err = tx.Exec(ctx, updateTransferReversed, admin, reversedAt, id); err != nil {
+		db.log.Error(err.Error())
+		return model.Transfer{}, err
+	}
+
+	transfer.Sum = float64(amount) / 100
+	transfer.Status = model.TransferReversed
+	transfer.ReversedBy = admin
+	transfer.ReversedAt = &reversedAt
+	db.log.WithFields(logrus.Fields{
+		"id":    id,
+		"admin": admin,
+	}).Info("Перевод отменен")
+	return transfer, tx.Commit(ctx)
+}
+
+// Заблокировать отправителя и получателя в порядке логинов, чтобы встречные
+// переводы не заблокировали друг друга
+func (db *DBStruct) lockTransferUsers(ctx context.Context, tx pgx.Tx, sender string, recipient string) error {
+	for _, login := range sortedLogins(sender, recipient) {
+		var blocked bool
+		err := tx.QueryRow(ctx, lockUserBlocked, login).Scan(&blocked)
+		if errors.Is(err, pgx.ErrNoRows) {
+			if login == recipient {
+				return model.ErrRecipientNotFound
+			}
+			return model.ErrUserNotFound
+		}
+		if err != nil {
+			db.log.Error(err.Error())
+			return err
+		}
+		if blocked && login == recipient {
+			return model.ErrRecipientBlocked
+		}
+	}
+	return nil
+}
+
+func sortedLogins(logins ...string) []string {
+	sort.Strings(logins)
+	return logins
+}
+
+// Провести баллы от from к to записями истории обоих пользователей.
+// Партии переходят к получателю с исходной датой начисления, поэтому
+// перевод не продлевает срок сгорания баллов
+func (db *DBStruct) movePoints(ctx context.Context, tx pgx.Tx, transferID int64, from string, to string,
+	amount int64, outType string, inType string, processedAt time.Time) error {
+
+	if err := db.addTransferHistory(ctx, tx, transferID, from, to, amount, outType, inType, processedAt); err != nil {
+		return err
+	}
+
+	lots, err := db.consumeLots(ctx, tx, from, amount)
+	if err != nil {
+		return err
+	}
+	// израсходованные партии отправителя запоминаются для отмены перевода
+	if err = db.addConsumptions(ctx, tx, from, "", transferID, lots); err != nil {
+		return err
+	}
+	for _, lot := range lots {
+		lot.transferID = transferID
+		if err = db.addLot(ctx, tx, to, lot); err != nil {
+			return err
+		}
+		amount -= lot.amount
+	}
+	// баллы, не разнесенные по партиям, переходят несгораемой партией
+	if amount > 0 {
+		lot := pointLot{amount: amount, accruedAt: processedAt, transferID: transferID}
+		if err = db.addLot(ctx, tx, to, lot); err != nil {
+			return err
+		}
+	}
+
+	if err = db.addBalanceEvent(ctx, tx, from); err != nil {
+		return err
+	}
+	return db.addBalanceEvent(ctx, tx, to)
+}
+
+// Вернуть баллы по отмененному переводу от получателя from отправителю to.
+// У получателя сначала забираются полученные переводом партии, а уже израсходованная
+// их часть - из остальных партий по порядку. Отправителю возвращаются израсходованные
+// переводом партии с исходной датой начисления
+func (db *DBStruct) returnPoints(ctx context.Context, tx pgx.Tx, transferID int64, from string, to string,
+	amount int64, processedAt time.Time) error {
+
+	err := db.addTransferHistory(ctx, tx, transferID, from, to, amount,
+		model.TxTransferReversal, model.TxTransferReversal, processedAt)
+	if err != nil {
+		return err
+	}
+	if _, err = db.consumeLotsOf(ctx, tx, from, "", transferID, amount); err != nil {
+		return err
+	}
+	if err = db.restoreLots(ctx, tx, to, "", transferID, amount, processedAt); err != nil {
+		return err
+	}
+
+	if err = db.addBalanceEvent(ctx, tx, from); err != nil {
+		return err
+	}
+	return db.addBalanceEvent(ctx, tx, to)
+}
+
+// Записи истории перевода у обоих участников
+func (db *DBStruct) addTransferHistory(ctx context.Context, tx pgx.Tx, transferID int64, from string, to string,
+	amount int64, outType string, inType string, processedAt time.Time) error {
+
+	at := processedAt.Format(time.RFC3339)
+	if _, err := tx.Exec(ctx, addOrderHistory, nil, -amount, at, from, outType, transferID); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	if _, err := tx.Exec(ctx, addOrderHistory, nil, amount, at, to, inType, transferID); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+// Версия данных пользователя увеличивается при каждом изменении его заказов,
+// баланса или списаний и служит для ETag ответов без их формирования
+var (
+	alterUserDataVersion  = `ALTER TABLE users ADD COLUMN IF NOT EXISTS data_version BIGINT NOT NULL DEFAULT 0`
+	bumpUserDataVersion   = `UPDATE users SET data_version = data_version + 1 WHERE login = $1`
+	selectUserDataVersion = `SELECT data_version FROM users WHERE login = $1`
+)
+
+// выполняет запрос в транзакции или на пуле соединений
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+}
+
+func (db *DBStruct) bumpVersion(ctx context.Context, q execer, login string) error {
+	if _, err := q.Exec(ctx, bumpUserDataVersion, login); err != nil {
+		db.log.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+func (db *DBStruct) GetDataVersion(ctx context.Context, login string) (int64, error) {
+	var version int64
+	err := db.pgxPool.QueryRow(ctx, selectUserDataVersion, login).Scan(&version)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, model.ErrUserNotFound
+	}
+	if err != nil {
+		db.log.Error(err.Error())
+		return 0, err
+	}
+	return version, nil
+}
@@ -0,0 +1,41 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Тестовые векторы из приложения B RFC 6238 (SHA1), последние 6 цифр
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).
+		EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		name string
+		time int64
+		want string
+	}{
+		{name: "59", time: 59, want: "287082"},
+		{name: "1111111109", time: 1111111109, want: "081804"},
+		{name: "1111111111", time: 1111111111, want: "050471"},
+		{name: "1234567890", time: 1234567890, want: "005924"},
+		{name: "2000000000", time: 2000000000, want: "279037"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(secret, Step(time.Unix(tt.time, 0)))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, code)
+
+			_, ok := Validate(secret, tt.want, time.Unix(tt.time+Period, 0))
+			assert.True(t, ok)
+			_, ok = Validate(secret, tt.want, time.Unix(tt.time+3*Period, 0))
+			assert.False(t, ok)
+		})
+	}
+}
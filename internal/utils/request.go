@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+)
+
+// Проверить тип тела запроса без учета параметров,
+// например application/json; charset=utf-8
+func HasContentType(r *http.Request, want string) bool {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mt == want
+}
+
+// Строго разобрать JSON из тела запроса: неизвестные поля и несколько
+// значений верхнего уровня - ошибка запроса. Превышение размера тела
+// возвращается как model.ErrBodyTooLarge
+func DecodeJSON(body io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return requestError(err)
+	}
+
+	// после значения в теле не должно быть ничего, кроме пробелов
+	if _, err := decoder.Token(); err != io.EOF {
+		if err != nil {
+			return requestError(err)
+		}
+		return fmt.Errorf("%w: unexpected data after JSON value", model.ErrWrongRequest)
+	}
+	return nil
+}
+
+func requestError(err error) error {
+	if errors.Is(err, model.ErrBodyTooLarge) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", model.ErrWrongRequest, err)
+}
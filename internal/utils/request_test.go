@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kartalenka7/project_gophermart/internal/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr error
+	}{
+		{name: "valid", body: `{"order": "2377225624", "sum": 751}`},
+		{name: "trailing whitespace", body: "{\"order\": \"2377225624\", \"sum\": 751}\n"},
+		{name: "unknown field", body: `{"order": "2377225624", "sum": 751, "login": "admin"}`, wantErr: model.ErrWrongRequest},
+		{name: "two values", body: `{"order": "1"} {"order": "2"}`, wantErr: model.ErrWrongRequest},
+		{name: "trailing garbage", body: `{"order": "1"}}`, wantErr: model.ErrWrongRequest},
+		{name: "empty body", body: ``, wantErr: model.ErrWrongRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var withdraw model.OrderWithdraw
+			err := DecodeJSON(strings.NewReader(tt.body), &withdraw)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestHasContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{contentType: "application/json", want: true},
+		{contentType: "application/json; charset=utf-8", want: true},
+		{contentType: "Application/JSON", want: true},
+		{contentType: "application/jsonp", want: false},
+		{contentType: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", nil)
+			r.Header.Set("Content-Type", tt.contentType)
+			assert.Equal(t, tt.want, HasContentType(r, "application/json"))
+		})
+	}
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"math"
 	"net/http"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/kartalenka7/project_gophermart/internal/model"
@@ -53,13 +54,48 @@ func Round(x float64, prec int) float64 {
 }
 
 //Создать новый токен JWT для учётной записи
-func AddAuthoriztionHeader(rw http.ResponseWriter, user model.User) error {
-	tk := &model.Token{Login: user.Login}
-	token := jwt.NewWithClaims(jwt.GetSigningMethod("HS256"), tk)
-	tokenString, err := token.SignedString([]byte("secret"))
+func AddAuthoriztionHeader(rw http.ResponseWriter, user model.User, sessionID string) (string, error) {
+	tokenString, err := NewToken(user, sessionID)
 	if err != nil {
-		return err
+		return "", err
 	}
 	rw.Header().Add("Authorization", tokenString)
-	return nil
+	return tokenString, nil
+}
+
+// Подписать токен JWT с логином, ролью пользователя и сессией
+func NewToken(user model.User, sessionID string) (string, error) {
+	return signToken(&model.Token{Login: user.Login, Role: user.Role, SessionID: sessionID})
+}
+
+// Короткоживущий токен первого шага входа с двухфакторной аутентификацией,
+// id связывает токен с записью в базе, по которой считаются попытки ввода кода
+func NewChallengeToken(login string, id string, ttl time.Duration) (string, time.Time, error) {
+	expiresAt := time.Now().Add(ttl)
+	tokenString, err := signToken(&model.Token{
+		Login:          login,
+		Purpose:        model.TokenPurpose2FA,
+		StandardClaims: jwt.StandardClaims{Id: id, ExpiresAt: expiresAt.Unix()},
+	})
+	return tokenString, expiresAt, err
+}
+
+// Проверить подпись и срок действия токена
+func ParseToken(tokenString string) (*model.Token, error) {
+	tk := &model.Token{}
+	token, err := jwt.ParseWithClaims(tokenString, tk, func(token *jwt.Token) (interface{}, error) {
+		return []byte("secret"), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, model.ErrNotAuthorized
+	}
+	return tk, nil
+}
+
+func signToken(tk *model.Token) (string, error) {
+	token := jwt.NewWithClaims(jwt.GetSigningMethod("HS256"), tk)
+	return token.SignedString([]byte("secret"))
 }
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,6 +17,7 @@ import (
 	"github.com/kartalenka7/project_gophermart/internal/model"
 	"github.com/kartalenka7/project_gophermart/internal/service"
 	"github.com/kartalenka7/project_gophermart/internal/storage"
+	"github.com/kartalenka7/project_gophermart/internal/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -62,14 +65,17 @@ func TestUserRegstr(t *testing.T) {
 	}
 
 	log := logger.InitLog()
-	cfg, err := config.GetConfig(log)
-	require.NoError(t, err)
+	cfg := testConfig(t, log)
+	// ответы, нарушающие спецификацию API, вернутся с 500
+	cfg.OpenAPIValidation = true
+	cfg.OpenAPIValidateResponses = true
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 	storage, err := storage.NewStorage(ctx, cfg.Database, log)
 	require.NoError(t, err)
-	service := service.NewService(ctx, storage, log, cfg.AccrualSys)
-	router := handlers.NewRouter(service, log)
+	service, err := service.NewService(ctx, storage, log, cfg)
+	require.NoError(t, err)
+	router := handlers.NewRouter(service, log, cfg)
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -141,14 +147,17 @@ func TestOrders(t *testing.T) {
 	}
 
 	log := logger.InitLog()
-	cfg, err := config.GetConfig(log)
-	require.NoError(t, err)
+	cfg := testConfig(t, log)
+	// ответы, нарушающие спецификацию API, вернутся с 500
+	cfg.OpenAPIValidation = true
+	cfg.OpenAPIValidateResponses = true
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 	storage, err := storage.NewStorage(ctx, cfg.Database, log)
 	require.NoError(t, err)
-	service := service.NewService(ctx, storage, log, cfg.AccrualSys)
-	router := handlers.NewRouter(service, log)
+	service, err := service.NewService(ctx, storage, log, cfg)
+	require.NoError(t, err)
+	router := handlers.NewRouter(service, log, cfg)
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -207,3 +216,834 @@ func TestOrders(t *testing.T) {
 		})
 	}
 }
+
+var (
+	testCfgOnce sync.Once
+	testCfg     config.Config
+	testCfgErr  error
+)
+
+// флаги командной строки можно определить только один раз на процесс
+func testConfig(t *testing.T, log *logrus.Logger) config.Config {
+	testCfgOnce.Do(func() {
+		testCfg, testCfgErr = config.GetConfig(log)
+	})
+	require.NoError(t, testCfgErr)
+	return testCfg
+}
+
+// хранилище для проверок, которым нужна база
+func newTestStorage(t *testing.T) *storage.DBStruct {
+	log := logger.InitLog()
+	cfg := testConfig(t, log)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	st, err := storage.NewStorage(ctx, cfg.Database, log)
+	require.NoError(t, err)
+	t.Cleanup(st.Close)
+	return st
+}
+
+// база сохраняется между запусками, поэтому логины уникальны
+func newTestUser(t *testing.T, st *storage.DBStruct, prefix string) string {
+	login := fmt.Sprintf("%s-%d", prefix, time.Now().UnixNano())
+	require.NoError(t, st.AddUser(context.Background(), model.User{Login: login, Password: "hash"}))
+	return login
+}
+
+// начислить пользователю баллы корректировкой, сумма в копейках
+func creditPoints(t *testing.T, st *storage.DBStruct, login string, amount int64) {
+	_, err := st.AddAdjustment(context.Background(), model.Adjustment{
+		Login:     login,
+		Reason:    "OTHER",
+		Comment:   "test",
+		CreatedBy: "test-admin",
+	}, amount, true)
+	require.NoError(t, err)
+}
+
+func TestDecideAdjustment(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "adjusted")
+
+	adj, err := st.AddAdjustment(ctx, model.Adjustment{
+		Login:     login,
+		Reason:    "COMPENSATION",
+		Comment:   "lost receipt",
+		CreatedBy: "admin1",
+	}, 500000, false)
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentPending, adj.Status)
+
+	// до утверждения баланс не меняется
+	balance, err := st.GetBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), balance.Balance)
+
+	_, err = st.DecideAdjustment(ctx, adj.ID, "admin1", true)
+	assert.ErrorIs(t, err, model.ErrSameApprover)
+
+	adj, err = st.DecideAdjustment(ctx, adj.ID, "admin2", true)
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentApplied, adj.Status)
+	assert.Equal(t, "admin2", adj.ApprovedBy)
+
+	_, err = st.DecideAdjustment(ctx, adj.ID, "admin3", false)
+	assert.ErrorIs(t, err, model.ErrAdjustmentDecided)
+
+	balance, err = st.GetBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, float64(500000), balance.Balance)
+
+	// отклонить свою корректировку автор может
+	adj, err = st.AddAdjustment(ctx, model.Adjustment{
+		Login:     login,
+		Reason:    "OTHER",
+		Comment:   "typo",
+		CreatedBy: "admin1",
+	}, -100000, false)
+	require.NoError(t, err)
+	adj, err = st.DecideAdjustment(ctx, adj.ID, "admin1", false)
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentRejected, adj.Status)
+
+	_, err = st.DecideAdjustment(ctx, -1, "admin2", true)
+	assert.ErrorIs(t, err, model.ErrAdjustmentNotFound)
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	prefix := fmt.Sprintf("%x", time.Now().UnixNano())
+
+	key, err := st.AddAPIKey(ctx, model.APIKey{
+		Prefix:      prefix,
+		Partner:     "pos",
+		Permissions: []string{model.PermOrdersWrite},
+	}, "hash")
+	require.NoError(t, err)
+
+	found, hash, err := st.GetAPIKey(ctx, prefix)
+	require.NoError(t, err)
+	assert.Equal(t, "hash", hash)
+	assert.Equal(t, []string{model.PermOrdersWrite}, found.Permissions)
+
+	require.NoError(t, st.RevokeAPIKey(ctx, key.ID))
+	_, _, err = st.GetAPIKey(ctx, prefix)
+	assert.ErrorIs(t, err, model.ErrAPIKeyNotFound)
+}
+
+func TestLoginChallengeLockout(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "otp")
+	limits := model.OTPAttemptLimits{MaxFailures: 3, Lockout: time.Hour}
+
+	var verified int
+	valid := func() error {
+		verified++
+		return nil
+	}
+	invalid := func() error {
+		verified++
+		return model.ErrInvalidOTP
+	}
+	newChallenge := func(ttl time.Duration) string {
+		id := fmt.Sprintf("%s-%d", login, time.Now().UnixNano())
+		require.NoError(t, st.AddLoginChallenge(ctx, login, id, time.Now().Add(ttl)))
+		return id
+	}
+
+	// верный код сбрасывает счетчик, токен второй раз не принимается
+	first := newChallenge(time.Minute)
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, st.CompleteLoginChallenge(ctx, login, first, limits, invalid), model.ErrInvalidOTP)
+	}
+	require.NoError(t, st.CompleteLoginChallenge(ctx, login, first, limits, valid))
+	assert.ErrorIs(t, st.CompleteLoginChallenge(ctx, login, first, limits, valid), model.ErrAuthFailed)
+
+	// чужой и истекший токены не принимаются
+	other := newChallenge(time.Minute)
+	assert.ErrorIs(t, st.CompleteLoginChallenge(ctx, "someone-else", other, limits, valid), model.ErrAuthFailed)
+	expired := newChallenge(-time.Second)
+	assert.ErrorIs(t, st.CompleteLoginChallenge(ctx, login, expired, limits, valid), model.ErrAuthFailed)
+
+	// неверные коды подряд закрывают токен и блокируют вход с 2FA
+	second := newChallenge(time.Minute)
+	for i := 0; i < limits.MaxFailures; i++ {
+		assert.ErrorIs(t, st.CompleteLoginChallenge(ctx, login, second, limits, invalid), model.ErrInvalidOTP)
+	}
+	assert.ErrorIs(t, st.CompleteLoginChallenge(ctx, login, second, limits, valid), model.ErrAuthFailed)
+	assert.ErrorIs(t, st.CompleteLoginChallenge(ctx, login, other, limits, valid), model.ErrAuthFailed)
+
+	// новый вход по паролю не снимает блокировку, код при этом не проверяется
+	verified = 0
+	third := newChallenge(time.Minute)
+	assert.ErrorIs(t, st.CompleteLoginChallenge(ctx, login, third, limits, valid), model.ErrTOTPLocked)
+	assert.Zero(t, verified)
+}
+
+func TestSecondFactorLockout(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "otp-step-up")
+	limits := model.OTPAttemptLimits{MaxFailures: 3, Lockout: time.Hour}
+
+	var verified int
+	valid := func() error {
+		verified++
+		return nil
+	}
+	invalid := func() error {
+		verified++
+		return model.ErrInvalidOTP
+	}
+
+	// верный код сбрасывает счетчик
+	for i := 0; i < limits.MaxFailures-1; i++ {
+		assert.ErrorIs(t, st.VerifySecondFactor(ctx, login, limits, invalid), model.ErrInvalidOTP)
+	}
+	require.NoError(t, st.VerifySecondFactor(ctx, login, limits, valid))
+
+	// неверные коды при подтверждении операций и при входе считаются вместе
+	id := fmt.Sprintf("%s-%d", login, time.Now().UnixNano())
+	require.NoError(t, st.AddLoginChallenge(ctx, login, id, time.Now().Add(time.Minute)))
+	assert.ErrorIs(t, st.CompleteLoginChallenge(ctx, login, id, limits, invalid), model.ErrInvalidOTP)
+	for i := 0; i < limits.MaxFailures-1; i++ {
+		assert.ErrorIs(t, st.VerifySecondFactor(ctx, login, limits, invalid), model.ErrInvalidOTP)
+	}
+
+	// во время блокировки код не проверяется ни при подтверждении, ни при входе
+	verified = 0
+	assert.ErrorIs(t, st.VerifySecondFactor(ctx, login, limits, valid), model.ErrTOTPLocked)
+	id = fmt.Sprintf("%s-%d", login, time.Now().UnixNano())
+	require.NoError(t, st.AddLoginChallenge(ctx, login, id, time.Now().Add(time.Minute)))
+	assert.ErrorIs(t, st.CompleteLoginChallenge(ctx, login, id, limits, valid), model.ErrTOTPLocked)
+	assert.Zero(t, verified)
+}
+
+func TestTerminateSession(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "sessions")
+
+	for _, id := range []string{login + "-a", login + "-b"} {
+		require.NoError(t, st.AddSession(ctx, login, model.Session{ID: id, UserAgent: "test", IP: "127.0.0.1"}))
+	}
+	require.NoError(t, st.TouchSession(ctx, login, login+"-a"))
+	assert.ErrorIs(t, st.TouchSession(ctx, "someone-else", login+"-a"), model.ErrSessionNotFound)
+
+	sessions, err := st.GetSessions(ctx, login)
+	require.NoError(t, err)
+	assert.Len(t, sessions, 2)
+
+	require.NoError(t, st.TerminateSession(ctx, login, login+"-b"))
+	assert.ErrorIs(t, st.TerminateSession(ctx, login, login+"-b"), model.ErrSessionNotFound)
+	assert.ErrorIs(t, st.TouchSession(ctx, login, login+"-b"), model.ErrSessionNotFound)
+
+	sessions, err = st.GetSessions(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, login+"-a", sessions[0].ID)
+}
+
+// уникальный номер заказа с верной контрольной цифрой Луна
+func newOrderNumber() string {
+	digits := fmt.Sprintf("%d", time.Now().UnixNano())
+	for check := 0; ; check++ {
+		if number := fmt.Sprintf("%s%d", digits, check); utils.CheckLuhnAlg(number) {
+			return number
+		}
+	}
+}
+
+func TestAddOrdersBatch(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "batch")
+	other := newTestUser(t, st, "batch-other")
+
+	owned := newOrderNumber()
+	require.NoError(t, st.AddOrder(ctx, owned, other))
+	fresh := newOrderNumber()
+
+	// повтор номера внутри пакета - дубликат, а не ошибка пакета
+	results, err := st.AddOrders(ctx, []string{fresh, fresh, owned}, login)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.NoError(t, results[0])
+	assert.ErrorIs(t, results[1], model.ErrOrderExistsSameUser)
+	assert.ErrorIs(t, results[2], model.ErrOrderExistsDiffUser)
+
+	orders, err := st.GetOrders(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, fresh, orders[0].Number)
+}
+
+func TestOrderHistoryStartsWithUpload(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "history")
+
+	single := newOrderNumber()
+	require.NoError(t, st.AddOrder(ctx, single, login))
+	batch := newOrderNumber()
+	_, err := st.AddOrders(ctx, []string{batch}, login)
+	require.NoError(t, err)
+
+	for _, number := range []string{single, batch} {
+		order, err := st.GetOrder(ctx, number, login)
+		require.NoError(t, err)
+		require.Len(t, order.History, 1)
+		assert.Equal(t, model.OrderNew, order.History[0].Status)
+		assert.Equal(t, model.OrderSourceUpload, order.History[0].Source)
+	}
+}
+
+func TestUpdateOrdersEventOrder(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "events")
+	first, second := newOrderNumber(), newOrderNumber()
+	_, err := st.AddOrders(ctx, []string{first, second}, login)
+	require.NoError(t, err)
+
+	// параллельные обновления заказов одного пользователя
+	var wg sync.WaitGroup
+	for _, number := range []string{first, second} {
+		wg.Add(1)
+		go func(number string) {
+			defer wg.Done()
+			assert.NoError(t, st.UpdateOrders(ctx, []model.PointsAppResponse{
+				{Number: number, Status: model.OrderProcessed, Accrual: 10},
+			}, model.OrderSourcePoll))
+		}(number)
+	}
+	wg.Wait()
+
+	events, err := st.GetEvents(ctx, login, 0, 100)
+	require.NoError(t, err)
+	// по событию смены статуса и изменения баланса на каждый заказ
+	require.Len(t, events, 4)
+	for i := 1; i < len(events); i++ {
+		assert.Less(t, events[i-1].ID, events[i].ID)
+	}
+	assert.Equal(t, model.EventOrderStatusChanged, events[0].Type)
+	assert.Equal(t, model.EventBalanceChanged, events[1].Type)
+	assert.Equal(t, model.EventOrderStatusChanged, events[2].Type)
+	assert.Equal(t, model.EventBalanceChanged, events[3].Type)
+
+	balance, err := st.GetBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, float64(2000), balance.Balance)
+}
+
+func TestGetBalanceAt(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "balance-at")
+
+	before := time.Now().Add(-time.Minute)
+	creditPoints(t, st, login, 10000)
+	between := time.Now()
+	// время операций хранится с точностью до секунды
+	time.Sleep(1100 * time.Millisecond)
+	creditPoints(t, st, login, 5000)
+
+	balance, err := st.GetBalanceAt(ctx, login, before)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), balance.Balance)
+
+	balance, err = st.GetBalanceAt(ctx, login, between)
+	require.NoError(t, err)
+	assert.Equal(t, float64(10000), balance.Balance)
+
+	balance, err = st.GetBalanceAt(ctx, login, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, float64(15000), balance.Balance)
+	assert.Equal(t, float64(0), balance.Withdrawn)
+}
+
+// списать с баланса пользователя amount копеек по новому заказу,
+// partner - партнер, проводящий списание по API ключу
+func withdrawPoints(t *testing.T, st *storage.DBStruct, login string, amount int64, partner string) string {
+	number := newOrderNumber()
+	err := st.WriteWithdraw(context.Background(), model.OrderWithdraw{
+		Number:   number,
+		Withdraw: -float64(amount),
+		Partner:  partner,
+	}, login, nil)
+	require.NoError(t, err)
+	return number
+}
+
+func TestReverseWithdrawal(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	shop := model.ReversalInitiator{Partner: "shop"}
+
+	// начислено 500 рублей, списано 300
+	tests := []struct {
+		name          string
+		partner       string
+		amounts       []int64
+		by            model.ReversalInitiator
+		wantErr       error
+		wantStatus    string
+		wantRemaining float64
+		wantBalance   float64
+	}{
+		{name: "full", partner: "shop", amounts: []int64{0}, by: shop,
+			wantStatus: model.ReversalApplied, wantRemaining: 0, wantBalance: 50000},
+		{name: "partial", partner: "shop", amounts: []int64{10000, 5000}, by: shop,
+			wantStatus: model.ReversalApplied, wantRemaining: 150, wantBalance: 35000},
+		{name: "more than remaining", partner: "shop", amounts: []int64{20000, 20000}, by: shop,
+			wantErr: model.ErrReversalExceeded},
+		{name: "other partner", partner: "pos", amounts: []int64{0}, by: shop,
+			wantErr: model.ErrForbidden},
+		{name: "user withdrawal", amounts: []int64{0}, by: shop,
+			wantErr: model.ErrForbidden},
+		{name: "admin below threshold", partner: "pos", amounts: []int64{10000},
+			by:         model.ReversalInitiator{Admin: "admin1", ApprovalThreshold: 10000},
+			wantStatus: model.ReversalApplied, wantRemaining: 200, wantBalance: 30000},
+		{name: "admin above threshold", partner: "pos", amounts: []int64{0},
+			by:         model.ReversalInitiator{Admin: "admin1", ApprovalThreshold: 10000},
+			wantStatus: model.ReversalPending, wantRemaining: 300, wantBalance: 20000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login := newTestUser(t, st, "reversal")
+			creditPoints(t, st, login, 50000)
+			number := withdrawPoints(t, st, login, 30000, tt.partner)
+
+			var reversal model.Reversal
+			var err error
+			for _, amount := range tt.amounts {
+				reversal, err = st.ReverseWithdrawal(ctx, login, number, amount, "order cancelled", tt.by)
+				if err != nil {
+					break
+				}
+			}
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, reversal.Status)
+			assert.Equal(t, tt.wantRemaining, reversal.Remaining)
+
+			balance, err := st.GetBalance(ctx, login)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBalance, balance.Balance)
+		})
+	}
+
+	_, err := st.ReverseWithdrawal(ctx, newTestUser(t, st, "reversal"), newOrderNumber(), 0, "", shop)
+	assert.ErrorIs(t, err, model.ErrWithdrawalNotFound)
+}
+
+func TestApproveReversal(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "reversal-approval")
+	creditPoints(t, st, login, 50000)
+	number := withdrawPoints(t, st, login, 30000, "")
+	admin := model.ReversalInitiator{Admin: "admin1", ApprovalThreshold: 10000}
+
+	pending, err := st.ReverseWithdrawal(ctx, login, number, 20000, "refund", admin)
+	require.NoError(t, err)
+	require.Equal(t, model.ReversalPending, pending.Status)
+
+	// отмена ждет второго администратора как корректировка
+	_, err = st.DecideAdjustment(ctx, pending.AdjustmentID, "admin1", true)
+	assert.ErrorIs(t, err, model.ErrSameApprover)
+
+	// пока отмена ждала, часть суммы вернули, остатка уже не хватает
+	_, err = st.ReverseWithdrawal(ctx, login, number, 15000, "", admin)
+	assert.ErrorIs(t, err, model.ErrReversalExceeded)
+	partial, err := st.ReverseWithdrawal(ctx, login, number, 10000, "", admin)
+	require.NoError(t, err)
+	require.Equal(t, model.ReversalApplied, partial.Status)
+	_, err = st.DecideAdjustment(ctx, pending.AdjustmentID, "admin2", true)
+	assert.ErrorIs(t, err, model.ErrReversalExceeded)
+
+	// утвержденная отмена проводится как обычная отмена списания
+	pending, err = st.ReverseWithdrawal(ctx, login, number, 0, "refund", admin)
+	require.NoError(t, err)
+	require.Equal(t, model.ReversalPending, pending.Status)
+	adj, err := st.DecideAdjustment(ctx, pending.AdjustmentID, "admin2", true)
+	require.NoError(t, err)
+	assert.Equal(t, model.AdjustmentApplied, adj.Status)
+	assert.Equal(t, number, adj.Number)
+
+	balance, err := st.GetBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, float64(50000), balance.Balance)
+	assert.Equal(t, float64(0), balance.Withdrawn)
+
+	withdrawals, err := st.GetWithdrawals(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	assert.Equal(t, model.WithdrawalReversed, withdrawals[0].Status)
+}
+
+// удержать баллы пользователя под новый заказ, сумма в копейках
+func holdPoints(t *testing.T, st *storage.DBStruct, login string, amount int64, expiresAt time.Time,
+	check model.WithdrawalCheck) (model.Hold, error) {
+	return st.CreateHold(context.Background(), login, newOrderNumber(), "", amount, expiresAt, check)
+}
+
+// лимит списаний за сутки в копейках для списания amount
+func dailyLimit(amount int64, max int64) model.WithdrawalCheck {
+	return func(usage model.WithdrawalUsage) error {
+		if usage.Daily+amount > max {
+			return model.ErrWithdrawalLimit
+		}
+		return nil
+	}
+}
+
+func TestHoldReducesAvailable(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "hold")
+	creditPoints(t, st, login, 10000)
+
+	hold, err := holdPoints(t, st, login, 3000, time.Now().Add(time.Hour), nil)
+	require.NoError(t, err)
+
+	// удержание не меняет баланс, но уменьшает доступную сумму
+	balance, err := st.GetBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, float64(10000), balance.Balance)
+	assert.Equal(t, float64(3000), balance.Held)
+
+	_, err = holdPoints(t, st, login, 7001, time.Now().Add(time.Hour), nil)
+	assert.ErrorIs(t, err, model.ErrInsufficientBalance)
+	err = st.WriteWithdraw(ctx, model.OrderWithdraw{Number: newOrderNumber(), Withdraw: -7001}, login, nil)
+	assert.ErrorIs(t, err, model.ErrInsufficientBalance)
+	withdrawPoints(t, st, login, 7000, "")
+
+	captured, err := st.CaptureHold(ctx, login, hold.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.HoldCaptured, captured.Status)
+	balance, err = st.GetBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), balance.Balance)
+	assert.Equal(t, float64(0), balance.Held)
+
+	_, err = st.VoidHold(ctx, login, hold.ID)
+	assert.ErrorIs(t, err, model.ErrHoldNotActive)
+}
+
+func TestCaptureHoldAfterBalanceDrop(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "hold-drop")
+	creditPoints(t, st, login, 10000)
+
+	hold, err := holdPoints(t, st, login, 6000, time.Now().Add(time.Hour), nil)
+	require.NoError(t, err)
+
+	// списание администратором не может забрать удержанные баллы
+	debit := model.Adjustment{Login: login, Reason: "FRAUD", Comment: "test", CreatedBy: "admin1"}
+	_, err = st.AddAdjustment(ctx, debit, -5000, true)
+	assert.ErrorIs(t, err, model.ErrInsufficientBalance)
+	pending, err := st.AddAdjustment(ctx, debit, -5000, false)
+	require.NoError(t, err)
+	_, err = st.DecideAdjustment(ctx, pending.ID, "admin2", true)
+	assert.ErrorIs(t, err, model.ErrInsufficientBalance)
+	_, err = st.AddAdjustment(ctx, debit, -4000, true)
+	require.NoError(t, err)
+
+	// баланс уменьшился, но удержание по-прежнему можно провести
+	captured, err := st.CaptureHold(ctx, login, hold.ID)
+	require.NoError(t, err)
+	assert.Equal(t, model.HoldCaptured, captured.Status)
+	balance, err := st.GetBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), balance.Balance)
+	assert.Equal(t, float64(0), balance.Held)
+}
+
+func TestExpiredHoldBeforeSweep(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "hold-expired")
+	creditPoints(t, st, login, 10000)
+
+	// срок удержания уже прошел, но воркер его еще не снял
+	hold, err := holdPoints(t, st, login, 4000, time.Now().Add(-time.Second), nil)
+	require.NoError(t, err)
+
+	balance, err := st.GetBalance(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), balance.Held)
+
+	_, err = st.CaptureHold(ctx, login, hold.ID)
+	assert.ErrorIs(t, err, model.ErrHoldNotActive)
+	_, err = st.VoidHold(ctx, login, hold.ID)
+	assert.ErrorIs(t, err, model.ErrHoldNotActive)
+
+	// просроченное удержание не уменьшает доступную сумму и не считается в лимитах
+	err = st.WriteWithdraw(ctx, model.OrderWithdraw{Number: newOrderNumber(), Withdraw: -10000},
+		login, dailyLimit(10000, 10000))
+	require.NoError(t, err)
+
+	expired, err := st.ExpireHolds(ctx)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, expired, int64(1))
+	_, err = st.CaptureHold(ctx, login, hold.ID)
+	assert.ErrorIs(t, err, model.ErrHoldNotActive)
+}
+
+func TestTransferDailyLimits(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	recipient := newTestUser(t, st, "transfer-to")
+
+	tests := []struct {
+		name    string
+		limits  model.TransferLimits
+		amounts []int64
+		wantErr error
+		wantIn  float64
+	}{
+		{name: "count", limits: model.TransferLimits{DailyCount: 2},
+			amounts: []int64{1000, 1000, 1000}, wantErr: model.ErrTransferLimit, wantIn: 2000},
+		{name: "amount", limits: model.TransferLimits{DailyAmount: 5000},
+			amounts: []int64{3000, 2500}, wantErr: model.ErrTransferLimit, wantIn: 3000},
+		{name: "amount up to limit", limits: model.TransferLimits{DailyAmount: 5000},
+			amounts: []int64{3000, 2000}, wantIn: 5000},
+		{name: "no limits", amounts: []int64{4000, 4000, 2000}, wantIn: 10000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := newTestUser(t, st, "transfer-from")
+			creditPoints(t, st, sender, 10000)
+			before, err := st.GetBalance(ctx, recipient)
+			require.NoError(t, err)
+
+			for _, amount := range tt.amounts {
+				if _, err = st.Transfer(ctx, sender, recipient, amount, tt.limits); err != nil {
+					break
+				}
+			}
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			after, err := st.GetBalance(ctx, recipient)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantIn, after.Balance-before.Balance)
+			balance, err := st.GetBalance(ctx, sender)
+			require.NoError(t, err)
+			assert.Equal(t, 10000-tt.wantIn, balance.Balance)
+		})
+	}
+}
+
+func TestReverseTransferSpent(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	sender := newTestUser(t, st, "transfer-from")
+	recipient := newTestUser(t, st, "transfer-to")
+	creditPoints(t, st, sender, 5000)
+
+	transfer, err := st.Transfer(ctx, sender, recipient, 5000, model.TransferLimits{})
+	require.NoError(t, err)
+
+	// получатель уже потратил часть баллов
+	withdrawPoints(t, st, recipient, 3000, "")
+	_, err = st.ReverseTransfer(ctx, transfer.ID, "admin1")
+	assert.ErrorIs(t, err, model.ErrInsufficientBalance)
+
+	// удержанные баллы тоже недоступны для возврата
+	creditPoints(t, st, recipient, 3000)
+	_, err = holdPoints(t, st, recipient, 1000, time.Now().Add(time.Hour), nil)
+	require.NoError(t, err)
+	_, err = st.ReverseTransfer(ctx, transfer.ID, "admin1")
+	assert.ErrorIs(t, err, model.ErrInsufficientBalance)
+
+	// неудавшаяся отмена перевод не меняет
+	creditPoints(t, st, recipient, 1000)
+	reversed, err := st.ReverseTransfer(ctx, transfer.ID, "admin1")
+	require.NoError(t, err)
+	assert.Equal(t, model.TransferReversed, reversed.Status)
+	_, err = st.ReverseTransfer(ctx, transfer.ID, "admin1")
+	assert.ErrorIs(t, err, model.ErrTransferReversed)
+
+	balance, err := st.GetBalance(ctx, sender)
+	require.NoError(t, err)
+	assert.Equal(t, float64(5000), balance.Balance)
+	balance, err = st.GetBalance(ctx, recipient)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1000), balance.Balance)
+}
+
+func TestTransferLockOrdering(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	first := newTestUser(t, st, "transfer-lock")
+	second := newTestUser(t, st, "transfer-lock")
+	creditPoints(t, st, first, 10000)
+	creditPoints(t, st, second, 10000)
+
+	// встречные переводы, списания и отмены одних и тех же пользователей
+	// не должны взаимно блокироваться
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		// номер заказа зависит от времени, поэтому выбирается до запуска горутин
+		number := newOrderNumber()
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			_, err := st.Transfer(ctx, first, second, 100, model.TransferLimits{})
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			transfer, err := st.Transfer(ctx, second, first, 100, model.TransferLimits{})
+			if assert.NoError(t, err) {
+				_, err = st.ReverseTransfer(ctx, transfer.ID, "admin1")
+				assert.NoError(t, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			err := st.WriteWithdraw(ctx, model.OrderWithdraw{Number: number, Withdraw: -100}, first, nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	balance, err := st.GetBalance(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, float64(10000-10*100-10*100), balance.Balance)
+	balance, err = st.GetBalance(ctx, second)
+	require.NoError(t, err)
+	assert.Equal(t, float64(10000+10*100), balance.Balance)
+}
+
+func TestHoldsCountTowardLimits(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "hold-limits")
+	creditPoints(t, st, login, 10000)
+
+	hold, err := holdPoints(t, st, login, 4000, time.Now().Add(time.Hour), dailyLimit(4000, 5000))
+	require.NoError(t, err)
+
+	// удержанная сумма входит в суточный лимит
+	_, err = holdPoints(t, st, login, 2000, time.Now().Add(time.Hour), dailyLimit(2000, 5000))
+	assert.ErrorIs(t, err, model.ErrWithdrawalLimit)
+	err = st.WriteWithdraw(ctx, model.OrderWithdraw{Number: newOrderNumber(), Withdraw: -2000},
+		login, dailyLimit(2000, 5000))
+	assert.ErrorIs(t, err, model.ErrWithdrawalLimit)
+
+	// после списания удержания в лимите учитывается уже списание, а не удержание
+	_, err = st.CaptureHold(ctx, login, hold.ID)
+	require.NoError(t, err)
+	err = st.WriteWithdraw(ctx, model.OrderWithdraw{Number: newOrderNumber(), Withdraw: -1000},
+		login, dailyLimit(1000, 5000))
+	require.NoError(t, err)
+	err = st.WriteWithdraw(ctx, model.OrderWithdraw{Number: newOrderNumber(), Withdraw: -1000},
+		login, dailyLimit(1000, 5000))
+	assert.ErrorIs(t, err, model.ErrWithdrawalLimit)
+
+	// отмененное удержание в лимите не учитывается
+	other := newTestUser(t, st, "hold-limits")
+	creditPoints(t, st, other, 10000)
+	hold, err = holdPoints(t, st, other, 4000, time.Now().Add(time.Hour), dailyLimit(4000, 5000))
+	require.NoError(t, err)
+	_, err = st.VoidHold(ctx, other, hold.ID)
+	require.NoError(t, err)
+	err = st.WriteWithdraw(ctx, model.OrderWithdraw{Number: newOrderNumber(), Withdraw: -5000},
+		other, dailyLimit(5000, 5000))
+	require.NoError(t, err)
+}
+
+// начислить пользователю баллы по обработанному заказу, такие баллы сгорают
+func accruePoints(t *testing.T, st *storage.DBStruct, login string, accrual float64) {
+	ctx := context.Background()
+	number := newOrderNumber()
+	require.NoError(t, st.AddOrder(ctx, number, login))
+	require.NoError(t, st.UpdateOrders(ctx, []model.PointsAppResponse{
+		{Number: number, Status: model.OrderProcessed, Accrual: accrual},
+	}, model.OrderSourcePoll))
+}
+
+func TestReversalRestoresLots(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	login := newTestUser(t, st, "reversal-lots")
+	accruePoints(t, st, login, 50)
+	accruedAfter := time.Now()
+	number := withdrawPoints(t, st, login, 5000, "shop")
+
+	expiring, err := st.GetExpiringPoints(ctx, login, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), expiring)
+
+	// возвращенные баллы снова сгорают в срок исходного начисления
+	_, err = st.ReverseWithdrawal(ctx, login, number, 2000, "", model.ReversalInitiator{Partner: "shop"})
+	require.NoError(t, err)
+	_, err = st.ReverseWithdrawal(ctx, login, number, 0, "", model.ReversalInitiator{Partner: "shop"})
+	require.NoError(t, err)
+	expiring, err = st.GetExpiringPoints(ctx, login, accruedAfter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5000), expiring)
+}
+
+func TestReverseTransferRestoresLots(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	sender := newTestUser(t, st, "transfer-lots")
+	recipient := newTestUser(t, st, "transfer-lots")
+	accruePoints(t, st, sender, 50)
+	accruedAfter := time.Now()
+	// у получателя свои несгораемые баллы, он расходует их первыми
+	creditPoints(t, st, recipient, 5000)
+
+	transfer, err := st.Transfer(ctx, sender, recipient, 5000, model.TransferLimits{})
+	require.NoError(t, err)
+	_, err = st.ReverseTransfer(ctx, transfer.ID, "admin1")
+	require.NoError(t, err)
+
+	// отправителю вернулась партия начисления, а не партия получателя
+	expiring, err := st.GetExpiringPoints(ctx, sender, accruedAfter)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5000), expiring)
+	balance, err := st.GetBalance(ctx, sender)
+	require.NoError(t, err)
+	assert.Equal(t, float64(5000), balance.Balance)
+}
+
+func TestReverseTransferTakesTransferredLots(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStorage(t)
+	sender := newTestUser(t, st, "transfer-back")
+	recipient := newTestUser(t, st, "transfer-back")
+	// у получателя более старая сгораемая партия, отправитель переводит несгораемые баллы
+	accruePoints(t, st, recipient, 50)
+	creditPoints(t, st, sender, 5000)
+
+	transfer, err := st.Transfer(ctx, sender, recipient, 5000, model.TransferLimits{})
+	require.NoError(t, err)
+	_, err = st.ReverseTransfer(ctx, transfer.ID, "admin1")
+	require.NoError(t, err)
+
+	// отмена забирает у получателя переведенную партию, его начисление остается
+	expiring, err := st.GetExpiringPoints(ctx, recipient, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5000), expiring)
+	expiring, err = st.GetExpiringPoints(ctx, sender, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), expiring)
+}